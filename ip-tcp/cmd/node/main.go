@@ -1,48 +1,228 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io"
 	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
+	bgp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/bgp"
+	ctl "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ctl"
 	data "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/data"
 	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	metrics "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/metrics"
+	script "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/script"
 	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
 	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
 )
 
-// main parses args and runs the node.
+// main runs the node and exits with the status it returns.
 func main() {
+	os.Exit(run())
+}
+
+// run parses args and runs the node, returning the exit status.
+func run() int {
 	// initialize logger
 	log.SetFlags(0)
 	// Set up CLI flags.
 	var aggFlag bool
 	flag.BoolVar(&aggFlag, "agg", false, "Turn on route aggregation.")
-	var debug bool
-	flag.BoolVar(&debug, "debug", false, "Turn on debug message printing.")
-	flag.BoolVar(&debug, "d", false, "Turn on debug message printing.")
+	var lsFlag bool
+	flag.BoolVar(&lsFlag, "ls", false, "Use link-state routing instead of RIP.")
+	var logSpec string
+	flag.StringVar(&logSpec, "log", "", "Log levels, e.g. tcp=debug,rip=warn; a bare level applies to every other subsystem.")
+	var logFormat string
+	flag.StringVar(&logFormat, "log-format", "text", "Log message format, text or json.")
+	var logFile string
+	flag.StringVar(&logFile, "log-file", "", "Write log messages to this file instead of stdout.")
+	var bfdFlag bool
+	flag.BoolVar(&bfdFlag, "bfd", false, "Turn on BFD neighbour failure detection.")
+	var bfdInterval time.Duration
+	flag.DurationVar(&bfdInterval, "bfd-interval", util.BFD_DEFAULT_INTERVAL, "Interval between BFD control packets.")
+	var bfdMult uint
+	flag.UintVar(&bfdMult, "bfd-mult", uint(util.BFD_DEFAULT_MULT), "Number of missed BFD packets before a neighbour is declared down.")
+	var routeLog string
+	flag.StringVar(&routeLog, "route-log", "", "Write a timestamped log of routing table changes to this file.")
+	var ctlPath string
+	flag.StringVar(&ctlPath, "ctl", "", "Serve the control API on a Unix socket at this path.")
+	var metricsAddr string
+	flag.StringVar(&metricsAddr, "metrics", "", "Serve Prometheus metrics over HTTP at this address, e.g. :9100 for localhost port 9100.")
+	var stateFile string
+	flag.StringVar(&stateFile, "state", "", "Save learned routes to this file, and restore them on startup.")
+	var stateInterval time.Duration
+	flag.DurationVar(&stateInterval, "state-interval", util.STATE_SAVE_INTERVAL, "Interval between saves of the state file.")
+	var printConfig bool
+	flag.BoolVar(&printConfig, "print-config", false, "Print the configuration as JSON and exit, e.g. to convert an lnx file.")
+	var scriptFile string
+	flag.StringVar(&scriptFile, "script", "", "Run the commands in this file instead of reading stdin, then exit.")
+	var ccName string
+	flag.StringVar(&ccName, "cc", "", "Congestion control for new TCP connections: reno, cubic or bbr.")
 	flag.Parse()
-	// Enable Debugging mode
-	util.InitDebug(debug)
+	// Set up logging.
+	if err := util.ConfigureLogging(logSpec); err != nil {
+		log.Printf("Error in -log: %v\n", err)
+		return 1
+	}
+	logOut := io.Writer(os.Stdout)
+	if logFile != "" {
+		file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			log.Printf("Error opening log file: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		logOut = file
+	}
+	if err := util.SetLogOutput(logOut, logFormat); err != nil {
+		log.Printf("Error in -log-format: %v\n", err)
+		return 1
+	}
 	// node <linkfile>
 	args := flag.Args()
 	if len(args) < 1 {
-		log.Println("usage: ./node <linkfile>")
-		return
+		log.Println("usage: ./node [flags] <linkfile or config.json>")
+		return 1
+	}
+	// Open the script up front so a typo fails fast.
+	var scenario *os.File
+	if scriptFile != "" {
+		file, err := os.Open(scriptFile)
+		if err != nil {
+			log.Printf("Error opening script: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		scenario = file
+	}
+	// Read the configuration, either JSON or a legacy lnx file.
+	var cfg *ip.Config
+	var err error
+	if strings.HasSuffix(args[0], ".json") {
+		cfg, err = ip.LoadConfig(args[0])
+	} else {
+		cfg, err = ip.ParseLnx(args[0])
+	}
+	if err != nil {
+		log.Printf("Error reading config: %v\n", err)
+		return 1
+	}
+	// Print the configuration instead of running, to convert lnx files to JSON.
+	if printConfig {
+		data, _ := json.MarshalIndent(cfg, "", "  ")
+		fmt.Println(string(data))
+		return 0
 	}
-	// Parse the linkfile.
-	linkfile := args[0]
-	node, err := ip.NewNode(linkfile)
+	node, err := ip.NewNodeFromConfig(cfg)
 	if err != nil {
-		log.Println("Error reading lnx file, exiting")
-		return
+		log.Printf("Error creating node: %v\n", err)
+		return 1
+	}
+	defer node.Close()
+	// Log routing table changes.
+	if routeLog != "" {
+		file, err := os.Create(routeLog)
+		if err != nil {
+			log.Printf("Error opening route log: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		node.LogRouteEvents(file)
+	}
+	// Flags turn on route aggregation and link-state on top of the configuration.
+	if aggFlag {
+		node.SetAggregate(true)
+	}
+	if lsFlag {
+		node.SetLinkState(true)
+	}
+	// Set up BFD.
+	if bfdFlag {
+		if bfdInterval <= 0 || bfdMult == 0 || bfdMult > 255 {
+			log.Println("invalid BFD interval or multiplier")
+			return 1
+		}
+		node.SetBFD(bfdInterval, uint8(bfdMult))
+	}
+	// Restore routes saved by a previous run, and save them on shutdown.
+	if stateFile != "" {
+		if stateInterval <= 0 {
+			log.Println("invalid state interval")
+			return 1
+		}
+		if err := node.SetStateFile(stateFile, stateInterval); err != nil {
+			log.Printf("Error reading state file: %v\n", err)
+			return 1
+		}
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-signals
+			node.SaveState()
+			os.Exit(0)
+		}()
+		defer node.SaveState()
 	}
-	// Set Route Aggregation.
-	node.SetAggregate(aggFlag)
 	// Register protocol handlers.
 	node.RegisterHandler(0, data.DataHandler)
 	driver := tcp.InitDriver(node)
+	defer driver.Close()
+	if ccName != "" {
+		if err := driver.SetCongestionControl(ccName); err != nil {
+			log.Printf("Error in -cc: %v\n", err)
+			return 1
+		}
+	}
 	node.RegisterHandler(6, driver.TCPHandler)
 	// Run the server
 	node.Run(false)
+	// Start BGP if the lnx file gave us an AS number.
+	if node.ASN != 0 {
+		speaker, err := bgp.NewSpeaker(node, driver)
+		if err == nil {
+			err = speaker.Start()
+		}
+		if err != nil {
+			log.Printf("Error starting BGP: %v\n", err)
+			return 1
+		}
+	}
+	// Serve the control API.
+	if ctlPath != "" {
+		server := ctl.NewServer(node, driver)
+		if err := server.Listen(ctlPath); err != nil {
+			log.Printf("Error starting control socket: %v\n", err)
+			return 1
+		}
+		defer server.Close()
+	}
+	// Serve metrics.
+	if metricsAddr != "" {
+		server, err := metrics.Serve(metricsAddr, node, driver)
+		if err != nil {
+			log.Printf("Error starting metrics server: %v\n", err)
+			return 1
+		}
+		defer server.Close()
+	}
+	// Run the script, exiting non-zero if any of its checks failed.
+	if scenario != nil {
+		failures, err := script.NewRunner(node, driver).Run(scenario)
+		if err != nil {
+			log.Printf("Error in script: %v\n", err)
+			return 2
+		}
+		if failures > 0 {
+			return 1
+		}
+		return 0
+	}
 	driver.Run()
+	return 0
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+
+	ctl "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ctl"
+)
+
+// main sends one command to a node's control socket and prints the result as JSON.
+func main() {
+	log.SetFlags(0)
+	var sock string
+	flag.StringVar(&sock, "sock", "", "Path of the node's control socket (its -ctl flag).")
+	flag.Parse()
+	// nodectl -sock <path> <command> [args...]
+	args := flag.Args()
+	if sock == "" || len(args) < 1 {
+		log.Println("usage: ./nodectl -sock <path> <command> [args...]")
+		os.Exit(2)
+	}
+	client, err := ctl.Dial(sock)
+	if err != nil {
+		log.Printf("Error connecting to node: %v\n", err)
+		os.Exit(1)
+	}
+	defer client.Close()
+	result, err := client.Call(args[0], args[1:]...)
+	if result != nil {
+		var out bytes.Buffer
+		json.Indent(&out, result, "", "  ")
+		out.WriteString("\n")
+		os.Stdout.Write(out.Bytes())
+	}
+	if err != nil {
+		log.Printf("error: %v\n", err)
+		client.Close()
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,198 @@
+package bgp
+
+import (
+	"errors"
+	"net"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Message types.
+const (
+	MSG_OPEN         uint8 = 1
+	MSG_UPDATE       uint8 = 2
+	MSG_NOTIFICATION uint8 = 3
+	MSG_KEEPALIVE    uint8 = 4
+)
+
+// Every message starts with a 2-byte length (including the header) and a 1-byte type.
+const HEADER_SIZE int = 3
+
+// Longest AS path an update can carry, since its length is sent in a single byte.
+const MAX_AS_PATH_LEN int = 255
+
+// Notification error codes.
+const (
+	ERR_HEADER     uint8 = 1
+	ERR_OPEN       uint8 = 2
+	ERR_UPDATE     uint8 = 3
+	ERR_HOLD_TIMER uint8 = 4
+	ERR_FSM        uint8 = 5
+	ERR_CEASE      uint8 = 6
+)
+
+// Open is sent by both sides when a session starts.
+type Open struct {
+	Version  uint8
+	ASN      uint32
+	HoldTime uint16 // Seconds.
+	RouterID uint32
+}
+
+// Update withdraws some prefixes and announces others. Every announced prefix shares the same
+// attributes.
+type Update struct {
+	Withdrawn []ip.Route
+	MED       uint32
+	NextHop   net.IP
+	ASPath    []uint32
+	NLRI      []ip.Route
+}
+
+// Notification reports an error just before the session is closed.
+type Notification struct {
+	Code    uint8
+	Subcode uint8
+}
+
+// Prepends the message header.
+func frame(msgType uint8, body []byte) (data []byte) {
+	data = make([]byte, 0, HEADER_SIZE+len(body))
+	data = append(data, util.Htons(uint16(HEADER_SIZE+len(body)))...)
+	data = append(data, msgType)
+	data = append(data, body...)
+	return data
+}
+
+// Parses a message header, returning the length of the whole message and its type.
+func DeserializeHeader(data []byte) (length int, msgType uint8, err error) {
+	if len(data) < HEADER_SIZE {
+		return 0, 0, errors.New("not enough data")
+	}
+	length = int(util.Ntohs(data[0:2]))
+	msgType = data[2]
+	if length < HEADER_SIZE || length > util.BGP_MAX_MESSAGE_SIZE {
+		return 0, 0, errors.New("bad message length")
+	}
+	if msgType < MSG_OPEN || msgType > MSG_KEEPALIVE {
+		return 0, 0, errors.New("bad message type")
+	}
+	return length, msgType, nil
+}
+
+// Serializes an Open message.
+func SerializeOpen(open Open) []byte {
+	body := make([]byte, 0)
+	body = append(body, open.Version)
+	body = append(body, util.Htonl(open.ASN)...)
+	body = append(body, util.Htons(open.HoldTime)...)
+	body = append(body, util.Htonl(open.RouterID)...)
+	return frame(MSG_OPEN, body)
+}
+
+// Parses the body of an Open message.
+func DeserializeOpen(body []byte) (open Open, err error) {
+	if len(body) < 11 {
+		return open, errors.New("not enough data")
+	}
+	return Open{
+		Version:  body[0],
+		ASN:      util.Ntohl(body[1:5]),
+		HoldTime: util.Ntohs(body[5:7]),
+		RouterID: util.Ntohl(body[7:11]),
+	}, nil
+}
+
+// Serializes a list of prefixes.
+func serializePrefixes(routes []ip.Route) (data []byte) {
+	data = append(data, util.Htons(uint16(len(routes)))...)
+	for _, route := range routes {
+		data = append(data, util.Htonl(route.Addr)...)
+		data = append(data, util.Htonl(route.Mask)...)
+	}
+	return data
+}
+
+// Parses a list of prefixes, returning the number of bytes consumed.
+func deserializePrefixes(data []byte) (routes []ip.Route, n int, err error) {
+	if len(data) < 2 {
+		return nil, 0, errors.New("not enough data")
+	}
+	count := int(util.Ntohs(data[0:2]))
+	n = 2
+	if len(data) < n+count*8 {
+		return nil, 0, errors.New("not enough data")
+	}
+	routes = make([]ip.Route, count)
+	for i := range routes {
+		routes[i] = ip.NewRoute(util.Ntohl(data[n:n+4]), util.Ntohl(data[n+4:n+8]))
+		n += 8
+	}
+	return routes, n, nil
+}
+
+// Serializes an Update message. Fails if the AS path is too long to send.
+func SerializeUpdate(update Update) ([]byte, error) {
+	if len(update.ASPath) > MAX_AS_PATH_LEN {
+		return nil, errors.New("as path too long")
+	}
+	body := serializePrefixes(update.Withdrawn)
+	body = append(body, util.Htonl(update.MED)...)
+	nextHop := uint32(0)
+	if update.NextHop != nil {
+		nextHop = util.IP2int(update.NextHop)
+	}
+	body = append(body, util.Htonl(nextHop)...)
+	body = append(body, uint8(len(update.ASPath)))
+	for _, asn := range update.ASPath {
+		body = append(body, util.Htonl(asn)...)
+	}
+	body = append(body, serializePrefixes(update.NLRI)...)
+	return frame(MSG_UPDATE, body), nil
+}
+
+// Parses the body of an Update message.
+func DeserializeUpdate(body []byte) (update Update, err error) {
+	withdrawn, n, err := deserializePrefixes(body)
+	if err != nil {
+		return update, err
+	}
+	update.Withdrawn = withdrawn
+	body = body[n:]
+	if len(body) < 9 {
+		return update, errors.New("not enough data")
+	}
+	update.MED = util.Ntohl(body[0:4])
+	update.NextHop = util.Int2IP(util.Ntohl(body[4:8]))
+	pathLen := int(body[8])
+	body = body[9:]
+	if len(body) < pathLen*4 {
+		return update, errors.New("not enough data")
+	}
+	update.ASPath = make([]uint32, pathLen)
+	for i := range update.ASPath {
+		update.ASPath[i] = util.Ntohl(body[i*4 : i*4+4])
+	}
+	body = body[pathLen*4:]
+	update.NLRI, _, err = deserializePrefixes(body)
+	return update, err
+}
+
+// Serializes a Notification message.
+func SerializeNotification(notification Notification) []byte {
+	return frame(MSG_NOTIFICATION, []byte{notification.Code, notification.Subcode})
+}
+
+// Parses the body of a Notification message.
+func DeserializeNotification(body []byte) (notification Notification, err error) {
+	if len(body) < 2 {
+		return notification, errors.New("not enough data")
+	}
+	return Notification{Code: body[0], Subcode: body[1]}, nil
+}
+
+// Serializes a Keepalive message.
+func SerializeKeepalive() []byte {
+	return frame(MSG_KEEPALIVE, []byte{})
+}
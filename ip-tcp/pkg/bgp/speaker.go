@@ -0,0 +1,651 @@
+package bgp
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+var bgpLog = util.NewLogger("bgp")
+
+// Session states.
+const (
+	S_IDLE         = "IDLE"
+	S_CONNECT      = "CONNECT"
+	S_OPEN_SENT    = "OPEN_SENT"
+	S_OPEN_CONFIRM = "OPEN_CONFIRM"
+	S_ESTABLISHED  = "ESTABLISHED"
+)
+
+// Maximum number of prefixes in a single update.
+const MAX_UPDATE_PREFIXES int = 256
+
+// Path is a route to a prefix learned from a peer.
+type Path struct {
+	Route     ip.Route
+	ASPath    []uint32
+	MED       uint32
+	NextHop   net.IP
+	LocalPref uint32
+	peer      *Peer
+}
+
+// advert is what we last told a peer about a prefix.
+type advert struct {
+	asPath []uint32
+	med    uint32
+}
+
+// Peer is an eBGP session with a neighbour.
+type Peer struct {
+	ip.BGPNeighbor
+	linkID    int                 // Interface the neighbour is on.
+	active    bool                // Whether we open the TCP connection, rather than waiting for the peer to.
+	state     string              // Session state.
+	since     time.Time           // When the session entered its current state.
+	accepted  chan *tcp.Conn      // Connections accepted from this peer.
+	out       chan []byte         // Messages waiting to be written, or nil if there is no session.
+	fail      chan error          // Errors that should tear down the session.
+	adjRibIn  map[ip.Route]*Path  // Paths learned from this peer.
+	adjRibOut map[ip.Route]advert // Paths advertised to this peer.
+}
+
+// Speaker runs BGP sessions with every configured neighbour and exchanges routes between BGP and
+// the node's routing table.
+type Speaker struct {
+	node     *ip.Node
+	driver   *tcp.Driver
+	asn      uint32
+	routerID uint32
+	peers    []*Peer
+	locRib   map[ip.Route]*Path // Best path to each prefix.
+	mtx      sync.Mutex
+}
+
+// message is a message read off a session.
+type message struct {
+	msgType uint8
+	body    []byte
+}
+
+// Creates a speaker for the node's AS and neighbours.
+func NewSpeaker(node *ip.Node, driver *tcp.Driver) (*Speaker, error) {
+	if node.ASN == 0 {
+		return nil, errors.New("no as number configured")
+	}
+	s := &Speaker{
+		node:   node,
+		driver: driver,
+		asn:    node.ASN,
+		locRib: make(map[ip.Route]*Path),
+	}
+	// Use our lowest address as the router ID.
+	for _, interf := range node.LocalInterfaces {
+		if addr := util.IP2int(interf.Addr); s.routerID == 0 || addr < s.routerID {
+			s.routerID = addr
+		}
+	}
+	for _, neighbor := range node.BGPNeighbors {
+		linkID, ok := node.NeighborInterface(neighbor.Addr)
+		if !ok {
+			return nil, fmt.Errorf("neighbor %v is not directly connected", neighbor.Addr)
+		}
+		local := node.LocalInterfaces[linkID].Addr
+		s.peers = append(s.peers, &Peer{
+			BGPNeighbor: neighbor,
+			linkID:      linkID,
+			active:      util.IP2int(local) < util.IP2int(neighbor.Addr),
+			state:       S_IDLE,
+			since:       time.Now(),
+			accepted:    make(chan *tcp.Conn, 1),
+			fail:        make(chan error, 1),
+			adjRibIn:    make(map[ip.Route]*Path),
+			adjRibOut:   make(map[ip.Route]advert),
+		})
+	}
+	return s, nil
+}
+
+// Starts listening for and connecting to peers.
+func (s *Speaker) Start() error {
+	// The peer with the higher address connects to us.
+	listening := make(map[string]bool)
+	for _, peer := range s.peers {
+		local := s.node.LocalInterfaces[peer.linkID].Addr
+		if peer.active || listening[local.String()] {
+			continue
+		}
+		l, err := s.driver.Listen(local, util.BGP_PORT)
+		if err != nil {
+			return err
+		}
+		listening[local.String()] = true
+		go s.acceptPeers(l)
+	}
+	for _, peer := range s.peers {
+		go s.runPeer(peer)
+	}
+	go s.watchRoutes(s.node.SubscribeRoutes())
+	s.node.RegisterCommand("bgp", s.HandleCommand)
+	return nil
+}
+
+// Hands connections accepted on a listener to the peer they came from.
+func (s *Speaker) acceptPeers(l *tcp.Listener) {
+	for {
+		c, err := l.AcceptConn()
+		if err != nil {
+			return
+		}
+		remote, _ := c.RemoteVIP()
+		peer := s.findPeer(remote)
+		if peer == nil || peer.active {
+			bgpLog.Debug("rejecting connection", "from", remote)
+			c.Close()
+			continue
+		}
+		select {
+		case peer.accepted <- c:
+		default:
+			c.Close()
+		}
+	}
+}
+
+// Finds the peer with the given address.
+func (s *Speaker) findPeer(addr net.IP) *Peer {
+	for _, peer := range s.peers {
+		if peer.Addr.Equal(addr) {
+			return peer
+		}
+	}
+	return nil
+}
+
+// Keeps a session up with the peer, reconnecting whenever it goes down.
+func (s *Speaker) runPeer(peer *Peer) {
+	var next *tcp.Conn
+	for {
+		conn := next
+		next = nil
+		if conn == nil && peer.active {
+			s.setState(peer, S_CONNECT)
+			local := s.node.LocalInterfaces[peer.linkID].Addr
+			c, err := s.driver.Connect(local, s.driver.EphemeralPort(), peer.Addr, util.BGP_PORT)
+			if err == tcp.ErrClosed {
+				return
+			}
+			if err != nil {
+				bgpLog.Debug("connect failed", "peer", peer.Addr, "err", err)
+				s.setState(peer, S_IDLE)
+				if !s.sleep(util.BGP_CONNECT_RETRY) {
+					return
+				}
+				continue
+			}
+			conn = c
+		} else if conn == nil {
+			select {
+			case conn = <-peer.accepted:
+			case <-s.node.Context().Done():
+				return
+			}
+		}
+		var err error
+		next, err = s.runSession(peer, conn)
+		bgpLog.Info("session closed", "peer", peer.Addr, "err", err)
+		conn.Close()
+		s.sessionDown(peer)
+		if next == nil && !s.sleep(util.BGP_CONNECT_RETRY) {
+			return
+		}
+	}
+}
+
+// Waits for d, returning false if the node was closed first.
+func (s *Speaker) sleep(d time.Duration) bool {
+	select {
+	case <-time.After(d):
+		return true
+	case <-s.node.Context().Done():
+		return false
+	}
+}
+
+// Runs a single session over an open connection until it fails. If the peer opens a new
+// connection, it is returned so that the next session can use it.
+func (s *Speaker) runSession(peer *Peer, conn *tcp.Conn) (*tcp.Conn, error) {
+	done := make(chan bool)
+	defer close(done)
+	msgs := make(chan message)
+	errs := make(chan error, 1)
+	go readMessages(conn, msgs, errs, done)
+	out := make(chan []byte, util.BGP_OUT_QUEUE)
+	written := make(chan bool)
+	go writeMessages(conn, out, written)
+	defer func() {
+		// Let the writer flush what is queued, such as a final notification.
+		s.mtx.Lock()
+		peer.out = nil
+		close(out)
+		s.mtx.Unlock()
+		select {
+		case <-written:
+		case <-time.After(util.BGP_KEEPALIVE_INTERVAL):
+		}
+	}()
+	s.mtx.Lock()
+	peer.out = out
+	s.mtx.Unlock()
+
+	// Open the session.
+	s.send(peer, SerializeOpen(Open{
+		Version:  4,
+		ASN:      s.asn,
+		HoldTime: uint16(util.BGP_HOLD_TIME / time.Second),
+		RouterID: s.routerID,
+	}))
+	s.setState(peer, S_OPEN_SENT)
+	state := S_OPEN_SENT
+	notify := func(code uint8, subcode uint8) {
+		s.send(peer, SerializeNotification(Notification{Code: code, Subcode: subcode}))
+	}
+	holdTime := util.BGP_HOLD_TIME
+	hold := time.NewTimer(holdTime)
+	defer hold.Stop()
+	keepalive := time.NewTicker(util.BGP_KEEPALIVE_INTERVAL)
+	defer keepalive.Stop()
+	for {
+		select {
+		case msg := <-msgs:
+			hold.Reset(holdTime)
+			switch msg.msgType {
+			case MSG_OPEN:
+				if state != S_OPEN_SENT {
+					notify(ERR_FSM, 0)
+					return nil, errors.New("unexpected open")
+				}
+				open, err := DeserializeOpen(msg.body)
+				if err != nil {
+					notify(ERR_OPEN, 0)
+					return nil, err
+				}
+				if open.ASN != peer.RemoteAS {
+					notify(ERR_OPEN, 2)
+					return nil, fmt.Errorf("peer is in as %v, expected %v", open.ASN, peer.RemoteAS)
+				}
+				// Use the smaller of the two hold times.
+				if peerHold := time.Duration(open.HoldTime) * time.Second; peerHold > 0 && peerHold < holdTime {
+					holdTime = peerHold
+					hold.Reset(holdTime)
+				}
+				s.send(peer, SerializeKeepalive())
+				s.setState(peer, S_OPEN_CONFIRM)
+				state = S_OPEN_CONFIRM
+			case MSG_KEEPALIVE:
+				if state == S_OPEN_CONFIRM {
+					s.established(peer)
+					state = S_ESTABLISHED
+				}
+			case MSG_UPDATE:
+				if state != S_ESTABLISHED {
+					notify(ERR_FSM, 0)
+					return nil, errors.New("unexpected update")
+				}
+				update, err := DeserializeUpdate(msg.body)
+				if err != nil {
+					notify(ERR_UPDATE, 0)
+					return nil, err
+				}
+				s.handleUpdate(peer, update)
+			case MSG_NOTIFICATION:
+				notification, _ := DeserializeNotification(msg.body)
+				return nil, fmt.Errorf("peer sent notification %v/%v", notification.Code, notification.Subcode)
+			}
+		case err := <-errs:
+			return nil, err
+		case err := <-peer.fail:
+			notify(ERR_CEASE, 0)
+			return nil, err
+		case c := <-peer.accepted:
+			// The peer restarted and is opening a new session.
+			return c, errors.New("peer reconnected")
+		case <-keepalive.C:
+			if state == S_OPEN_CONFIRM || state == S_ESTABLISHED {
+				s.send(peer, SerializeKeepalive())
+			}
+		case <-hold.C:
+			notify(ERR_HOLD_TIMER, 0)
+			return nil, errors.New("hold timer expired")
+		}
+	}
+}
+
+// Reads messages off a connection until it fails or the session ends.
+func readMessages(conn *tcp.Conn, msgs chan message, errs chan error, done chan bool) {
+	header := make([]byte, HEADER_SIZE)
+	for {
+		_, err := conn.Read(header, uint32(HEADER_SIZE), true)
+		if err != nil {
+			errs <- err
+			return
+		}
+		length, msgType, err := DeserializeHeader(header)
+		if err != nil {
+			errs <- err
+			return
+		}
+		body := make([]byte, length-HEADER_SIZE)
+		if len(body) > 0 {
+			if _, err := conn.Read(body, uint32(len(body)), true); err != nil {
+				errs <- err
+				return
+			}
+		}
+		select {
+		case msgs <- message{msgType: msgType, body: body}:
+		case <-done:
+			return
+		}
+	}
+}
+
+// Writes queued messages to a connection until the queue is closed.
+func writeMessages(conn *tcp.Conn, out chan []byte, written chan bool) {
+	for data := range out {
+		conn.Write(data)
+	}
+	close(written)
+}
+
+// Queues a message for the peer without blocking. If the queue is full, the session is reset.
+func (s *Speaker) send(peer *Peer, data []byte) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.sendLocked(peer, data)
+}
+
+// Queues a message for the peer. mtx held on entry.
+func (s *Speaker) sendLocked(peer *Peer, data []byte) {
+	if peer.out == nil {
+		return
+	}
+	select {
+	case peer.out <- data:
+	default:
+		select {
+		case peer.fail <- errors.New("output queue full"):
+		default:
+		}
+	}
+}
+
+// Queues an update for the peer. mtx held on entry.
+func (s *Speaker) sendUpdate(peer *Peer, update Update) {
+	data, err := SerializeUpdate(update)
+	if err != nil {
+		bgpLog.Warn("not sending update", "peer", peer.Addr, "err", err)
+		return
+	}
+	s.sendLocked(peer, data)
+}
+
+// Updates the state of a session.
+func (s *Speaker) setState(peer *Peer, state string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	peer.state = state
+	peer.since = time.Now()
+}
+
+// Marks a session established and sends the peer our routes.
+func (s *Speaker) established(peer *Peer) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	bgpLog.Info("session established", "peer", peer.Addr, "as", peer.RemoteAS)
+	peer.state = S_ESTABLISHED
+	peer.since = time.Now()
+	peer.adjRibOut = make(map[ip.Route]advert)
+	s.export(peer)
+}
+
+// Forgets everything learned from a peer whose session went down.
+func (s *Speaker) sessionDown(peer *Peer) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	peer.state = S_IDLE
+	peer.since = time.Now()
+	peer.adjRibIn = make(map[ip.Route]*Path)
+	peer.adjRibOut = make(map[ip.Route]advert)
+	s.decide()
+}
+
+// Applies an update from a peer.
+func (s *Speaker) handleUpdate(peer *Peer, update Update) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	for _, route := range update.Withdrawn {
+		delete(peer.adjRibIn, route)
+	}
+	for _, route := range update.NLRI {
+		// Drop paths that have already been through our AS, or that the peer didn't put itself on.
+		if len(update.ASPath) == 0 || update.ASPath[0] != peer.RemoteAS || containsAS(update.ASPath, s.asn) {
+			bgpLog.Debug("rejecting path", "route", route, "peer", peer.Addr, "as_path", update.ASPath)
+			delete(peer.adjRibIn, route)
+			continue
+		}
+		peer.adjRibIn[route] = &Path{
+			Route:     route,
+			ASPath:    update.ASPath,
+			MED:       update.MED,
+			NextHop:   update.NextHop,
+			LocalPref: peer.LocalPref,
+			peer:      peer,
+		}
+	}
+	s.decide()
+}
+
+// Checks if a path should be preferred over another: highest local preference, then shortest AS
+// path, then lowest MED between paths from the same neighbouring AS, then lowest peer address.
+func better(a *Path, b *Path) bool {
+	if a.LocalPref != b.LocalPref {
+		return a.LocalPref > b.LocalPref
+	}
+	if len(a.ASPath) != len(b.ASPath) {
+		return len(a.ASPath) < len(b.ASPath)
+	}
+	if a.ASPath[0] == b.ASPath[0] && a.MED != b.MED {
+		return a.MED < b.MED
+	}
+	return util.IP2int(a.peer.Addr) < util.IP2int(b.peer.Addr)
+}
+
+// Picks the best path to each prefix, installs changes into the routing table and tells our peers.
+// mtx held on entry.
+func (s *Speaker) decide() {
+	best := make(map[ip.Route]*Path)
+	for _, peer := range s.peers {
+		for route, path := range peer.adjRibIn {
+			if current, exists := best[route]; !exists || better(path, current) {
+				best[route] = path
+			}
+		}
+	}
+	changed := false
+	for route := range s.locRib {
+		if _, exists := best[route]; !exists {
+			s.node.WithdrawRoute(route, ip.SRC_BGP)
+			changed = true
+		}
+	}
+	for route, path := range best {
+		if current, exists := s.locRib[route]; !exists || current != path {
+			s.node.InstallRoute(route, path.peer.linkID, util.BGP_REDIST_COST, util.BGP_REDIST_TAG, ip.SRC_BGP, "as-path "+formatASPath(path.ASPath))
+			changed = true
+		}
+	}
+	s.locRib = best
+	if changed {
+		s.exportAll()
+	}
+}
+
+// Re-exports routes whenever the routing table changes, and periodically in case events were missed.
+func (s *Speaker) watchRoutes(events <-chan ip.RouteEvent) {
+	ticker := time.NewTicker(util.BGP_SCAN_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-ticker.C:
+		}
+		s.mtx.Lock()
+		s.exportAll()
+		s.mtx.Unlock()
+	}
+}
+
+// Brings every established peer up to date. mtx held on entry.
+func (s *Speaker) exportAll() {
+	for _, peer := range s.peers {
+		if peer.state == S_ESTABLISHED {
+			s.export(peer)
+		}
+	}
+}
+
+// Sends a peer whatever has changed since we last advertised to it. We advertise our best BGP paths
+// (except back to where they came from) and redistribute local, static, link-state and RIP routes. RIP
+// routes tagged with BGP_REDIST_TAG were redistributed from BGP by another border node, so they
+// aren't sent back into BGP. mtx held on entry.
+func (s *Speaker) export(peer *Peer) {
+	desired := make(map[ip.Route]advert)
+	for route, path := range s.locRib {
+		if path.peer == peer || containsAS(path.ASPath, peer.RemoteAS) {
+			continue
+		}
+		// There's no room left on the path for us, so the route goes no further.
+		if len(path.ASPath) >= MAX_AS_PATH_LEN {
+			continue
+		}
+		desired[route] = advert{asPath: append([]uint32{s.asn}, path.ASPath...)}
+	}
+	for route, entry := range s.node.Routes() {
+		switch entry.Source {
+		case ip.SRC_CONNECTED, ip.SRC_STATIC, ip.SRC_LS:
+		case ip.SRC_RIP:
+			if entry.Tag == util.BGP_REDIST_TAG {
+				continue
+			}
+		default:
+			continue
+		}
+		desired[route] = advert{asPath: []uint32{s.asn}, med: entry.Cost}
+	}
+	// Withdraw what we no longer have.
+	withdrawn := make([]ip.Route, 0)
+	for route := range peer.adjRibOut {
+		if _, exists := desired[route]; !exists {
+			withdrawn = append(withdrawn, route)
+			delete(peer.adjRibOut, route)
+		}
+	}
+	for len(withdrawn) > 0 {
+		n := len(withdrawn)
+		if n > MAX_UPDATE_PREFIXES {
+			n = MAX_UPDATE_PREFIXES
+		}
+		s.sendUpdate(peer, Update{Withdrawn: withdrawn[:n]})
+		withdrawn = withdrawn[n:]
+	}
+	// Announce what changed, grouping prefixes with the same attributes.
+	nextHop := s.node.LocalInterfaces[peer.linkID].Addr
+	updates := make(map[string]*Update)
+	for route, adv := range desired {
+		if old, exists := peer.adjRibOut[route]; exists && old.med == adv.med && formatASPath(old.asPath) == formatASPath(adv.asPath) {
+			continue
+		}
+		peer.adjRibOut[route] = adv
+		key := fmt.Sprintf("%v/%v", formatASPath(adv.asPath), adv.med)
+		update, exists := updates[key]
+		if !exists || len(update.NLRI) == MAX_UPDATE_PREFIXES {
+			if exists {
+				s.sendUpdate(peer, *update)
+			}
+			update = &Update{MED: adv.med, NextHop: nextHop, ASPath: adv.asPath}
+			updates[key] = update
+		}
+		update.NLRI = append(update.NLRI, route)
+	}
+	for _, update := range updates {
+		s.sendUpdate(peer, *update)
+	}
+}
+
+// Checks if an AS path contains the given AS.
+func containsAS(asPath []uint32, asn uint32) bool {
+	for _, a := range asPath {
+		if a == asn {
+			return true
+		}
+	}
+	return false
+}
+
+// Formats an AS path as a space-separated list.
+func formatASPath(asPath []uint32) string {
+	asns := make([]string, len(asPath))
+	for i, asn := range asPath {
+		asns[i] = fmt.Sprint(asn)
+	}
+	return strings.Join(asns, " ")
+}
+
+// Prints the peers and every path we've learned.
+func (s *Speaker) HandleCommand(tokens []string) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	log.Printf("as %v router-id %v\n", s.asn, util.Int2IP(s.routerID))
+	log.Printf("neighbor\tas\tlp\tstate\t\tfor\n")
+	for _, peer := range s.peers {
+		log.Printf("%v\t%v\t%v\t%-12v\t%v\n",
+			peer.Addr, peer.RemoteAS, peer.LocalPref, peer.state, time.Since(peer.since).Round(time.Second))
+	}
+	paths := make([]*Path, 0)
+	for _, peer := range s.peers {
+		for _, path := range peer.adjRibIn {
+			paths = append(paths, path)
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool {
+		if paths[i].Route.Addr != paths[j].Route.Addr {
+			return paths[i].Route.Addr < paths[j].Route.Addr
+		}
+		if paths[i].Route.Mask != paths[j].Route.Mask {
+			return paths[i].Route.Mask < paths[j].Route.Mask
+		}
+		return util.IP2int(paths[i].peer.Addr) < util.IP2int(paths[j].peer.Addr)
+	})
+	log.Printf("  prefix\t\tnext-hop\tlp\tmed\tas-path\n")
+	for _, path := range paths {
+		marker := " "
+		if s.locRib[path.Route] == path {
+			marker = ">"
+		}
+		log.Printf("%v %v/%v\t%v\t%v\t%v\t%v\n", marker, util.Int2IP(path.Route.Addr), util.MaskLen(util.Int2IP(path.Route.Mask)),
+			path.NextHop, path.LocalPref, path.MED, formatASPath(path.ASPath))
+	}
+}
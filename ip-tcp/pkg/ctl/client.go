@@ -0,0 +1,54 @@
+package ctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+)
+
+// Client sends commands to a node's control server.
+type Client struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID uint64
+}
+
+// Connects to the control socket at path.
+func Dial(path string) (*Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// Runs a command on the node and waits for its result, which is nil for commands that only
+// report success. If the command failed, returns its error along with any partial result.
+func (c *Client) Call(command string, args ...string) (json.RawMessage, error) {
+	c.nextID++
+	req := Request{ID: c.nextID, Command: command, Args: args}
+	if err := json.NewEncoder(c.conn).Encode(req); err != nil {
+		return nil, err
+	}
+	line, err := c.reader.ReadBytes('\n')
+	if err != nil {
+		return nil, err
+	}
+	var resp Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return nil, err
+	}
+	if resp.ID != req.ID {
+		return nil, errors.New("response does not match request")
+	}
+	if resp.Error != "" {
+		return resp.Result, errors.New(resp.Error)
+	}
+	return resp.Result, nil
+}
+
+// Disconnects from the node.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,64 @@
+package ctl
+
+import "encoding/json"
+
+// Request runs one REPL command. Args are the tokens after the command, as they would be typed.
+// Requests and responses are sent as one JSON object per line.
+type Request struct {
+	ID      uint64   `json:"id"`
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+}
+
+// Response carries the result of the request with the same ID, or why it failed.
+type Response struct {
+	ID     uint64          `json:"id"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+// Interface is a result of li.
+type Interface struct {
+	ID     int    `json:"id"`
+	Local  string `json:"local"`
+	Remote string `json:"remote"`
+	Up     bool   `json:"up"`
+}
+
+// Route is a result of lr.
+type Route struct {
+	Prefix    string `json:"prefix"`
+	Cost      uint32 `json:"cost"`
+	Interface string `json:"interface"` // Local address of the outgoing interface.
+	Source    string `json:"source"`
+	Tag       uint32 `json:"tag,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+	Stale     bool   `json:"stale,omitempty"`
+}
+
+// Traceroute is the result of traceroute. Complete is false if a hop didn't answer.
+type Traceroute struct {
+	Hops     []string `json:"hops"`
+	Complete bool     `json:"complete"`
+}
+
+// Socket is a result of ls.
+type Socket struct {
+	ID         int    `json:"id"`
+	LocalAddr  string `json:"local_addr"`
+	LocalPort  uint16 `json:"local_port"`
+	RemoteAddr string `json:"remote_addr"`
+	RemotePort uint16 `json:"remote_port"`
+	State      string `json:"state"`
+}
+
+// Connected is the result of c.
+type Connected struct {
+	Socket int `json:"socket"`
+}
+
+// Transferred is the result of s and r. Data is only set by r.
+type Transferred struct {
+	Bytes uint32 `json:"bytes"`
+	Data  string `json:"data,omitempty"`
+}
@@ -0,0 +1,364 @@
+package ctl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+var ctlLog = util.NewLogger("ctl")
+
+// Server runs REPL commands sent over a Unix socket and replies with structured results.
+type Server struct {
+	node     *ip.Node
+	driver   *tcp.Driver
+	listener net.Listener
+	conns    map[net.Conn]bool
+	mtx      sync.Mutex
+}
+
+// Creates a control server for a node and its TCP stack.
+func NewServer(node *ip.Node, driver *tcp.Driver) *Server {
+	return &Server{
+		node:   node,
+		driver: driver,
+		conns:  make(map[net.Conn]bool),
+	}
+}
+
+// Listens for clients on the Unix socket at path, replacing any socket left there by an old node.
+func (s *Server) Listen(path string) error {
+	if info, err := os.Stat(path); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	go s.acceptClients()
+	return nil
+}
+
+// Stops listening, removes the socket and disconnects every client.
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	err := s.listener.Close()
+	s.mtx.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mtx.Unlock()
+	return err
+}
+
+// Accepts clients until the listener closes.
+func (s *Server) acceptClients() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mtx.Lock()
+		s.conns[conn] = true
+		s.mtx.Unlock()
+		go s.serveClient(conn)
+	}
+}
+
+// Answers a client's requests, one per line, until it disconnects.
+func (s *Server) serveClient(conn net.Conn) {
+	defer func() {
+		s.mtx.Lock()
+		delete(s.conns, conn)
+		s.mtx.Unlock()
+		conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	encoder := json.NewEncoder(conn)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			return
+		}
+		var req Request
+		if jsonErr := json.Unmarshal(line, &req); jsonErr != nil {
+			encoder.Encode(Response{Error: fmt.Sprintf("malformed request: %v", jsonErr)})
+		} else if encodeErr := encoder.Encode(s.Handle(req)); encodeErr != nil {
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Runs a single request.
+func (s *Server) Handle(req Request) Response {
+	resp := Response{ID: req.ID}
+	result, err := s.run(req.Command, req.Args)
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	if result != nil {
+		data, jsonErr := json.Marshal(result)
+		if jsonErr != nil {
+			resp.Error = jsonErr.Error()
+		} else {
+			resp.Result = data
+		}
+	}
+	if resp.Error != "" {
+		ctlLog.Debug("request failed", "id", req.ID, "command", req.Command, "args", strings.Join(req.Args, " "), "err", resp.Error)
+	} else {
+		ctlLog.Debug("request", "id", req.ID, "command", req.Command, "args", strings.Join(req.Args, " "))
+	}
+	return resp
+}
+
+// Runs a command, returning whatever should be sent back as its result.
+func (s *Server) run(command string, args []string) (interface{}, error) {
+	switch command {
+	case "li", "interfaces":
+		interfaces := make([]Interface, 0)
+		for _, info := range s.node.Interfaces() {
+			interfaces = append(interfaces, Interface{
+				ID:     info.ID,
+				Local:  info.Local.String(),
+				Remote: info.Remote.String(),
+				Up:     info.Up,
+			})
+		}
+		return interfaces, nil
+
+	case "lr", "routes":
+		routes := make([]Route, 0)
+		for route, entry := range s.node.Routes() {
+			routes = append(routes, Route{
+				Prefix:    fmt.Sprintf("%v/%v", util.Int2IP(route.Addr), util.MaskLen(util.Int2IP(route.Mask))),
+				Cost:      entry.Cost,
+				Interface: entry.Interface.Addr.String(),
+				Source:    string(entry.Source),
+				Tag:       entry.Tag,
+				Detail:    entry.Detail,
+				Stale:     entry.Stale,
+			})
+		}
+		sort.Slice(routes, func(i, j int) bool { return routes[i].Prefix < routes[j].Prefix })
+		return routes, nil
+
+	case "up", "down":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("usage: %v <id>", command)
+		}
+		inum, err := strconv.Atoi(args[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface %v", args[0])
+		}
+		return nil, s.node.SetInterfaceUp(inum, command == "up")
+
+	case "send":
+		if len(args) < 3 {
+			return nil, errors.New("usage: send <ip> <protocol> <payload>")
+		}
+		dst, err := parseAddr(args[0])
+		if err != nil {
+			return nil, err
+		}
+		protocol, err := strconv.ParseUint(args[1], 10, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid protocol %v", args[1])
+		}
+		return nil, s.node.SendData(dst, uint8(protocol), []byte(strings.Join(args[2:], " ")))
+
+	case "traceroute":
+		if len(args) != 1 {
+			return nil, errors.New("usage: traceroute <ip>")
+		}
+		dst, err := parseAddr(args[0])
+		if err != nil {
+			return nil, err
+		}
+		hops, err := s.node.Traceroute(dst)
+		if hops == nil {
+			return nil, err
+		}
+		result := Traceroute{Hops: make([]string, 0, len(hops)), Complete: err == nil}
+		for _, hop := range hops {
+			result.Hops = append(result.Hops, hop.String())
+		}
+		return result, nil
+
+	case "ls", "sockets":
+		sockets := make([]Socket, 0)
+		for _, info := range s.driver.Sockets() {
+			sockets = append(sockets, Socket{
+				ID:         info.ID,
+				LocalAddr:  info.LocalAddr.String(),
+				LocalPort:  info.LocalPort,
+				RemoteAddr: info.RemoteAddr.String(),
+				RemotePort: info.RemotePort,
+				State:      string(info.State),
+			})
+		}
+		return sockets, nil
+
+	case "a":
+		if len(args) != 1 {
+			return nil, errors.New("usage: a <port>")
+		}
+		port, err := parsePort(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.driver.Serve(port)
+
+	case "c":
+		if len(args) != 2 {
+			return nil, errors.New("usage: c <ip> <port>")
+		}
+		dst, err := parseAddr(args[0])
+		if err != nil {
+			return nil, err
+		}
+		port, err := parsePort(args[1])
+		if err != nil {
+			return nil, err
+		}
+		c, err := s.driver.Connect(s.node.GetOpenAddr(), s.driver.EphemeralPort(), dst, port)
+		if err != nil {
+			return nil, err
+		}
+		return Connected{Socket: c.SocketID()}, nil
+
+	case "s":
+		if len(args) < 2 {
+			return nil, errors.New("usage: s <socket> <data>")
+		}
+		c, err := s.socket(args[0])
+		if err != nil {
+			return nil, err
+		}
+		n, err := c.Write([]byte(strings.Join(args[1:], " ")))
+		return Transferred{Bytes: n}, err
+
+	case "r":
+		if len(args) != 2 && len(args) != 3 {
+			return nil, errors.New("usage: r <socket> <numbytes> [y|n]")
+		}
+		numBytes, err := strconv.ParseUint(args[1], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid byte count %v", args[1])
+		}
+		// The buffer is allocated up front, so don't let a client ask for gigabytes.
+		if numBytes > uint64(util.TCP_MAX_WINDOW_SIZE) {
+			return nil, fmt.Errorf("byte count %v is larger than the maximum of %v", numBytes, util.TCP_MAX_WINDOW_SIZE)
+		}
+		c, err := s.socket(args[0])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, numBytes)
+		n, err := c.Read(buf, uint32(numBytes), len(args) == 3 && args[2] == "y")
+		return Transferred{Bytes: n, Data: string(buf[:n])}, err
+
+	case "sf":
+		if len(args) != 3 {
+			return nil, errors.New("usage: sf <filename> <ip> <port>")
+		}
+		dst, err := parseAddr(args[1])
+		if err != nil {
+			return nil, err
+		}
+		port, err := parsePort(args[2])
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.driver.SendFile(args[0], dst, port)
+
+	case "rf":
+		if len(args) != 2 {
+			return nil, errors.New("usage: rf <filename> <port>")
+		}
+		port, err := parsePort(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.driver.ReceiveFile(args[0], port)
+
+	case "sd":
+		if len(args) != 2 {
+			return nil, errors.New("usage: sd <socket> [read|write|both]")
+		}
+		c, err := s.socket(args[0])
+		if err != nil {
+			return nil, err
+		}
+		mode, err := tcp.ParseShutdown(args[1])
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.Shutdown(mode)
+
+	case "cl":
+		if len(args) != 1 {
+			return nil, errors.New("usage: cl <socket>")
+		}
+		c, err := s.socket(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.Close()
+
+	case "ab":
+		if len(args) != 1 {
+			return nil, errors.New("usage: ab <socket>")
+		}
+		c, err := s.socket(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return nil, c.Abort()
+	}
+	return nil, fmt.Errorf("unknown command %v", command)
+}
+
+// Looks up a socket descriptor.
+func (s *Server) socket(token string) (*tcp.Conn, error) {
+	sockID, err := strconv.Atoi(token)
+	if err != nil {
+		return nil, errors.New("socket is not valid")
+	}
+	return s.driver.Socket(sockID)
+}
+
+// Parses a virtual IP address.
+func parseAddr(token string) (net.IP, error) {
+	addr := net.ParseIP(token)
+	if addr == nil {
+		return nil, fmt.Errorf("invalid address %v", token)
+	}
+	return addr, nil
+}
+
+// Parses a TCP port.
+func parsePort(token string) (uint16, error) {
+	port, err := strconv.ParseUint(token, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid port %v", token)
+	}
+	return uint16(port), nil
+}
@@ -0,0 +1,242 @@
+package pkg
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+var bfdLog = util.NewLogger("bfd")
+
+// BFD session states.
+const (
+	BFD_ADMIN_DOWN uint8 = 0
+	BFD_DOWN       uint8 = 1
+	BFD_INIT       uint8 = 2
+	BFD_UP         uint8 = 3
+)
+
+var bfdStateNames = []string{"ADMIN_DOWN", "DOWN", "INIT", "UP"}
+
+// BFDPacket is a BFD control packet, loosely following RFC 5880.
+type BFDPacket struct {
+	State         uint8
+	DetectMult    uint8
+	MyDiscr       uint32
+	YourDiscr     uint32
+	DesiredMinTx  uint32 // Microseconds.
+	RequiredMinRx uint32 // Microseconds.
+}
+
+// Serializes a BFDPacket.
+func SerializeBFDPacket(bfdPacket BFDPacket) (data []byte) {
+	data = make([]byte, 0)
+	data = append(data, []byte{1, bfdPacket.State, bfdPacket.DetectMult, 20}...)
+	data = append(data, util.Htonl(bfdPacket.MyDiscr)...)
+	data = append(data, util.Htonl(bfdPacket.YourDiscr)...)
+	data = append(data, util.Htonl(bfdPacket.DesiredMinTx)...)
+	data = append(data, util.Htonl(bfdPacket.RequiredMinRx)...)
+	return data
+}
+
+// Parses a BFDPacket.
+func DeserializeBFDPacket(data []byte) (bfdPacket BFDPacket, err error) {
+	if len(data) < 20 {
+		return bfdPacket, errors.New("not enough data")
+	}
+	if data[0] != 1 || data[1] > BFD_UP || data[2] == 0 {
+		return bfdPacket, errors.New("invalid bfd packet")
+	}
+	return BFDPacket{
+		State:         data[1],
+		DetectMult:    data[2],
+		MyDiscr:       util.Ntohl(data[4:8]),
+		YourDiscr:     util.Ntohl(data[8:12]),
+		DesiredMinTx:  util.Ntohl(data[12:16]),
+		RequiredMinRx: util.Ntohl(data[16:20]),
+	}, nil
+}
+
+// bfdSession is the BFD state for a single interface.
+type bfdSession struct {
+	state       uint8
+	localDiscr  uint32
+	remoteDiscr uint32
+	remoteMinRx time.Duration
+	remoteMinTx time.Duration
+	remoteMult  uint8
+	wasUp       bool // Whether the session has ever come up.
+	lastTx      time.Time
+	detect      *time.Timer
+}
+
+// bfdState is all of the BFD state for a node.
+type bfdState struct {
+	interval time.Duration // Our desired transmit and required receive interval.
+	mult     uint8         // Our detection multiplier.
+	sessions []*bfdSession // By interface number.
+	mtx      sync.Mutex
+}
+
+// Turns on BFD on every interface with the given interval and multiplier. Must be called before Run.
+func (node *Node) SetBFD(interval time.Duration, mult uint8) {
+	node.bfd = &bfdState{
+		interval: interval,
+		mult:     mult,
+		sessions: make([]*bfdSession, len(node.LocalInterfaces)),
+	}
+	for i := range node.LocalInterfaces {
+		node.bfd.sessions[i] = &bfdSession{
+			state:      BFD_DOWN,
+			localDiscr: rand.Uint32() | 1,
+		}
+	}
+}
+
+// Checks if routes may be learned on the interface. Once a BFD session has come up, we stop
+// listening to the neighbour while the session is down.
+func (node *Node) bfdAllows(linkID int) bool {
+	if node.bfd == nil {
+		return true
+	}
+	node.bfd.mtx.Lock()
+	defer node.bfd.mtx.Unlock()
+	session := node.bfd.sessions[linkID]
+	return !session.wasUp || session.state == BFD_UP
+}
+
+// Handles BFD control packets.
+func BFDHandler(node *Node, packet *IPPacket, linkID int) error {
+	if node.bfd == nil {
+		return nil
+	}
+	bfdPacket, err := DeserializeBFDPacket(packet.Data)
+	if err != nil {
+		return err
+	}
+	node.bfd.mtx.Lock()
+	session := node.bfd.sessions[linkID]
+	if bfdPacket.YourDiscr != 0 && bfdPacket.YourDiscr != session.localDiscr {
+		node.bfd.mtx.Unlock()
+		return errors.New("bfd discriminator mismatch")
+	}
+	session.remoteDiscr = bfdPacket.MyDiscr
+	session.remoteMinRx = time.Duration(bfdPacket.RequiredMinRx) * time.Microsecond
+	session.remoteMinTx = time.Duration(bfdPacket.DesiredMinTx) * time.Microsecond
+	session.remoteMult = bfdPacket.DetectMult
+	oldState := session.state
+	switch {
+	case bfdPacket.State == BFD_ADMIN_DOWN || (bfdPacket.State == BFD_DOWN && session.state == BFD_UP):
+		session.state = BFD_DOWN
+	case session.state == BFD_DOWN && bfdPacket.State == BFD_DOWN:
+		session.state = BFD_INIT
+	case session.state == BFD_DOWN && bfdPacket.State == BFD_INIT:
+		session.state = BFD_UP
+	case session.state == BFD_INIT && bfdPacket.State != BFD_DOWN:
+		session.state = BFD_UP
+	}
+	// Restart the detection timer.
+	detectTime := session.remoteMinTx
+	if detectTime < node.bfd.interval {
+		detectTime = node.bfd.interval
+	}
+	detectTime *= time.Duration(session.remoteMult)
+	if session.detect == nil {
+		session.detect = time.AfterFunc(detectTime, node.newBFDTimer(linkID))
+	} else {
+		session.detect.Reset(detectTime)
+	}
+	newState := session.state
+	if newState == BFD_UP {
+		session.wasUp = true
+	}
+	node.bfd.mtx.Unlock()
+	node.bfdStateChanged(linkID, oldState, newState)
+	return nil
+}
+
+// Creates a timer callback that takes down the session on the given interface.
+func (node *Node) newBFDTimer(linkID int) func() {
+	return func() {
+		node.bfd.mtx.Lock()
+		session := node.bfd.sessions[linkID]
+		oldState := session.state
+		if oldState == BFD_INIT || oldState == BFD_UP {
+			session.state = BFD_DOWN
+			session.remoteDiscr = 0
+		}
+		node.bfd.mtx.Unlock()
+		node.bfdStateChanged(linkID, oldState, BFD_DOWN)
+	}
+}
+
+// Reacts to a session coming up or going down.
+func (node *Node) bfdStateChanged(linkID int, oldState uint8, newState uint8) {
+	if oldState == newState {
+		return
+	}
+	bfdLog.Info("session changed state", "if", linkID, "from", bfdStateNames[oldState], "to", bfdStateNames[newState])
+	if oldState == BFD_UP {
+		// The neighbour is gone; pull its routes right away.
+		node.flushInterface(linkID, true, CAUSE_BFD)
+	} else if newState == BFD_UP && !node.LinkState {
+		// Relearn routes from the neighbour without waiting for its next update.
+		node.sendRIP(node.LocalInterfaces[linkID], RIPData{Command: 1})
+	}
+}
+
+// Sends BFD control packets on every interface.
+func (node *Node) runBFD() {
+	ticker := time.NewTicker(node.bfd.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-node.ctx.Done():
+			return
+		}
+		node.bfd.mtx.Lock()
+		for linkID, session := range node.bfd.sessions {
+			// Don't send faster than the neighbour wants to receive.
+			if time.Since(session.lastTx) < session.remoteMinRx {
+				continue
+			}
+			session.lastTx = time.Now()
+			bfdPacket := BFDPacket{
+				State:         session.state,
+				DetectMult:    node.bfd.mult,
+				MyDiscr:       session.localDiscr,
+				YourDiscr:     session.remoteDiscr,
+				DesiredMinTx:  uint32(node.bfd.interval / time.Microsecond),
+				RequiredMinRx: uint32(node.bfd.interval / time.Microsecond),
+			}
+			interf := node.LocalInterfaces[linkID]
+			packet := NewIPPacket(util.BFD_PROTO, SerializeBFDPacket(bfdPacket), util.DEFAULT_TTL, interf.Addr, interf.Remote)
+			interf.Send(node.UDPConn, packet)
+		}
+		node.bfd.mtx.Unlock()
+	}
+}
+
+// Prints the BFD sessions.
+func (node *Node) printBFD() {
+	if node.bfd == nil {
+		log.Println("bfd is not enabled")
+		return
+	}
+	node.bfd.mtx.Lock()
+	defer node.bfd.mtx.Unlock()
+	log.Printf("if\tstate\tlocal\t\tremote\t\tdetect\n")
+	for linkID, session := range node.bfd.sessions {
+		detectTime := session.remoteMinTx
+		if detectTime < node.bfd.interval {
+			detectTime = node.bfd.interval
+		}
+		detectTime *= time.Duration(session.remoteMult)
+		log.Printf("%v\t%v\t%08x\t%08x\t%v\n", linkID, bfdStateNames[session.state], session.localDiscr, session.remoteDiscr, detectTime)
+	}
+}
@@ -0,0 +1,61 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+)
+
+// BGPNeighbor is a configured eBGP peer. Peers must be at the far end of one of our interfaces.
+type BGPNeighbor struct {
+	Addr      net.IP
+	RemoteAS  uint32
+	LocalPref uint32 // Preference given to routes learned from this peer.
+}
+
+// Checks if a token starts a BGP directive.
+func IsBGPDirective(token string) bool {
+	return token == "as" || token == "neighbor"
+}
+
+// Parses a single BGP directive from an lnx file into cfg:
+//
+//	as <asn>
+//	neighbor <vip> remote-as <asn> [local-pref <n>]
+func parseBGPDirective(cfg *BGPConfig, tokens []string) error {
+	switch tokens[0] {
+	case "as":
+		if len(tokens) != 2 {
+			return errors.New("usage: as <asn>")
+		}
+		asn, err := strconv.ParseUint(tokens[1], 10, 32)
+		if err != nil || asn == 0 {
+			return fmt.Errorf("invalid as number %v", tokens[1])
+		}
+		cfg.AS = uint32(asn)
+
+	case "neighbor":
+		if (len(tokens) != 4 && len(tokens) != 6) || tokens[2] != "remote-as" {
+			return errors.New("usage: neighbor <vip> remote-as <asn> [local-pref <n>]")
+		}
+		asn, err := strconv.ParseUint(tokens[3], 10, 32)
+		if err != nil || asn == 0 {
+			return fmt.Errorf("invalid as number %v", tokens[3])
+		}
+		neighbor := BGPNeighborConfig{Addr: tokens[1], RemoteAS: uint32(asn)}
+		if len(tokens) == 6 {
+			if tokens[4] != "local-pref" {
+				return fmt.Errorf("unknown neighbor option %v", tokens[4])
+			}
+			localPref, err := strconv.ParseUint(tokens[5], 10, 32)
+			if err != nil {
+				return fmt.Errorf("invalid local-pref %v", tokens[5])
+			}
+			pref := uint32(localPref)
+			neighbor.LocalPref = &pref
+		}
+		cfg.Neighbors = append(cfg.Neighbors, neighbor)
+	}
+	return nil
+}
@@ -0,0 +1,351 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Config describes a node: its interfaces, routes and protocols. It is loaded from JSON, or
+// converted from an lnx file. Zero values mean the default.
+type Config struct {
+	Listen       string              `json:"listen"` // host:port of our UDP socket.
+	Interfaces   []InterfaceConfig   `json:"interfaces"`
+	StaticRoutes []StaticRouteConfig `json:"static_routes,omitempty"`
+	Routing      RoutingConfig       `json:"routing"`
+	TCP          TCPConfig           `json:"tcp"`
+	BGP          *BGPConfig          `json:"bgp,omitempty"`
+	Policy       []string            `json:"policy,omitempty"` // Policy directives, as in lnx files.
+}
+
+// InterfaceConfig describes a link to a neighbour.
+type InterfaceConfig struct {
+	Name       string   `json:"name"`
+	Remote     string   `json:"remote"` // host:port of the neighbour's UDP socket.
+	Addr       string   `json:"addr"`
+	RemoteAddr string   `json:"remote_addr"`
+	Cost       uint32   `json:"cost,omitempty"`
+	MTU        int      `json:"mtu,omitempty"`  // Largest IP packet we send; 0 for no limit.
+	Loss       float64  `json:"loss,omitempty"` // Fraction of packets to drop.
+	Delay      Duration `json:"delay,omitempty"`
+	Down       bool     `json:"down,omitempty"` // Start with the interface down.
+}
+
+// StaticRouteConfig is a static route through a neighbour.
+type StaticRouteConfig struct {
+	Prefix string `json:"prefix"`
+	Via    string `json:"via"`
+	Cost   uint32 `json:"cost,omitempty"`
+}
+
+// RoutingConfig chooses and tunes the routing protocols.
+type RoutingConfig struct {
+	Protocol          string     `json:"protocol,omitempty"` // rip (the default), ls or none.
+	Aggregate         bool       `json:"aggregate,omitempty"`
+	RIPUpdateInterval Duration   `json:"rip_update_interval,omitempty"`
+	RIPTimeout        Duration   `json:"rip_timeout,omitempty"`
+	BFD               *BFDConfig `json:"bfd,omitempty"`
+}
+
+// BFDConfig turns on BFD.
+type BFDConfig struct {
+	Interval Duration `json:"interval,omitempty"`
+	Mult     uint8    `json:"mult,omitempty"`
+}
+
+// TCPConfig holds defaults for the TCP stack running on the node. The IP layer only carries them.
+type TCPConfig struct {
+	WindowSize        uint32 `json:"window_size,omitempty"`        // Initial receive buffer size.
+	MaxWindowSize     uint32 `json:"max_window_size,omitempty"`    // Largest autotuning grows the receive buffer to.
+	MSS               uint32 `json:"mss,omitempty"`                // Largest segment payload.
+	MaxRetries        int    `json:"max_retries,omitempty"`        // Handshake retransmissions before giving up.
+	MaxRetransmits    int    `json:"max_retransmits,omitempty"`    // Consecutive timeouts before a connection is aborted.
+	CongestionControl string `json:"congestion_control,omitempty"` // Algorithm for new connections: reno, cubic or bbr.
+}
+
+// BGPConfig makes the node a BGP speaker.
+type BGPConfig struct {
+	AS        uint32              `json:"as"`
+	Neighbors []BGPNeighborConfig `json:"neighbors,omitempty"`
+}
+
+// BGPNeighborConfig is an eBGP peer.
+type BGPNeighborConfig struct {
+	Addr      string  `json:"addr"`
+	RemoteAS  uint32  `json:"remote_as"`
+	LocalPref *uint32 `json:"local_pref,omitempty"`
+}
+
+// Duration is a time.Duration written as a string like "5s" in JSON.
+type Duration time.Duration
+
+// Marshals the duration as a string.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Parses a string duration.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"5s\", got %s", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Reads a JSON node configuration and validates it. Unknown fields are errors, to catch typos.
+func LoadConfig(filename string) (*Config, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+// Converts an lnx file to a configuration. Interfaces are named if0, if1, ...; route directives
+// become static routes, BGP directives the BGP section, and other directives are kept as policy.
+func ParseLnx(filename string) (*Config, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	fileReader := bufio.NewScanner(file)
+	cfg := &Config{}
+
+	// Get local node info
+	if !fileReader.Scan() {
+		return nil, fmt.Errorf("%v: empty lnx file", filename)
+	}
+	tokens := strings.Fields(fileReader.Text())
+	if len(tokens) != 2 {
+		return nil, fmt.Errorf("%v:1: expected <host> <port>, got %q", filename, fileReader.Text())
+	}
+	cfg.Listen = net.JoinHostPort(tokens[0], tokens[1])
+
+	// Get other connection info
+	for lineNum := 2; fileReader.Scan(); lineNum++ {
+		text := strings.TrimSpace(fileReader.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		tokens := strings.Fields(text)
+		switch {
+		case tokens[0] == "route":
+			static := StaticRouteConfig{}
+			if (len(tokens) != 4 && len(tokens) != 6) || tokens[2] != "via" || (len(tokens) == 6 && tokens[4] != "cost") {
+				return nil, fmt.Errorf("%v:%v: usage: route <prefix> via <vip> [cost <n>]", filename, lineNum)
+			}
+			static.Prefix, static.Via = tokens[1], tokens[3]
+			if len(tokens) == 6 {
+				cost, err := strconv.ParseUint(tokens[5], 10, 32)
+				if err != nil {
+					return nil, fmt.Errorf("%v:%v: invalid cost %v", filename, lineNum, tokens[5])
+				}
+				static.Cost = uint32(cost)
+			}
+			cfg.StaticRoutes = append(cfg.StaticRoutes, static)
+		case IsPolicyDirective(tokens[0]):
+			// Policy directives follow the interfaces they refer to.
+			cfg.Policy = append(cfg.Policy, strings.Join(tokens, " "))
+		case IsBGPDirective(tokens[0]):
+			if cfg.BGP == nil {
+				cfg.BGP = &BGPConfig{}
+			}
+			if err := parseBGPDirective(cfg.BGP, tokens); err != nil {
+				return nil, fmt.Errorf("%v:%v: %v", filename, lineNum, err)
+			}
+		case len(tokens) == 4:
+			cfg.Interfaces = append(cfg.Interfaces, InterfaceConfig{
+				Name:       fmt.Sprintf("if%v", len(cfg.Interfaces)),
+				Remote:     net.JoinHostPort(tokens[0], tokens[1]),
+				Addr:       tokens[2],
+				RemoteAddr: tokens[3],
+			})
+		default:
+			return nil, fmt.Errorf("%v:%v: malformed interface line %q", filename, lineNum, text)
+		}
+	}
+	if err := fileReader.Err(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", filename, err)
+	}
+	return cfg, nil
+}
+
+// Checks the configuration for mistakes, naming the offending field.
+func (cfg *Config) Validate() error {
+	if err := validateHostPort(cfg.Listen); err != nil {
+		return fmt.Errorf("listen: %v", err)
+	}
+	names := make(map[string]bool)
+	remotes := make(map[string]bool)
+	for i, interf := range cfg.Interfaces {
+		field := fmt.Sprintf("interfaces[%v]", i)
+		switch {
+		case interf.Name == "":
+			return fmt.Errorf("%v.name: missing", field)
+		case names[interf.Name]:
+			return fmt.Errorf("%v.name: duplicate name %q", field, interf.Name)
+		case interf.Cost >= util.INFINITY:
+			return fmt.Errorf("%v.cost: must be below %v", field, util.INFINITY)
+		case interf.MTU < 0 || (interf.MTU > 0 && interf.MTU < util.MIN_MTU):
+			return fmt.Errorf("%v.mtu: must be 0 or at least %v", field, util.MIN_MTU)
+		case interf.Loss < 0 || interf.Loss >= 1:
+			return fmt.Errorf("%v.loss: must be in [0, 1)", field)
+		case interf.Delay < 0:
+			return fmt.Errorf("%v.delay: must not be negative", field)
+		}
+		names[interf.Name] = true
+		if err := validateHostPort(interf.Remote); err != nil {
+			return fmt.Errorf("%v.remote: %v", field, err)
+		}
+		if err := validateAddr(interf.Addr); err != nil {
+			return fmt.Errorf("%v.addr: %v", field, err)
+		}
+		if err := validateAddr(interf.RemoteAddr); err != nil {
+			return fmt.Errorf("%v.remote_addr: %v", field, err)
+		}
+		remotes[net.ParseIP(interf.RemoteAddr).String()] = true
+	}
+	for i, static := range cfg.StaticRoutes {
+		field := fmt.Sprintf("static_routes[%v]", i)
+		if _, err := ParsePrefix(static.Prefix); err != nil {
+			return fmt.Errorf("%v.prefix: %v", field, err)
+		}
+		if err := validateAddr(static.Via); err != nil {
+			return fmt.Errorf("%v.via: %v", field, err)
+		}
+		if !remotes[net.ParseIP(static.Via).String()] {
+			return fmt.Errorf("%v.via: %v is not the remote_addr of any interface", field, static.Via)
+		}
+		if static.Cost > util.INFINITY {
+			return fmt.Errorf("%v.cost: must be at most %v", field, util.INFINITY)
+		}
+	}
+	routing := cfg.Routing
+	switch routing.Protocol {
+	case "", "rip", "ls", "none":
+	default:
+		return fmt.Errorf("routing.protocol: must be rip, ls or none, got %q", routing.Protocol)
+	}
+	if routing.RIPUpdateInterval < 0 {
+		return fmt.Errorf("routing.rip_update_interval: must not be negative")
+	}
+	if routing.RIPTimeout < 0 {
+		return fmt.Errorf("routing.rip_timeout: must not be negative")
+	}
+	if routing.ripTimeout() <= routing.ripUpdateInterval() {
+		return fmt.Errorf("routing.rip_timeout: must be longer than the update interval")
+	}
+	if routing.BFD != nil && routing.BFD.Interval < 0 {
+		return fmt.Errorf("routing.bfd.interval: must not be negative")
+	}
+	maxWindow := uint32(^uint16(0)) << util.TCP_MAX_WINDOW_SHIFT
+	if cfg.TCP.WindowSize > maxWindow {
+		return fmt.Errorf("tcp.window_size: must be at most %v", maxWindow)
+	}
+	if cfg.TCP.MaxWindowSize > maxWindow {
+		return fmt.Errorf("tcp.max_window_size: must be at most %v", maxWindow)
+	}
+	if cfg.TCP.MaxWindowSize != 0 && cfg.TCP.MaxWindowSize < cfg.TCP.WindowSize {
+		return fmt.Errorf("tcp.max_window_size: must be at least window_size")
+	}
+	if cfg.TCP.WindowSize != 0 && !util.IsPowerOf2(cfg.TCP.WindowSize) {
+		return fmt.Errorf("tcp.window_size: must be a power of 2, got %v", cfg.TCP.WindowSize)
+	}
+	if cfg.TCP.MaxWindowSize != 0 && !util.IsPowerOf2(cfg.TCP.MaxWindowSize) {
+		return fmt.Errorf("tcp.max_window_size: must be a power of 2, got %v", cfg.TCP.MaxWindowSize)
+	}
+	if cfg.TCP.MSS > uint32(util.MAX_FRAME_SIZE-2*util.MIN_PACKET_SIZE) {
+		return fmt.Errorf("tcp.mss: must be at most %v", util.MAX_FRAME_SIZE-2*util.MIN_PACKET_SIZE)
+	}
+	if cfg.TCP.MSS != 0 && cfg.TCP.MSS < util.TCP_MIN_MSS {
+		return fmt.Errorf("tcp.mss: must be at least %v", util.TCP_MIN_MSS)
+	}
+	if cfg.TCP.MaxRetries < 0 {
+		return fmt.Errorf("tcp.max_retries: must not be negative")
+	}
+	if cfg.TCP.MaxRetransmits < 0 {
+		return fmt.Errorf("tcp.max_retransmits: must not be negative")
+	}
+	if cfg.BGP != nil {
+		if cfg.BGP.AS == 0 {
+			return fmt.Errorf("bgp.as: missing")
+		}
+		for i, neighbor := range cfg.BGP.Neighbors {
+			field := fmt.Sprintf("bgp.neighbors[%v]", i)
+			if err := validateAddr(neighbor.Addr); err != nil {
+				return fmt.Errorf("%v.addr: %v", field, err)
+			}
+			if !remotes[net.ParseIP(neighbor.Addr).String()] {
+				return fmt.Errorf("%v.addr: %v is not the remote_addr of any interface", field, neighbor.Addr)
+			}
+			if neighbor.RemoteAS == 0 {
+				return fmt.Errorf("%v.remote_as: missing", field)
+			}
+		}
+	}
+	return nil
+}
+
+// Gets the RIP update interval, or the default.
+func (routing RoutingConfig) ripUpdateInterval() time.Duration {
+	if routing.RIPUpdateInterval == 0 {
+		return util.RIP_UPDATE_COOLDOWN
+	}
+	return time.Duration(routing.RIPUpdateInterval)
+}
+
+// Gets the RIP route timeout, or the default.
+func (routing RoutingConfig) ripTimeout() time.Duration {
+	if routing.RIPTimeout == 0 {
+		return util.RIP_ENTRY_TIMEOUT
+	}
+	return time.Duration(routing.RIPTimeout)
+}
+
+// Checks an address of the form host:port.
+func validateHostPort(s string) error {
+	_, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return err
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// Checks a virtual IPv4 address.
+func validateAddr(s string) error {
+	if addr := net.ParseIP(s); addr == nil || addr.To4() == nil {
+		return fmt.Errorf("invalid address %q", s)
+	}
+	return nil
+}
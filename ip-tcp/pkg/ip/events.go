@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// RouteEventType is the kind of change made to the routing table.
+type RouteEventType string
+
+const (
+	ROUTE_ADDED     RouteEventType = "added"
+	ROUTE_CHANGED   RouteEventType = "changed"
+	ROUTE_WITHDRAWN RouteEventType = "withdrawn"
+	ROUTE_EXPIRED   RouteEventType = "expired"
+)
+
+// Causes of routing table changes.
+const (
+	CAUSE_CONNECTED = "connected"
+	CAUSE_RIP       = "rip update"
+	CAUSE_TIMEOUT   = "timeout"
+	CAUSE_IF_UP     = "interface up"
+	CAUSE_IF_DOWN   = "interface down"
+	CAUSE_AGGREGATE = "aggregation"
+	CAUSE_SPF       = "spf"
+	CAUSE_BFD       = "bfd down"
+	CAUSE_STATIC    = "static route"
+	CAUSE_DISTANCE  = "distance change"
+	CAUSE_RESTART   = "warm restart"
+)
+
+// RouteEvent describes a single change to the routing table.
+type RouteEvent struct {
+	Time      time.Time
+	Type      RouteEventType
+	Route     Route
+	Interface int    // Index of the next-hop interface, or -1 if unknown.
+	OldCost   uint32 // Cost before the change; INFINITY if the route was added.
+	NewCost   uint32 // Cost after the change; INFINITY if the route was removed.
+	Cause     string
+}
+
+// Formats the event as a single log line.
+func (ev RouteEvent) String() string {
+	return fmt.Sprintf("%v %v %v/%v if %v cost %v -> %v (%v)",
+		ev.Time.Format(time.RFC3339Nano), ev.Type, util.Int2IP(ev.Route.Addr), util.MaskLen(util.Int2IP(ev.Route.Mask)),
+		ev.Interface, ev.OldCost, ev.NewCost, ev.Cause)
+}
+
+// SubscribeRoutes returns a channel of routing table changes. Events are dropped if the subscriber
+// falls more than ROUTE_EVENT_BUFFER events behind.
+func (node *Node) SubscribeRoutes() <-chan RouteEvent {
+	ch := make(chan RouteEvent, util.ROUTE_EVENT_BUFFER)
+	node.subMtx.Lock()
+	defer node.subMtx.Unlock()
+	node.subscribers = append(node.subscribers, ch)
+	return ch
+}
+
+// UnsubscribeRoutes stops and closes a channel returned by SubscribeRoutes.
+func (node *Node) UnsubscribeRoutes(sub <-chan RouteEvent) {
+	node.subMtx.Lock()
+	defer node.subMtx.Unlock()
+	for i, ch := range node.subscribers {
+		if ch == sub {
+			node.subscribers = append(node.subscribers[:i], node.subscribers[i+1:]...)
+			close(ch)
+			return
+		}
+	}
+}
+
+// LogRouteEvents writes every routing table change to w, one timestamped line per event.
+func (node *Node) LogRouteEvents(w io.Writer) {
+	sub := node.SubscribeRoutes()
+	go func() {
+		for ev := range sub {
+			fmt.Fprintln(w, ev.String())
+		}
+	}()
+}
+
+// Publishes an event to every subscriber without blocking.
+func (node *Node) publishRoute(evType RouteEventType, route Route, interf *Interface, oldCost uint32, newCost uint32, cause string) {
+	ev := RouteEvent{
+		Time:      time.Now(),
+		Type:      evType,
+		Route:     route,
+		Interface: node.interfaceIndex(interf),
+		OldCost:   oldCost,
+		NewCost:   newCost,
+		Cause:     cause,
+	}
+	node.subMtx.Lock()
+	defer node.subMtx.Unlock()
+	node.routeChanges[evType]++
+	for _, ch := range node.subscribers {
+		select {
+		case ch <- ev:
+		default:
+			ribLog.Warn("dropping route event for slow subscriber", "event", ev)
+		}
+	}
+}
+
+// Gets the index of an interface, or -1 if it isn't one of ours.
+func (node *Node) interfaceIndex(interf *Interface) int {
+	for i, inf := range node.LocalInterfaces {
+		if inf == interf {
+			return i
+		}
+	}
+	return -1
+}
@@ -9,6 +9,8 @@ import (
 	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
 )
 
+var icmpLog = util.NewLogger("icmp")
+
 // ICMP Packet implements a subset of the ICMP Protocol.
 type ICMPPacket struct {
 	Type     uint8
@@ -72,6 +74,7 @@ func ICMPHandler(node *Node, packet *IPPacket, linkID int) error {
 	if !VerifyICMPChecksum(icmpPacket) {
 		return errors.New("invalid ICMP checksum")
 	}
+	icmpLog.Debug("received message", "type", icmpPacket.Type, "code", icmpPacket.Code, "from", packet.Header.Src)
 	// Depending on the type...
 	switch icmpPacket.Type {
 	case 8: // EchoRequest
@@ -95,49 +98,52 @@ func ICMPHandler(node *Node, packet *IPPacket, linkID int) error {
 	return nil
 }
 
-// Conducts a traceroute by sending packets with increasing TTL values.
-func (node *Node) traceroute(dst net.IP) {
+// Conducts a traceroute by sending packets with increasing TTL values, returning every hop starting
+// with our own address. If a hop doesn't answer, returns the hops so far and an error.
+func (node *Node) Traceroute(dst net.IP) (hops []net.IP, err error) {
 	// Initialize destination and source.
 	entry, found, _ := node.matchRoute(dst, 32)
 	if !found {
-		log.Printf("Traceroute unable to reach vip\n")
-		return
+		return nil, errors.New("unable to reach vip")
 	}
 	src := entry.Interface.Addr
-	hops := []net.IP{src}
+	hops = []net.IP{src}
 	// Check if we're tracing to ourselves.
-	matched := false
 	for _, inf := range node.LocalInterfaces {
 		if dst.Equal(inf.Addr) {
-			matched = true
-			break
+			return hops, nil
 		}
 	}
 	// Traceroute to a remote host.
-	timedout := false
-	if !matched {
-		for ttl := uint8(1); ttl <= util.DEFAULT_TTL; ttl++ {
-			node.sendICMPEchoRequest(src, dst, ttl)
-			timer := time.NewTimer(util.RIP_ENTRY_TIMEOUT)
-			select {
-			case <-timer.C:
-				timedout = true
-				goto stopHopping
-			case remoteIP := <-node.ICMPChan:
-				hops = append(hops, remoteIP)
-				if remoteIP.Equal(dst) {
-					goto stopHopping
-				}
+	for ttl := uint8(1); ttl <= util.DEFAULT_TTL; ttl++ {
+		node.sendICMPEchoRequest(src, dst, ttl)
+		timer := time.NewTimer(util.RIP_ENTRY_TIMEOUT)
+		select {
+		case <-timer.C:
+			return hops, errors.New("timed out")
+		case remoteIP := <-node.ICMPChan:
+			timer.Stop()
+			hops = append(hops, remoteIP)
+			if remoteIP.Equal(dst) {
+				return hops, nil
 			}
 		}
 	}
-	// When we finish, print out the result.
-stopHopping:
-	log.Printf("Traceroute from %v to %v\n", src.String(), dst.String())
+	return hops, nil
+}
+
+// Conducts a traceroute and prints the result.
+func (node *Node) traceroute(dst net.IP) {
+	hops, err := node.Traceroute(dst)
+	if hops == nil {
+		log.Printf("Traceroute unable to reach vip\n")
+		return
+	}
+	log.Printf("Traceroute from %v to %v\n", hops[0].String(), dst.String())
 	for idx, ip := range hops {
 		log.Printf("%v %v\n", idx+1, ip.String())
 	}
-	if timedout {
+	if err != nil {
 		log.Printf("Traceroute timed out\n")
 	} else {
 		log.Printf("Traceroute finished in %v hops\n", len(hops))
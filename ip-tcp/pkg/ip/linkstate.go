@@ -0,0 +1,622 @@
+package pkg
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+var lsLog = util.NewLogger("ls")
+
+// Link-state packet types.
+const (
+	LS_HELLO  uint16 = 1
+	LS_UPDATE uint16 = 2
+	LS_ACK    uint16 = 3
+)
+
+// LSPacket is a single link-state protocol message.
+type LSPacket struct {
+	Type      uint16
+	Router    uint32   // Router ID of the sender.
+	Neighbors []uint32 // Hello: routers heard on this link.
+	LSAs      []LSA    // Update: advertisements being flooded.
+	Acks      []LSAck  // Ack: advertisements being acknowledged.
+}
+
+// LSA is a link-state advertisement originated by a single router.
+type LSA struct {
+	Router   uint32
+	Seq      uint32
+	Age      uint16 // Seconds since origination.
+	Links    []LSLink
+	Prefixes []LSPrefix
+}
+
+// LSLink is an adjacency to another router.
+type LSLink struct {
+	Neighbor uint32
+	Cost     uint32
+}
+
+// LSPrefix is a prefix directly attached to a router.
+type LSPrefix struct {
+	Addr uint32
+	Mask uint32
+	Cost uint32
+}
+
+// LSAck acknowledges a single LSA instance.
+type LSAck struct {
+	Router uint32
+	Seq    uint32
+}
+
+// Serializes an LSPacket.
+func SerializeLSPacket(lsPacket LSPacket) (data []byte) {
+	data = make([]byte, 0)
+	data = append(data, util.Htons(lsPacket.Type)...)
+	switch lsPacket.Type {
+	case LS_HELLO:
+		data = append(data, util.Htons(uint16(len(lsPacket.Neighbors)))...)
+		data = append(data, util.Htonl(lsPacket.Router)...)
+		for _, neighbor := range lsPacket.Neighbors {
+			data = append(data, util.Htonl(neighbor)...)
+		}
+	case LS_UPDATE:
+		data = append(data, util.Htons(uint16(len(lsPacket.LSAs)))...)
+		data = append(data, util.Htonl(lsPacket.Router)...)
+		for _, lsa := range lsPacket.LSAs {
+			data = append(data, SerializeLSA(lsa)...)
+		}
+	case LS_ACK:
+		data = append(data, util.Htons(uint16(len(lsPacket.Acks)))...)
+		data = append(data, util.Htonl(lsPacket.Router)...)
+		for _, ack := range lsPacket.Acks {
+			data = append(data, util.Htonl(ack.Router)...)
+			data = append(data, util.Htonl(ack.Seq)...)
+		}
+	}
+	return data
+}
+
+// Parses an LSPacket.
+func DeserializeLSPacket(data []byte) (lsPacket LSPacket, err error) {
+	if len(data) < 8 {
+		return lsPacket, errors.New("not enough data")
+	}
+	lsPacket.Type = util.Ntohs(data[0:2])
+	count := int(util.Ntohs(data[2:4]))
+	lsPacket.Router = util.Ntohl(data[4:8])
+	data = data[8:]
+	switch lsPacket.Type {
+	case LS_HELLO:
+		if len(data) < count*4 {
+			return lsPacket, errors.New("not enough data")
+		}
+		lsPacket.Neighbors = make([]uint32, count)
+		for i := 0; i < count; i++ {
+			lsPacket.Neighbors[i] = util.Ntohl(data[i*4 : (i+1)*4])
+		}
+	case LS_UPDATE:
+		lsPacket.LSAs = make([]LSA, count)
+		for i := 0; i < count; i++ {
+			var n int
+			lsPacket.LSAs[i], n, err = DeserializeLSA(data)
+			if err != nil {
+				return lsPacket, err
+			}
+			data = data[n:]
+		}
+	case LS_ACK:
+		if len(data) < count*8 {
+			return lsPacket, errors.New("not enough data")
+		}
+		lsPacket.Acks = make([]LSAck, count)
+		for i := 0; i < count; i++ {
+			lsPacket.Acks[i] = LSAck{
+				Router: util.Ntohl(data[i*8 : i*8+4]),
+				Seq:    util.Ntohl(data[i*8+4 : i*8+8]),
+			}
+		}
+	default:
+		return lsPacket, errors.New("invalid type")
+	}
+	return lsPacket, nil
+}
+
+// Serializes an LSA.
+func SerializeLSA(lsa LSA) (data []byte) {
+	data = make([]byte, 0)
+	data = append(data, util.Htonl(lsa.Router)...)
+	data = append(data, util.Htonl(lsa.Seq)...)
+	data = append(data, util.Htons(lsa.Age)...)
+	data = append(data, util.Htons(uint16(len(lsa.Links)))...)
+	data = append(data, util.Htons(uint16(len(lsa.Prefixes)))...)
+	data = append(data, util.Htons(0)...)
+	for _, link := range lsa.Links {
+		data = append(data, util.Htonl(link.Neighbor)...)
+		data = append(data, util.Htonl(link.Cost)...)
+	}
+	for _, prefix := range lsa.Prefixes {
+		data = append(data, util.Htonl(prefix.Addr)...)
+		data = append(data, util.Htonl(prefix.Mask)...)
+		data = append(data, util.Htonl(prefix.Cost)...)
+	}
+	return data
+}
+
+// Parses an LSA, returning the number of bytes consumed.
+func DeserializeLSA(data []byte) (lsa LSA, n int, err error) {
+	if len(data) < 16 {
+		return lsa, 0, errors.New("not enough data")
+	}
+	lsa.Router = util.Ntohl(data[0:4])
+	lsa.Seq = util.Ntohl(data[4:8])
+	lsa.Age = util.Ntohs(data[8:10])
+	numLinks := int(util.Ntohs(data[10:12]))
+	numPrefixes := int(util.Ntohs(data[12:14]))
+	n = 16 + numLinks*8 + numPrefixes*12
+	if len(data) < n {
+		return lsa, 0, errors.New("not enough data")
+	}
+	lsa.Links = make([]LSLink, numLinks)
+	for i := 0; i < numLinks; i++ {
+		buf := data[16+i*8:]
+		lsa.Links[i] = LSLink{
+			Neighbor: util.Ntohl(buf[0:4]),
+			Cost:     util.Ntohl(buf[4:8]),
+		}
+	}
+	lsa.Prefixes = make([]LSPrefix, numPrefixes)
+	for i := 0; i < numPrefixes; i++ {
+		buf := data[16+numLinks*8+i*12:]
+		lsa.Prefixes[i] = LSPrefix{
+			Addr: util.Ntohl(buf[0:4]),
+			Mask: util.Ntohl(buf[4:8]),
+			Cost: util.Ntohl(buf[8:12]),
+		}
+	}
+	return lsa, n, nil
+}
+
+// lsdbEntry is an LSA in the database along with when we installed it.
+type lsdbEntry struct {
+	lsa       LSA
+	installed time.Time
+}
+
+// Gets the current age of the entry in seconds.
+func (e *lsdbEntry) age() uint16 {
+	age := uint32(e.lsa.Age) + uint32(time.Since(e.installed)/time.Second)
+	if age > uint32(util.LS_MAX_AGE) {
+		return util.LS_MAX_AGE
+	}
+	return uint16(age)
+}
+
+// lsNeighbor is the adjacency state for the router on the other end of an interface.
+type lsNeighbor struct {
+	router  uint32
+	twoWay  bool
+	dead    *time.Timer
+	pending map[uint32]LSA // LSAs awaiting acknowledgement, by originating router.
+}
+
+// lsState is all of the link-state protocol state for a node.
+type lsState struct {
+	routerID  uint32
+	seq       uint32
+	lsdb      map[uint32]*lsdbEntry
+	neighbors map[int]*lsNeighbor // By interface number.
+	mtx       sync.Mutex
+}
+
+// Sets the link-state flag for the node. Must be called before Run.
+func (node *Node) SetLinkState(flag bool) {
+	node.LinkState = flag
+	if flag {
+		routerID := uint32(0)
+		for _, interf := range node.LocalInterfaces {
+			addr := util.IP2int(interf.Addr)
+			if routerID == 0 || addr < routerID {
+				routerID = addr
+			}
+		}
+		node.ls = &lsState{
+			routerID:  routerID,
+			lsdb:      make(map[uint32]*lsdbEntry),
+			neighbors: make(map[int]*lsNeighbor),
+		}
+	}
+}
+
+// Handles link-state data.
+func LSHandler(node *Node, packet *IPPacket, linkID int) error {
+	if !node.LinkState || !node.bfdAllows(linkID) {
+		return nil
+	}
+	lsPacket, err := DeserializeLSPacket(packet.Data)
+	if err != nil {
+		return err
+	}
+	lsLog.Debug("received link-state packet", "type", lsPacket.Type, "from", packet.Header.Src)
+	node.ls.mtx.Lock()
+	defer node.ls.mtx.Unlock()
+	switch lsPacket.Type {
+	case LS_HELLO:
+		node.handleLSHello(lsPacket, linkID)
+	case LS_UPDATE:
+		node.handleLSUpdate(lsPacket, linkID)
+	case LS_ACK:
+		if neighbor, exists := node.ls.neighbors[linkID]; exists && neighbor.router == lsPacket.Router {
+			for _, ack := range lsPacket.Acks {
+				if lsa, exists := neighbor.pending[ack.Router]; exists && lsa.Seq <= ack.Seq {
+					delete(neighbor.pending, ack.Router)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Handles a hello, bringing up the adjacency if needed. ls.mtx held on entry.
+func (node *Node) handleLSHello(lsPacket LSPacket, linkID int) {
+	neighbor, exists := node.ls.neighbors[linkID]
+	if exists && neighbor.router != lsPacket.Router {
+		// A different router is on the end of this link now.
+		neighbor.dead.Stop()
+		delete(node.ls.neighbors, linkID)
+		exists = false
+	}
+	if !exists {
+		neighbor = &lsNeighbor{
+			router:  lsPacket.Router,
+			pending: make(map[uint32]LSA),
+		}
+		neighbor.dead = time.AfterFunc(util.LS_DEAD_INTERVAL, node.newLSDeadTimer(linkID, neighbor))
+		node.ls.neighbors[linkID] = neighbor
+	} else {
+		neighbor.dead.Reset(util.LS_DEAD_INTERVAL)
+	}
+	// Check if the neighbour has heard us too.
+	twoWay := false
+	for _, router := range lsPacket.Neighbors {
+		if router == node.ls.routerID {
+			twoWay = true
+			break
+		}
+	}
+	if twoWay == neighbor.twoWay {
+		return
+	}
+	neighbor.twoWay = twoWay
+	if twoWay {
+		// Synchronize databases by flooding everything we have to the new neighbour.
+		for _, entry := range node.ls.lsdb {
+			lsa := entry.lsa
+			lsa.Age = entry.age()
+			neighbor.pending[lsa.Router] = lsa
+		}
+		node.sendLSPending(linkID, neighbor)
+	}
+	node.originateLSA()
+	node.runSPF()
+}
+
+// Handles a link-state update. ls.mtx held on entry.
+func (node *Node) handleLSUpdate(lsPacket LSPacket, linkID int) {
+	neighbor, exists := node.ls.neighbors[linkID]
+	if !exists || !neighbor.twoWay || neighbor.router != lsPacket.Router {
+		return
+	}
+	acks := make([]LSAck, 0)
+	changed := false
+	for _, lsa := range lsPacket.LSAs {
+		acks = append(acks, LSAck{Router: lsa.Router, Seq: lsa.Seq})
+		current, exists := node.ls.lsdb[lsa.Router]
+		if lsa.Router == node.ls.routerID {
+			// Someone has an old copy of our own LSA; jump past it.
+			if !exists || lsa.Seq >= current.lsa.Seq {
+				node.ls.seq = lsa.Seq
+				node.originateLSA()
+			}
+			continue
+		}
+		if exists && lsa.Seq < current.lsa.Seq {
+			// The neighbour is out of date; send our copy back.
+			newer := current.lsa
+			newer.Age = current.age()
+			neighbor.pending[newer.Router] = newer
+			continue
+		}
+		if exists && lsa.Seq == current.lsa.Seq {
+			// Implied acknowledgement.
+			if pending, exists := neighbor.pending[lsa.Router]; exists && pending.Seq == lsa.Seq {
+				delete(neighbor.pending, lsa.Router)
+			}
+			continue
+		}
+		if lsa.Age >= util.LS_MAX_AGE {
+			continue
+		}
+		// Install and flood out of every other adjacency.
+		node.ls.lsdb[lsa.Router] = &lsdbEntry{lsa: lsa, installed: time.Now()}
+		node.floodLSA(lsa, linkID)
+		changed = true
+	}
+	// Acknowledge everything we got, then resend anything the neighbour is missing.
+	node.sendLS(linkID, LSPacket{Type: LS_ACK, Router: node.ls.routerID, Acks: acks})
+	node.sendLSPending(linkID, neighbor)
+	if changed {
+		node.runSPF()
+	}
+}
+
+// Creates a timer callback that tears down the adjacency on the given interface.
+func (node *Node) newLSDeadTimer(linkID int, neighbor *lsNeighbor) func() {
+	return func() {
+		node.ls.mtx.Lock()
+		defer node.ls.mtx.Unlock()
+		if node.ls.neighbors[linkID] != neighbor {
+			return
+		}
+		lsLog.Info("neighbour is dead", "if", linkID)
+		delete(node.ls.neighbors, linkID)
+		if neighbor.twoWay {
+			node.originateLSA()
+			node.runSPF()
+		}
+	}
+}
+
+// Called when an interface is brought up or down.
+func (node *Node) lsInterfaceChanged(linkID int) {
+	node.ls.mtx.Lock()
+	defer node.ls.mtx.Unlock()
+	if neighbor, exists := node.ls.neighbors[linkID]; exists {
+		neighbor.dead.Stop()
+		delete(node.ls.neighbors, linkID)
+	}
+	node.originateLSA()
+	node.runSPF()
+}
+
+// Originates a new instance of our own LSA and floods it. ls.mtx held on entry.
+func (node *Node) originateLSA() {
+	node.ls.seq++
+	lsa := LSA{
+		Router:   node.ls.routerID,
+		Seq:      node.ls.seq,
+		Links:    make([]LSLink, 0),
+		Prefixes: make([]LSPrefix, 0),
+	}
+	for i, interf := range node.LocalInterfaces {
+		interf.Lock.RLock()
+		enabled := interf.Enabled
+		interf.Lock.RUnlock()
+		if !enabled {
+			continue
+		}
+		lsa.Prefixes = append(lsa.Prefixes, LSPrefix{
+			Addr: util.IP2int(interf.Addr),
+			Mask: util.IP2int(util.DEFAULT_MASK),
+			Cost: 0,
+		})
+		if neighbor, exists := node.ls.neighbors[i]; exists && neighbor.twoWay {
+			node.policyMtx.RLock()
+			lsa.Links = append(lsa.Links, LSLink{Neighbor: neighbor.router, Cost: interf.Cost})
+			node.policyMtx.RUnlock()
+		}
+	}
+	node.ls.lsdb[lsa.Router] = &lsdbEntry{lsa: lsa, installed: time.Now()}
+	node.floodLSA(lsa, -1)
+}
+
+// Floods an LSA to every adjacency except the one it came in on. ls.mtx held on entry.
+func (node *Node) floodLSA(lsa LSA, fromLinkID int) {
+	for linkID, neighbor := range node.ls.neighbors {
+		if linkID == fromLinkID || !neighbor.twoWay {
+			continue
+		}
+		neighbor.pending[lsa.Router] = lsa
+		node.sendLS(linkID, LSPacket{Type: LS_UPDATE, Router: node.ls.routerID, LSAs: []LSA{lsa}})
+	}
+}
+
+// Sends all unacknowledged LSAs to a neighbour. ls.mtx held on entry.
+func (node *Node) sendLSPending(linkID int, neighbor *lsNeighbor) {
+	if len(neighbor.pending) == 0 {
+		return
+	}
+	lsas := make([]LSA, 0, len(neighbor.pending))
+	for _, lsa := range neighbor.pending {
+		lsas = append(lsas, lsa)
+	}
+	node.sendLS(linkID, LSPacket{Type: LS_UPDATE, Router: node.ls.routerID, LSAs: lsas})
+}
+
+// Sends a link-state packet out of the given interface.
+func (node *Node) sendLS(linkID int, lsPacket LSPacket) {
+	interf := node.LocalInterfaces[linkID]
+	packet := NewIPPacket(util.LS_PROTO, SerializeLSPacket(lsPacket), util.DEFAULT_TTL, interf.Addr, interf.Remote)
+	interf.Send(node.UDPConn, packet)
+}
+
+// Sends a hello out of each interface. ls.mtx held on entry.
+func (node *Node) sendLSHellos() {
+	for linkID := range node.LocalInterfaces {
+		neighbors := make([]uint32, 0)
+		if neighbor, exists := node.ls.neighbors[linkID]; exists {
+			neighbors = append(neighbors, neighbor.router)
+		}
+		node.sendLS(linkID, LSPacket{Type: LS_HELLO, Router: node.ls.routerID, Neighbors: neighbors})
+	}
+}
+
+// Runs the link-state protocol in place of RIP.
+func (node *Node) runLinkState() {
+	node.ls.mtx.Lock()
+	node.originateLSA()
+	node.sendLSHellos()
+	node.ls.mtx.Unlock()
+	hello := time.NewTicker(util.LS_HELLO_INTERVAL)
+	rxmt := time.NewTicker(util.LS_RXMT_INTERVAL)
+	refresh := time.NewTicker(util.LS_REFRESH_INTERVAL)
+	defer hello.Stop()
+	defer rxmt.Stop()
+	defer refresh.Stop()
+	for {
+		select {
+		case <-hello.C:
+			node.ls.mtx.Lock()
+			node.sendLSHellos()
+			node.ageLSDB()
+			node.ls.mtx.Unlock()
+		case <-rxmt.C:
+			node.ls.mtx.Lock()
+			for linkID, neighbor := range node.ls.neighbors {
+				if neighbor.twoWay {
+					node.sendLSPending(linkID, neighbor)
+				}
+			}
+			node.ls.mtx.Unlock()
+		case <-refresh.C:
+			node.ls.mtx.Lock()
+			node.originateLSA()
+			node.ls.mtx.Unlock()
+		case <-node.ctx.Done():
+			return
+		}
+	}
+}
+
+// Removes LSAs that have reached the maximum age. ls.mtx held on entry.
+func (node *Node) ageLSDB() {
+	changed := false
+	for router, entry := range node.ls.lsdb {
+		if router != node.ls.routerID && entry.age() >= util.LS_MAX_AGE {
+			lsLog.Debug("expiring LSA", "router", util.Int2IP(router))
+			delete(node.ls.lsdb, router)
+			changed = true
+		}
+	}
+	if changed {
+		node.runSPF()
+	}
+}
+
+// Runs Dijkstra over the database and installs the resulting routes. ls.mtx held on entry.
+func (node *Node) runSPF() {
+	self := node.ls.routerID
+	dist := map[uint32]uint32{self: 0}
+	firstHop := make(map[uint32]*Interface)
+	done := make(map[uint32]bool)
+	for {
+		// Pick the closest router we haven't finalized yet.
+		u, found := uint32(0), false
+		for router, d := range dist {
+			if !done[router] && (!found || d < dist[u]) {
+				u, found = router, true
+			}
+		}
+		if !found {
+			break
+		}
+		done[u] = true
+		entry, exists := node.ls.lsdb[u]
+		if !exists {
+			continue
+		}
+		// Relax each bidirectional link.
+		for _, link := range entry.lsa.Links {
+			if !node.lsBidirectional(link.Neighbor, u) {
+				continue
+			}
+			if d, seen := dist[link.Neighbor]; seen && d <= dist[u]+link.Cost {
+				continue
+			}
+			dist[link.Neighbor] = dist[u] + link.Cost
+			if u == self {
+				for linkID, neighbor := range node.ls.neighbors {
+					if neighbor.router == link.Neighbor && neighbor.twoWay {
+						firstHop[link.Neighbor] = node.LocalInterfaces[linkID]
+						break
+					}
+				}
+			} else {
+				firstHop[link.Neighbor] = firstHop[u]
+			}
+		}
+	}
+	// Compute the best route to each advertised prefix.
+	routes := make(map[Route]*Entry)
+	for router, d := range dist {
+		interf, exists := firstHop[router]
+		if router == self || !exists {
+			continue
+		}
+		for _, prefix := range node.ls.lsdb[router].lsa.Prefixes {
+			route := NewRoute(prefix.Addr, prefix.Mask)
+			if best, exists := routes[route]; !exists || d+prefix.Cost < best.Cost {
+				routes[route] = &Entry{Interface: interf, Cost: d + prefix.Cost, Source: SRC_LS}
+			}
+		}
+	}
+	// Swap the new routes into the routing information base.
+	node.rtMtx.Lock()
+	defer node.rtMtx.Unlock()
+	for route, candidates := range node.rib {
+		if _, exists := candidates[SRC_LS]; exists {
+			if _, keep := routes[route]; !keep {
+				node.removeRoute(route, SRC_LS, ROUTE_WITHDRAWN, CAUSE_SPF)
+			}
+		}
+	}
+	for route, entry := range routes {
+		current, exists := node.candidate(route, SRC_LS)
+		if !exists || current.Cost != entry.Cost || current.Interface != entry.Interface {
+			node.setCandidate(route, entry, CAUSE_SPF)
+		}
+	}
+}
+
+// Checks that a link from u to v is also advertised from v to u. ls.mtx held on entry.
+func (node *Node) lsBidirectional(u uint32, v uint32) bool {
+	entry, exists := node.ls.lsdb[u]
+	if !exists {
+		return false
+	}
+	for _, link := range entry.lsa.Links {
+		if link.Neighbor == v {
+			return true
+		}
+	}
+	return false
+}
+
+// Prints the link-state database and adjacencies.
+func (node *Node) printLSDB() {
+	if !node.LinkState {
+		log.Println("link-state routing is not enabled")
+		return
+	}
+	node.ls.mtx.Lock()
+	defer node.ls.mtx.Unlock()
+	log.Printf("router id %v\n", util.Int2IP(node.ls.routerID))
+	log.Printf("if\tneighbor\tstate\n")
+	for linkID, neighbor := range node.ls.neighbors {
+		state := "INIT"
+		if neighbor.twoWay {
+			state = "FULL"
+		}
+		log.Printf("%v\t%v\t%v\n", linkID, util.Int2IP(neighbor.router), state)
+	}
+	log.Printf("router\t\tseq\tage\tlinks\tprefixes\n")
+	for router, entry := range node.ls.lsdb {
+		log.Printf("%v\t%v\t%v\t%v\t%v\n", util.Int2IP(router), entry.lsa.Seq, entry.age(), len(entry.lsa.Links), len(entry.lsa.Prefixes))
+	}
+}
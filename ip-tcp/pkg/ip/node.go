@@ -1,11 +1,12 @@
 package pkg
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
 	"net"
-	"os"
 	"strconv"
 	"strings"
 	"sync"
@@ -14,124 +15,274 @@ import (
 	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
 )
 
+var ipLog = util.NewLogger("ip")
+
 // Interface is a network line that we can send data on.
 type Interface struct {
+	Name      string
 	Port      int
 	UDPTarget *net.UDPAddr
 	Addr      net.IP
 	Remote    net.IP
 	Lock      sync.RWMutex
 	Enabled   bool
+	Cost      uint32        // Metric added to routes learned on this interface.
+	Import    Filter        // Policy for routes learned on this interface.
+	Export    Filter        // Policy for routes advertised on this interface.
+	MTU       int           // Largest packet we send, or 0 for no limit.
+	Loss      float64       // Fraction of outgoing packets to drop.
+	Delay     time.Duration // Added to every outgoing packet.
+	stats     interfaceCounters
+	delayMtx  sync.Mutex
+	delayed   map[*time.Timer]bool // Timers holding back delayed packets, so Close can stop them.
 }
 
 // Send sends the provided packet along the provided connection.
 func (interf *Interface) Send(conn *net.UDPConn, packet *IPPacket) {
 	interf.Lock.RLock()
-	if interf.Enabled {
-		buf := packet.Serialize()
-		conn.WriteToUDP(buf, interf.UDPTarget)
+	defer interf.Lock.RUnlock()
+	if !interf.Enabled {
+		interf.stats.dropped.Inc()
+		return
+	}
+	buf := packet.Serialize()
+	// Apply the configured impairments.
+	if interf.MTU > 0 && len(buf) > interf.MTU {
+		ipLog.Debug("dropping packet larger than mtu", "if", interf.Name, "len", len(buf), "mtu", interf.MTU)
+		interf.stats.dropped.Inc()
+		return
+	}
+	if interf.Loss > 0 && rand.Float64() < interf.Loss {
+		interf.stats.dropped.Inc()
+		return
+	}
+	interf.stats.sent(len(buf))
+	if interf.Delay > 0 {
+		interf.sendLater(conn, buf, interf.UDPTarget)
+		return
 	}
-	interf.Lock.RUnlock()
+	conn.WriteToUDP(buf, interf.UDPTarget)
+}
+
+// Sends a packet once the interface's delay has passed.
+func (interf *Interface) sendLater(conn *net.UDPConn, buf []byte, target *net.UDPAddr) {
+	interf.delayMtx.Lock()
+	defer interf.delayMtx.Unlock()
+	if interf.delayed == nil {
+		interf.delayed = make(map[*time.Timer]bool)
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(interf.Delay, func() {
+		interf.delayMtx.Lock()
+		delete(interf.delayed, timer)
+		interf.delayMtx.Unlock()
+		conn.WriteToUDP(buf, target)
+	})
+	interf.delayed[timer] = true
+}
+
+// Drops packets still waiting out the interface's delay.
+func (interf *Interface) stopDelayed() {
+	interf.delayMtx.Lock()
+	defer interf.delayMtx.Unlock()
+	for timer := range interf.delayed {
+		timer.Stop()
+	}
+	interf.delayed = nil
 }
 
 // Entry is an entry in the routing table, pointed to by an IP.
 type Entry struct {
 	Interface *Interface
 	Cost      uint32
+	Tag       uint32
+	Source    RouteSource // Where the route came from.
+	Detail    string      // Extra information shown by lr, such as a BGP AS path.
 	Death     *time.Timer
+	Expires   time.Time // When Death fires, for learned routes.
+	Stale     bool      // Reloaded from a state file and not yet refreshed by a neighbour.
 }
 
 // Node is the main holding struct for a process.
 type Node struct {
-	UDPConn         *net.UDPConn
-	Handlers        map[uint8]func(*Node, *IPPacket, int) error
-	LocalInterfaces []*Interface
-	RoutingTable    map[Route]*Entry // key = net.IP.String()
-	rtMtx           sync.RWMutex
-	ICMPChan        chan net.IP
-	Aggregate       bool
+	UDPConn           *net.UDPConn
+	Handlers          map[uint8]func(*Node, *IPPacket, int) error
+	LocalInterfaces   []*Interface
+	RoutingTable      map[Route]*Entry                 // Forwarding table: the best candidate for each prefix.
+	rib               map[Route]map[RouteSource]*Entry // Candidate routes for each prefix, by source.
+	Distances         map[RouteSource]uint8            // Administrative distance of each source.
+	StaticRoutes      []StaticRoute                    // Configured static routes.
+	rtMtx             sync.RWMutex
+	ICMPChan          chan net.IP
+	Aggregate         bool
+	LinkState         bool
+	RIPEnabled        bool          // Whether RIP runs when link-state doesn't.
+	RIPUpdateInterval time.Duration // Interval between periodic RIP updates.
+	RIPTimeout        time.Duration // How long a RIP route lives without being refreshed.
+	TCP               TCPConfig     // Defaults for the TCP stack.
+	ls                *lsState
+	bfd               *bfdState
+	PrefixLists       map[string]*PrefixList
+	Summaries         []Summary
+	statePath         string        // Where learned routes are saved, if anywhere.
+	stateInterval     time.Duration // How often learned routes are saved.
+	ASN               uint32        // Our autonomous system number, or 0 if we don't speak BGP.
+	BGPNeighbors      []BGPNeighbor // Configured eBGP peers.
+	commands          map[string]func([]string)
+	subscribers       []chan RouteEvent
+	routeChanges      map[RouteEventType]uint64 // Forwarding table changes by type. Guarded by subMtx.
+	subMtx            sync.Mutex
+	ripSent           ripCounters
+	ripReceived       ripCounters
+	raw               map[uint8][]*RawSocket // Open raw sockets, by protocol.
+	rawMtx            sync.RWMutex
+	policyMtx         sync.RWMutex
+	ctx               context.Context // Cancelled by Close.
+	cancel            context.CancelFunc
+	wg                sync.WaitGroup // Goroutines started by Run.
+	closeOnce         sync.Once
 }
 
 // Creates a new node from the provided Lnx file.
 func NewNode(filename string) (*Node, error) {
+	cfg, err := ParseLnx(filename)
+	if err != nil {
+		return nil, err
+	}
+	return NewNodeFromConfig(cfg)
+}
+
+// Creates a new node from a configuration, which is validated first.
+func NewNodeFromConfig(cfg *Config) (*Node, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
 	// Initialize fields.
 	node := &Node{
-		RoutingTable: make(map[Route]*Entry),
-		Handlers:     make(map[uint8]func(*Node, *IPPacket, int) error),
-		ICMPChan:     make(chan net.IP),
-		Aggregate:    false,
+		RoutingTable:      make(map[Route]*Entry),
+		rib:               make(map[Route]map[RouteSource]*Entry),
+		Distances:         make(map[RouteSource]uint8),
+		Handlers:          make(map[uint8]func(*Node, *IPPacket, int) error),
+		ICMPChan:          make(chan net.IP),
+		Aggregate:         cfg.Routing.Aggregate,
+		RIPEnabled:        cfg.Routing.Protocol != "none",
+		RIPUpdateInterval: cfg.Routing.ripUpdateInterval(),
+		RIPTimeout:        cfg.Routing.ripTimeout(),
+		TCP:               cfg.TCP,
+		PrefixLists:       make(map[string]*PrefixList),
+		commands:          make(map[string]func([]string)),
+		routeChanges:      make(map[RouteEventType]uint64),
+		raw:               make(map[uint8][]*RawSocket),
+	}
+	node.ctx, node.cancel = context.WithCancel(context.Background())
+
+	for source, d := range defaultDistances {
+		node.Distances[source] = d
 	}
 
 	// Register necessary protocol handlers.
 	node.RegisterHandler(1, ICMPHandler)
 	node.RegisterHandler(200, RIPHandler)
-
-	// Open Lnx file.
-	file, err := os.Open(filename)
-	if err != nil {
-		return node, err
-	}
-	defer file.Close()
-	fileReader := bufio.NewScanner(file)
-
-	// Get local node info
-	fileReader.Scan()
-	text := fileReader.Text()
-	tokens := strings.Split(text, " ")
-	servername := tokens[0]
-	udpPort := tokens[1]
-	connStr := fmt.Sprintf("%v:%v", servername, udpPort)
+	node.RegisterHandler(util.LS_PROTO, LSHandler)
+	node.RegisterHandler(util.BFD_PROTO, BFDHandler)
 
 	// Start the main UDP listener.
-	localAddr, err := net.ResolveUDPAddr("udp4", connStr)
+	localAddr, err := net.ResolveUDPAddr("udp4", cfg.Listen)
 	if err != nil {
-		return node, err
+		return nil, fmt.Errorf("listen: %v", err)
 	}
 	conn, err := net.ListenUDP("udp4", localAddr)
 	if err != nil {
-		return node, err
+		return nil, fmt.Errorf("listen: %v", err)
 	}
 	node.UDPConn = conn
 
-	// Get other connection info
+	// Create the interfaces.
 	node.LocalInterfaces = make([]*Interface, 0)
-	for fileReader.Scan() {
-		// For each line, get the info and resolve the addresses.
-		text := fileReader.Text()
-		tokens := strings.Split(text, " ")
-		remoteServerName := tokens[0]
-		remoteUDPPort, err := strconv.Atoi(tokens[1])
+	for i, interfCfg := range cfg.Interfaces {
+		remoteAddr, err := net.ResolveUDPAddr("udp4", interfCfg.Remote)
 		if err != nil {
-			return node, err
+			conn.Close()
+			return nil, fmt.Errorf("interfaces[%v].remote: %v", i, err)
 		}
-		localIP := tokens[2]
-		remoteIP := tokens[3]
-		remoteConnStr := fmt.Sprintf("%v:%v", remoteServerName, remoteUDPPort)
-		remoteAddr, err := net.ResolveUDPAddr("udp4", remoteConnStr)
-		if err != nil {
-			return node, err
+		cost := interfCfg.Cost
+		if cost == 0 {
+			cost = 1
 		}
-
-		// Create the interface.
 		newInterface := &Interface{
-			Port:      remoteUDPPort,
+			Name:      interfCfg.Name,
+			Port:      remoteAddr.Port,
 			UDPTarget: remoteAddr,
-			Addr:      net.ParseIP(localIP),
-			Remote:    net.ParseIP(remoteIP),
-			Enabled:   true,
+			Addr:      net.ParseIP(interfCfg.Addr),
+			Remote:    net.ParseIP(interfCfg.RemoteAddr),
+			Enabled:   !interfCfg.Down,
+			Cost:      cost,
+			MTU:       interfCfg.MTU,
+			Loss:      interfCfg.Loss,
+			Delay:     time.Duration(interfCfg.Delay),
+			Import:    Filter{DenyTags: make(map[uint32]bool)},
+			Export:    Filter{DenyTags: make(map[uint32]bool)},
 		}
 
 		// Register local address in routing table
-		route := NewRoute(util.IP2int(net.ParseIP(localIP)), util.IP2int(util.DEFAULT_MASK))
-		newEntry := &Entry{
-			Interface: newInterface,
-			Cost:      0,
+		if newInterface.Enabled {
+			route := NewRoute(util.IP2int(newInterface.Addr), util.IP2int(util.DEFAULT_MASK))
+			newEntry := &Entry{
+				Interface: newInterface,
+				Cost:      0,
+				Source:    SRC_CONNECTED,
+			}
+			node.setRoute(route, newEntry, CAUSE_CONNECTED)
 		}
-		node.setRoute(route, newEntry)
 
 		// Add new interface to local interfaces
 		node.LocalInterfaces = append(node.LocalInterfaces, newInterface)
 	}
+
+	// Apply static routes, BGP and policy, which refer to the interfaces.
+	for i, static := range cfg.StaticRoutes {
+		route, _ := ParsePrefix(static.Prefix)
+		cost := static.Cost
+		if cost == 0 {
+			cost = 1
+		}
+		if err := node.SetStaticRoute(StaticRoute{Route: route, NextHop: net.ParseIP(static.Via), Cost: cost}); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("static_routes[%v]: %v", i, err)
+		}
+	}
+	if cfg.BGP != nil {
+		node.ASN = cfg.BGP.AS
+		for _, neighbor := range cfg.BGP.Neighbors {
+			localPref := util.BGP_DEFAULT_LOCAL_PREF
+			if neighbor.LocalPref != nil {
+				localPref = *neighbor.LocalPref
+			}
+			node.BGPNeighbors = append(node.BGPNeighbors, BGPNeighbor{
+				Addr:      net.ParseIP(neighbor.Addr),
+				RemoteAS:  neighbor.RemoteAS,
+				LocalPref: localPref,
+			})
+		}
+	}
+	for i, directive := range cfg.Policy {
+		if err := node.ApplyPolicy(strings.Fields(directive)); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("policy[%v] %q: %v", i, directive, err)
+		}
+	}
+	node.SetLinkState(cfg.Routing.Protocol == "ls")
+	if bfd := cfg.Routing.BFD; bfd != nil {
+		interval, mult := time.Duration(bfd.Interval), bfd.Mult
+		if interval == 0 {
+			interval = util.BFD_DEFAULT_INTERVAL
+		}
+		if mult == 0 {
+			mult = util.BFD_DEFAULT_MULT
+		}
+		node.SetBFD(interval, mult)
+	}
+
 	// Print interfaces on startup
 	for i, interf := range node.LocalInterfaces {
 		log.Printf("%v: %v\n", i, interf.Addr.String())
@@ -144,10 +295,25 @@ func (node *Node) RegisterHandler(pNum uint8, handler func(*Node, *IPPacket, int
 	node.Handlers[pNum] = handler
 }
 
+// Registers a REPL command. Handlers print their output and are called with the full line.
+func (node *Node) RegisterCommand(name string, handler func(tokens []string)) {
+	node.commands[name] = handler
+}
+
 // Run runs the node.
 func (node *Node) Run(runRepl bool) {
-	go node.handleUDPListen()
-	go node.sendRIPUpdates()
+	node.spawn(node.handleUDPListen)
+	if node.LinkState {
+		node.spawn(node.runLinkState)
+	} else if node.RIPEnabled {
+		node.spawn(node.sendRIPUpdates)
+	}
+	if node.bfd != nil {
+		node.spawn(node.runBFD)
+	}
+	if node.statePath != "" {
+		node.spawn(node.saveStatePeriodically)
+	}
 	if runRepl {
 		// Init the REPL
 		readyChan := make(chan bool)
@@ -167,6 +333,75 @@ func (node *Node) Run(runRepl bool) {
 	}
 }
 
+// Runs f in a goroutine that Close waits for.
+func (node *Node) spawn(f func()) {
+	node.wg.Add(1)
+	go func() {
+		defer node.wg.Done()
+		f()
+	}()
+}
+
+// Gets a context that is cancelled when the node is closed, for anything built on top of it.
+func (node *Node) Context() context.Context {
+	return node.ctx
+}
+
+// Stops every goroutine and timer the node started, closes its UDP socket and closes the channels
+// of route event subscribers. Anything using the node's context, such as a TCP driver, is cancelled
+// too. Safe to call more than once.
+func (node *Node) Close() error {
+	var err error
+	node.closeOnce.Do(func() {
+		node.cancel()
+		err = node.UDPConn.Close()
+		node.wg.Wait()
+		node.stopTimers()
+		node.subMtx.Lock()
+		for _, ch := range node.subscribers {
+			close(ch)
+		}
+		node.subscribers = nil
+		node.subMtx.Unlock()
+	})
+	return err
+}
+
+// Stops the timers that expire routes, link-state neighbours and BFD sessions, and that hold back
+// delayed packets.
+func (node *Node) stopTimers() {
+	for _, interf := range node.LocalInterfaces {
+		interf.stopDelayed()
+	}
+	node.rtMtx.Lock()
+	for _, candidates := range node.rib {
+		for _, entry := range candidates {
+			if entry.Death != nil {
+				entry.Death.Stop()
+			}
+		}
+	}
+	node.rtMtx.Unlock()
+	if node.ls != nil {
+		node.ls.mtx.Lock()
+		for _, neighbor := range node.ls.neighbors {
+			if neighbor.dead != nil {
+				neighbor.dead.Stop()
+			}
+		}
+		node.ls.mtx.Unlock()
+	}
+	if node.bfd != nil {
+		node.bfd.mtx.Lock()
+		for _, session := range node.bfd.sessions {
+			if session != nil && session.detect != nil {
+				session.detect.Stop()
+			}
+		}
+		node.bfd.mtx.Unlock()
+	}
+}
+
 // Sends the provided data.
 func (node *Node) Send(proto uint8, data []byte, ttl uint8, src net.IP, dst net.IP) {
 	node.SendPacket(NewIPPacket(proto, data, ttl, src, dst))
@@ -174,10 +409,15 @@ func (node *Node) Send(proto uint8, data []byte, ttl uint8, src net.IP, dst net.
 
 // Sends the provided packet.
 func (node *Node) SendPacket(packet *IPPacket) {
-	util.Debug.Printf("sending packet %v\n", packet)
+	ipLog.Debug("sending packet", "src", packet.Header.Src, "dst", packet.Header.Dst, "proto", packet.Header.Proto, "ttl", packet.Header.Ttl, "len", len(packet.Data))
 	entry, found, _ := node.matchRoute(packet.Header.Dst, 32)
 	if found {
 		entry.Interface.Send(node.UDPConn, packet)
+		return
+	}
+	// Neighbours are always reachable over their link, even if routing doesn't run on it.
+	if linkID, ok := node.NeighborInterface(packet.Header.Dst); ok {
+		node.LocalInterfaces[linkID].Send(node.UDPConn, packet)
 	}
 }
 
@@ -189,20 +429,28 @@ func (node *Node) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 		// Print out all of the routes.
 		log.Printf("cost\tdst\t\tloc\n")
 		for route, entry := range node.RoutingTable {
-			log.Printf("%v\t%v/%v\t%v\n",
+			line := fmt.Sprintf("%v\t%v/%v\t%v",
 				entry.Cost, util.Int2IP(route.Addr), util.MaskLen(util.Int2IP(route.Mask)), entry.Interface.Addr.String())
+			if entry.Tag != 0 {
+				line += fmt.Sprintf("\ttag %v", entry.Tag)
+			}
+			if entry.Source == SRC_BGP {
+				line += fmt.Sprintf("\tbgp %v", entry.Detail)
+			}
+			if entry.Stale {
+				line += "\tstale"
+			}
+			log.Println(line)
 		}
 
 	case "li", "interfaces":
 		// Print out all of the interfaces.
 		log.Printf("id\trem\t\tloc\n")
-		for i, interf := range node.LocalInterfaces {
-			interf.Lock.RLock()
-			if interf.Enabled {
+		for _, info := range node.Interfaces() {
+			if info.Up {
 				log.Printf("%v\t%v\t%v\n",
-					i, interf.Remote.String(), interf.Addr.String())
+					info.ID, info.Remote.String(), info.Local.String())
 			}
-			interf.Lock.RUnlock()
 		}
 
 	case "down":
@@ -216,31 +464,8 @@ func (node *Node) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 			log.Println("usage: down [integer]")
 			goto done
 		}
-		if inum >= len(node.LocalInterfaces) {
-			log.Println("error: index exceeds number of interfaces")
-			goto done
-		}
-		// Delete from routing table
-		interf := node.LocalInterfaces[inum]
-		deletedEntries := make([]RIPEntry, 0)
-		node.rtMtx.Lock()
-		for route, entry := range node.RoutingTable {
-			if entry.Interface == interf {
-				entry.Cost = util.INFINITY
-				deletedEntries = append(deletedEntries, EntryToRIPEntry(&route, entry))
-				delete(node.RoutingTable, route)
-			}
-		}
-		node.rtMtx.Unlock()
-		// Set disabled.
-		interf.Lock.Lock()
-		interf.Enabled = false
-		interf.Lock.Unlock()
-		// Send triggered updates
-		if len(deletedEntries) > 0 {
-			node.rtMtx.RLock()
-			node.sendTriggeredUpdate(deletedEntries)
-			node.rtMtx.RUnlock()
+		if err := node.SetInterfaceUp(inum, false); err != nil {
+			log.Printf("error: %v\n", err)
 		}
 
 	case "up":
@@ -254,27 +479,31 @@ func (node *Node) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 			log.Println("usage: up [integer]")
 			goto done
 		}
-		if inum >= len(node.LocalInterfaces) {
-			log.Println("error: index exceeds number of interfaces")
+		if err := node.SetInterfaceUp(inum, true); err != nil {
+			log.Printf("error: %v\n", err)
+		}
+
+	case "policy":
+		// Show the policy, or apply a policy directive.
+		if len(tokens) == 1 {
+			node.printPolicy()
 			goto done
 		}
-		// Set enabled.
-		interf := node.LocalInterfaces[inum]
-		interf.Lock.Lock()
-		interf.Enabled = true
-		interf.Lock.Unlock()
-		// Re-add entry to the routing table
-		addedEntry := make([]RIPEntry, 1)
-		entry := &Entry{
-			Interface: interf,
-			Cost:      0,
-		}
-		route := NewRoute(util.IP2int(interf.Addr), util.IP2int(util.DEFAULT_MASK))
-		addedEntry[0] = EntryToRIPEntry(&route, entry)
-		node.setRoute(route, entry)
-		node.rtMtx.RLock()
-		node.sendTriggeredUpdate(addedEntry)
-		node.rtMtx.RUnlock()
+		if err := node.ApplyPolicy(tokens[1:]); err != nil {
+			log.Printf("policy error: %v\n", err)
+		}
+
+	case "rib":
+		// Print out every candidate route.
+		node.printRIB()
+
+	case "bfd":
+		// Print out the BFD sessions.
+		node.printBFD()
+
+	case "lsdb":
+		// Print out the link-state database.
+		node.printLSDB()
 
 	case "send":
 		// Send data using the specified protocol to the specified ip.
@@ -286,14 +515,8 @@ func (node *Node) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 		ip := tokens[1]
 		protocol, _ := strconv.Atoi(tokens[2])
 		payload := strings.Join(tokens[3:], " ")
-		// Create and send packet to right place in routing table; drop if none.
-		entry, found, _ := node.matchRoute(net.ParseIP(ip), 32)
-		if found {
-			interf := entry.Interface
-			destAddr := net.ParseIP(ip)
-			packet := NewIPPacket(uint8(protocol), []byte(payload), util.DEFAULT_TTL, interf.Addr, destAddr)
-			interf.Send(node.UDPConn, packet)
-		}
+		// Send packet to right place in routing table; drop if none.
+		node.SendData(net.ParseIP(ip), uint8(protocol), []byte(payload))
 
 	case "traceroute":
 		// Initiate a traceroute.
@@ -310,6 +533,11 @@ func (node *Node) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 		return true, true
 
 	default:
+		// Try commands registered by other packages.
+		if handler, exists := node.commands[tokens[0]]; exists {
+			handler(tokens)
+			goto done
+		}
 		// Print out the help message.
 		log.Println(util.TCP_HELP_MESSAGE)
 		readyChan <- true
@@ -320,18 +548,117 @@ done:
 	return true, false
 }
 
+// InterfaceInfo describes one of our interfaces.
+type InterfaceInfo struct {
+	ID     int
+	Local  net.IP
+	Remote net.IP
+	Up     bool
+}
+
+// Lists our interfaces.
+func (node *Node) Interfaces() []InterfaceInfo {
+	infos := make([]InterfaceInfo, 0, len(node.LocalInterfaces))
+	for i, interf := range node.LocalInterfaces {
+		interf.Lock.RLock()
+		infos = append(infos, InterfaceInfo{ID: i, Local: interf.Addr, Remote: interf.Remote, Up: interf.Enabled})
+		interf.Lock.RUnlock()
+	}
+	return infos
+}
+
+// Brings an interface up or down, updating the routing table and telling our neighbours.
+func (node *Node) SetInterfaceUp(inum int, up bool) error {
+	if inum < 0 || inum >= len(node.LocalInterfaces) {
+		return errors.New("index exceeds number of interfaces")
+	}
+	interf := node.LocalInterfaces[inum]
+	interf.Lock.Lock()
+	interf.Enabled = up
+	interf.Lock.Unlock()
+	if !up {
+		// Delete from routing table
+		node.flushInterface(inum, false, CAUSE_IF_DOWN)
+		return nil
+	}
+	// Re-add our address and static routes to the routing table
+	entry := &Entry{
+		Interface: interf,
+		Cost:      0,
+		Source:    SRC_CONNECTED,
+	}
+	route := NewRoute(util.IP2int(interf.Addr), util.IP2int(util.DEFAULT_MASK))
+	node.rtMtx.Lock()
+	addedEntry, _ := node.setCandidate(route, entry, CAUSE_IF_UP)
+	changed := append([]RIPEntry{addedEntry}, node.installStatics(inum)...)
+	node.rtMtx.Unlock()
+	if node.LinkState {
+		node.lsInterfaceChanged(inum)
+		return nil
+	}
+	node.rtMtx.RLock()
+	node.sendTriggeredUpdate(changed)
+	node.rtMtx.RUnlock()
+	return nil
+}
+
+// Sends a payload with the given protocol out of the interface that routes to dst.
+func (node *Node) SendData(dst net.IP, proto uint8, payload []byte) error {
+	entry, found, _ := node.matchRoute(dst, 32)
+	if !found {
+		return fmt.Errorf("no route to %v", dst)
+	}
+	interf := entry.Interface
+	packet := NewIPPacket(proto, payload, util.DEFAULT_TTL, interf.Addr, dst)
+	interf.Send(node.UDPConn, packet)
+	return nil
+}
+
+// Removes every route through the given interface, optionally keeping our own address on it, and
+// recomputes link-state routes or sends triggered updates.
+func (node *Node) flushInterface(inum int, keepLocal bool, cause string) {
+	interf := node.LocalInterfaces[inum]
+	deletedEntries := make([]RIPEntry, 0)
+	node.rtMtx.Lock()
+	for route, candidates := range node.rib {
+		for source, entry := range candidates {
+			if entry.Interface != interf || (keepLocal && source == SRC_CONNECTED) {
+				continue
+			}
+			if entry.Death != nil {
+				entry.Death.Stop()
+			}
+			if advert, changed := node.removeRoute(route, source, ROUTE_WITHDRAWN, cause); changed {
+				deletedEntries = append(deletedEntries, advert)
+			}
+		}
+	}
+	node.rtMtx.Unlock()
+	// Recompute link-state routes, or send triggered updates
+	if node.LinkState {
+		node.lsInterfaceChanged(inum)
+	} else if len(deletedEntries) > 0 {
+		node.rtMtx.RLock()
+		node.sendTriggeredUpdate(deletedEntries)
+		node.rtMtx.RUnlock()
+	}
+}
+
 // handleUDPListen listens for incoming packets.
 func (node *Node) handleUDPListen() {
 	for {
 		// Get a packet
 		buf := make([]byte, util.MAX_FRAME_SIZE)
-		n, sender, _ := node.UDPConn.ReadFromUDP(buf)
+		n, sender, err := node.UDPConn.ReadFromUDP(buf)
+		if err != nil && node.ctx.Err() != nil {
+			return
+		}
 		if n < util.MIN_PACKET_SIZE {
 			continue
 		}
 		packet := &IPPacket{}
 		packet.Deserialize(buf[:n])
-		util.Debug.Printf("receieved packet %v", packet)
+		ipLog.Debug("received packet", "src", packet.Header.Src, "dst", packet.Header.Dst, "proto", packet.Header.Proto, "ttl", packet.Header.Ttl, "len", len(packet.Data))
 		// Check what interface it came in on.
 		interfNum := 0
 		for i, interf := range node.LocalInterfaces {
@@ -345,18 +672,30 @@ func (node *Node) handleUDPListen() {
 		interf.Lock.Lock()
 		if !interf.Enabled {
 			interf.Lock.Unlock()
+			interf.stats.dropped.Inc()
 			continue
 		}
 		interf.Lock.Unlock()
 		// Check that packet is valid.
 		if !VerifyIPChecksum(packet) {
+			ipLog.Debug("dropping packet with bad checksum", "src", packet.Header.Src, "if", interfNum)
+			interf.stats.dropped.Inc()
 			continue
 		}
+		interf.stats.received(n)
 		// Check if the packet is for us.
 		matched := false
 		for _, inf := range node.LocalInterfaces {
 			if packet.Header.Dst.Equal(inf.Addr) {
-				node.Handlers[packet.Header.Proto](node, packet, interfNum)
+				sniffed := node.deliverRaw(packet, interfNum)
+				handler, exists := node.Handlers[packet.Header.Proto]
+				if !exists {
+					if !sniffed {
+						ipLog.Debug("dropping packet for unknown protocol", "proto", packet.Header.Proto, "src", packet.Header.Src)
+					}
+				} else if err := handler(node, packet, interfNum); err != nil {
+					ipLog.Debug("handler error", "proto", packet.Header.Proto, "src", packet.Header.Src, "err", err)
+				}
 				matched = true
 				break
 			}
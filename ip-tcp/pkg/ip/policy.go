@@ -0,0 +1,318 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// PrefixListEntry matches prefixes inside Addr/Mask whose length is between Ge and Le.
+type PrefixListEntry struct {
+	Permit bool
+	Addr   uint32
+	Mask   uint32
+	Ge     int
+	Le     int
+}
+
+// PrefixList is an ordered list of entries; the first match wins, and unmatched prefixes are denied.
+type PrefixList struct {
+	Name    string
+	Entries []PrefixListEntry
+}
+
+// Filter is the policy applied to routes passing through an interface in one direction.
+type Filter struct {
+	PrefixList string          // Prefix list that routes must be permitted by, if set.
+	DenyTags   map[uint32]bool // Tags of routes to reject (export only).
+	Offset     uint32          // Metric added to routes that pass.
+	SetTag     uint32          // Tag applied to routes that pass (import only).
+}
+
+// Checks if the prefix list permits the given route.
+func (pl *PrefixList) Permits(route Route) bool {
+	routeLen := util.MaskLen(util.Int2IP(route.Mask))
+	for _, entry := range pl.Entries {
+		entryLen := util.MaskLen(util.Int2IP(entry.Mask))
+		if routeLen < entryLen || route.Addr&entry.Mask != entry.Addr&entry.Mask {
+			continue
+		}
+		if routeLen < entry.Ge || routeLen > entry.Le {
+			continue
+		}
+		return entry.Permit
+	}
+	return false
+}
+
+// Parses a prefix of the form a.b.c.d/len.
+func ParsePrefix(s string) (Route, error) {
+	_, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return Route{}, err
+	}
+	addr := util.IP2int(ipNet.IP.To4())
+	mask := util.IP2int(net.IP(ipNet.Mask))
+	return NewRoute(addr&mask, mask), nil
+}
+
+// Checks if a token starts a policy directive.
+func IsPolicyDirective(token string) bool {
+	switch token {
+	case "prefix-list", "cost", "import", "export", "summary", "no", "route", "distance":
+		return true
+	}
+	return false
+}
+
+// ApplyPolicy applies a single policy directive. The same syntax is used in lnx files and at runtime:
+//
+//	prefix-list <name> permit|deny <prefix> [ge <len>] [le <len>]
+//	cost <if> <cost>
+//	import|export <if> prefix-list <name>|none
+//	import|export <if> offset <metric>
+//	import <if> tag <tag>
+//	export <if> deny-tag|permit-tag <tag>
+//	[no] summary <prefix> [on if <n>]
+//	[no] route <prefix> via <vip> [cost <n>]
+//	distance <source> <n>
+func (node *Node) ApplyPolicy(tokens []string) error {
+	if len(tokens) == 0 {
+		return errors.New("empty policy directive")
+	}
+	// Static routes and distances belong to the routing table, not the policy.
+	if tokens[0] == "route" || tokens[0] == "distance" || (tokens[0] == "no" && len(tokens) > 1 && tokens[1] == "route") {
+		return node.applyRIBDirective(tokens)
+	}
+	node.policyMtx.Lock()
+	defer node.policyMtx.Unlock()
+	switch tokens[0] {
+	case "prefix-list":
+		if len(tokens) < 4 || (tokens[2] != "permit" && tokens[2] != "deny") {
+			return errors.New("usage: prefix-list <name> permit|deny <prefix> [ge <len>] [le <len>]")
+		}
+		route, err := ParsePrefix(tokens[3])
+		if err != nil {
+			return err
+		}
+		entry := PrefixListEntry{
+			Permit: tokens[2] == "permit",
+			Addr:   route.Addr,
+			Mask:   route.Mask,
+			Ge:     util.MaskLen(util.Int2IP(route.Mask)),
+			Le:     util.MaskLen(util.Int2IP(route.Mask)),
+		}
+		for i := 4; i+1 < len(tokens); i += 2 {
+			n, err := strconv.Atoi(tokens[i+1])
+			if err != nil || n < 0 || n > 32 {
+				return fmt.Errorf("invalid prefix length %v", tokens[i+1])
+			}
+			switch tokens[i] {
+			case "ge":
+				entry.Ge = n
+				if entry.Le < n {
+					entry.Le = 32
+				}
+			case "le":
+				entry.Le = n
+			default:
+				return fmt.Errorf("unknown prefix-list option %v", tokens[i])
+			}
+		}
+		pl, exists := node.PrefixLists[tokens[1]]
+		if !exists {
+			pl = &PrefixList{Name: tokens[1]}
+			node.PrefixLists[tokens[1]] = pl
+		}
+		pl.Entries = append(pl.Entries, entry)
+
+	case "cost":
+		if len(tokens) != 3 {
+			return errors.New("usage: cost <if> <cost>")
+		}
+		interf, err := node.policyInterface(tokens[1])
+		if err != nil {
+			return err
+		}
+		cost, err := strconv.Atoi(tokens[2])
+		if err != nil || cost < 1 || uint32(cost) > util.INFINITY {
+			return fmt.Errorf("invalid cost %v", tokens[2])
+		}
+		interf.Cost = uint32(cost)
+
+	case "import", "export":
+		if len(tokens) != 4 {
+			return fmt.Errorf("usage: %v <if> <option> <value>", tokens[0])
+		}
+		interf, err := node.policyInterface(tokens[1])
+		if err != nil {
+			return err
+		}
+		filter := &interf.Import
+		if tokens[0] == "export" {
+			filter = &interf.Export
+		}
+		switch tokens[2] {
+		case "prefix-list":
+			if tokens[3] == "none" {
+				filter.PrefixList = ""
+			} else {
+				filter.PrefixList = tokens[3]
+			}
+		case "offset":
+			offset, err := strconv.Atoi(tokens[3])
+			if err != nil || offset < 0 || uint32(offset) > util.INFINITY {
+				return fmt.Errorf("invalid offset %v", tokens[3])
+			}
+			filter.Offset = uint32(offset)
+		case "tag", "deny-tag", "permit-tag":
+			tag, err := strconv.ParseUint(tokens[3], 10, 16)
+			if err != nil {
+				return fmt.Errorf("invalid tag %v", tokens[3])
+			}
+			if tokens[2] == "tag" && tokens[0] == "import" {
+				filter.SetTag = uint32(tag)
+			} else if tokens[2] == "deny-tag" && tokens[0] == "export" {
+				filter.DenyTags[uint32(tag)] = true
+			} else if tokens[2] == "permit-tag" && tokens[0] == "export" {
+				delete(filter.DenyTags, uint32(tag))
+			} else {
+				return fmt.Errorf("%v is not supported on %v", tokens[2], tokens[0])
+			}
+		default:
+			return fmt.Errorf("unknown %v option %v", tokens[0], tokens[2])
+		}
+
+	case "summary", "no":
+		return node.applySummary(tokens)
+
+	default:
+		return fmt.Errorf("unknown policy directive %v", tokens[0])
+	}
+	return nil
+}
+
+// Finds the interface named in a policy directive, by name or index. policyMtx held on entry.
+func (node *Node) policyInterface(token string) (*Interface, error) {
+	for _, interf := range node.LocalInterfaces {
+		if interf.Name == token {
+			return interf, nil
+		}
+	}
+	inum, err := strconv.Atoi(token)
+	if err != nil || inum < 0 || inum >= len(node.LocalInterfaces) {
+		return nil, fmt.Errorf("invalid interface %v", token)
+	}
+	return node.LocalInterfaces[inum], nil
+}
+
+// Checks a route against a filter. policyMtx held on entry.
+func (node *Node) filterPermits(filter *Filter, route Route, tag uint32) bool {
+	if filter.PrefixList != "" {
+		pl, exists := node.PrefixLists[filter.PrefixList]
+		if !exists || !pl.Permits(route) {
+			return false
+		}
+	}
+	return !filter.DenyTags[tag]
+}
+
+// Applies import policy to a received RIP entry, returning the cost and tag to install it with.
+// Routes that are filtered out come back with cost infinity.
+func (node *Node) importRIPEntry(linkID int, ripEntry RIPEntry) (cost uint32, tag uint32) {
+	node.policyMtx.RLock()
+	defer node.policyMtx.RUnlock()
+	interf := node.LocalInterfaces[linkID]
+	if !node.filterPermits(&interf.Import, RIPEntryToRoute(&ripEntry), 0) {
+		return util.INFINITY, 0
+	}
+	cost = ripEntry.Cost + interf.Cost + interf.Import.Offset
+	if cost > util.INFINITY {
+		cost = util.INFINITY
+	}
+	// Our own tag replaces the one the neighbour sent.
+	if interf.Import.SetTag != 0 {
+		return cost, interf.Import.SetTag
+	}
+	return cost, ripEntry.Tag
+}
+
+// Applies summaries, split horizon with poison reverse and export policy to entries being sent out
+// of an interface. rtMtx held on entry.
+func (node *Node) exportRIPEntries(linkID int, entries []RIPEntry) []RIPEntry {
+	node.policyMtx.RLock()
+	defer node.policyMtx.RUnlock()
+	interf := node.LocalInterfaces[linkID]
+	entries = node.summarizeRIPEntries(linkID, entries)
+	exported := make([]RIPEntry, 0, len(entries))
+	for _, entry := range entries {
+		route := RIPEntryToRoute(&entry)
+		tag := uint32(0)
+		// Summaries already carry their own cost, whatever we learned for the same prefix.
+		if rtEntry, exists := node.RoutingTable[route]; exists && !node.summarizes(linkID, route) {
+			tag = rtEntry.Tag
+			// Poison Reverse: make cost infinite when sending back
+			if rtEntry.Interface == interf && rtEntry.Source != SRC_CONNECTED {
+				entry.Cost = util.INFINITY
+			}
+		}
+		if !node.filterPermits(&interf.Export, route, tag) {
+			continue
+		}
+		entry.Cost += interf.Export.Offset
+		if entry.Cost > util.INFINITY {
+			entry.Cost = util.INFINITY
+		}
+		exported = append(exported, entry)
+	}
+	return exported
+}
+
+// Prints the current policy.
+func (node *Node) printPolicy() {
+	node.policyMtx.RLock()
+	defer node.policyMtx.RUnlock()
+	names := make([]string, 0, len(node.PrefixLists))
+	for name := range node.PrefixLists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, entry := range node.PrefixLists[name].Entries {
+			action := "deny"
+			if entry.Permit {
+				action = "permit"
+			}
+			log.Printf("prefix-list %v %v %v/%v ge %v le %v\n",
+				name, action, util.Int2IP(entry.Addr), util.MaskLen(util.Int2IP(entry.Mask)), entry.Ge, entry.Le)
+		}
+	}
+	node.printSummaries()
+	log.Printf("if\tcost\tdir\tprefix-list\toffset\ttags\n")
+	for i, interf := range node.LocalInterfaces {
+		for _, dir := range []string{"import", "export"} {
+			filter := &interf.Import
+			tags := fmt.Sprintf("set %v", filter.SetTag)
+			if dir == "export" {
+				filter = &interf.Export
+				denied := make([]string, 0)
+				for tag := range filter.DenyTags {
+					denied = append(denied, strconv.Itoa(int(tag)))
+				}
+				sort.Strings(denied)
+				tags = fmt.Sprintf("deny [%v]", strings.Join(denied, " "))
+			}
+			pl := filter.PrefixList
+			if pl == "" {
+				pl = "none"
+			}
+			log.Printf("%v\t%v\t%v\t%v\t\t%v\t%v\n", i, interf.Cost, dir, pl, filter.Offset, tags)
+		}
+	}
+}
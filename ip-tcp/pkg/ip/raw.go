@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+	atomic "go.uber.org/atomic"
+)
+
+var ErrRawClosed = errors.New("raw socket closed")
+
+// RawInfo describes a packet read from a raw socket.
+type RawInfo struct {
+	Header    IPHeader
+	Interface int // Index of the interface the packet arrived on.
+	Truncated bool
+}
+
+// A packet queued on a raw socket.
+type rawPacket struct {
+	header IPHeader
+	data   []byte
+	linkID int
+}
+
+// RawSocket sends and receives IP packets of one protocol directly. Every raw socket open on a
+// protocol gets its own copy of each packet addressed to us, alongside the registered handler.
+type RawSocket struct {
+	node      *Node
+	proto     uint8
+	hdrincl   atomic.Bool // Whether WriteTo takes a whole packet, header included.
+	queue     chan rawPacket
+	dropped   atomic.Uint64 // Packets dropped because the queue was full.
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Opens a raw socket for the given protocol.
+func (node *Node) OpenRaw(proto uint8) (*RawSocket, error) {
+	if node.ctx.Err() != nil {
+		return nil, ErrRawClosed
+	}
+	sock := &RawSocket{
+		node:  node,
+		proto: proto,
+		queue: make(chan rawPacket, util.RAW_QUEUE_SIZE),
+		done:  make(chan struct{}),
+	}
+	node.rawMtx.Lock()
+	defer node.rawMtx.Unlock()
+	node.raw[proto] = append(node.raw[proto], sock)
+	return sock, nil
+}
+
+// Hands a copy of a packet to every raw socket open on its protocol, without blocking. Returns
+// whether there were any.
+func (node *Node) deliverRaw(packet *IPPacket, interfNum int) bool {
+	node.rawMtx.RLock()
+	defer node.rawMtx.RUnlock()
+	socks := node.raw[packet.Header.Proto]
+	for _, sock := range socks {
+		data := make([]byte, len(packet.Data))
+		copy(data, packet.Data)
+		select {
+		case sock.queue <- rawPacket{header: packet.Header, data: data, linkID: interfNum}:
+		default:
+			sock.dropped.Inc()
+			ipLog.Debug("dropping packet for slow raw socket", "proto", sock.proto, "src", packet.Header.Src)
+		}
+	}
+	return len(socks) > 0
+}
+
+// Gets the protocol the socket was opened for.
+func (sock *RawSocket) Proto() uint8 {
+	return sock.proto
+}
+
+// Sets whether WriteTo takes a whole IP packet, like IP_HDRINCL, rather than just a payload.
+func (sock *RawSocket) SetHeaderIncluded(flag bool) {
+	sock.hdrincl.Store(flag)
+}
+
+// Gets the number of packets dropped because they weren't read quickly enough.
+func (sock *RawSocket) Dropped() uint64 {
+	return sock.dropped.Load()
+}
+
+// Reads the next packet into buf, blocking until one arrives. Returns the number of payload bytes
+// copied; if buf is too small the rest is discarded and the info is marked truncated.
+func (sock *RawSocket) ReadFrom(buf []byte) (int, RawInfo, error) {
+	select {
+	case packet := <-sock.queue:
+		n := copy(buf, packet.data)
+		return n, RawInfo{Header: packet.header, Interface: packet.linkID, Truncated: n < len(packet.data)}, nil
+	case <-sock.done:
+	case <-sock.node.ctx.Done():
+	}
+	return 0, RawInfo{}, ErrRawClosed
+}
+
+// Sends a packet to dst. The payload is wrapped in a header with our protocol and the address of
+// the outgoing interface, unless the header is included, in which case data is a whole IPv4 packet
+// without options, whose total length must match: its checksum is filled in if zero and dst is
+// ignored in favour of the header's.
+func (sock *RawSocket) WriteTo(data []byte, dst net.IP) (int, error) {
+	select {
+	case <-sock.done:
+		return 0, ErrRawClosed
+	case <-sock.node.ctx.Done():
+		return 0, ErrRawClosed
+	default:
+	}
+	if !sock.hdrincl.Load() {
+		if err := sock.node.SendData(dst, sock.proto, data); err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+	if len(data) < util.MIN_PACKET_SIZE {
+		return 0, fmt.Errorf("packet of %v bytes is shorter than a header", len(data))
+	}
+	packet := &IPPacket{}
+	packet.Deserialize(data)
+	if packet.Header.Version != 4 {
+		return 0, fmt.Errorf("ip version %v is not supported", packet.Header.Version)
+	}
+	if packet.Header.HeaderLength != 5 {
+		return 0, fmt.Errorf("header length of %v words is not supported, since options aren't", packet.Header.HeaderLength)
+	}
+	if int(packet.Header.TotalLength) != len(data) {
+		return 0, fmt.Errorf("total length %v doesn't match the %v bytes given", packet.Header.TotalLength, len(data))
+	}
+	if packet.Header.Checksum == 0 {
+		packet.Header.Checksum = IPChecksum(packet)
+	}
+	if _, found, _ := sock.node.matchRoute(packet.Header.Dst, 32); !found {
+		if _, ok := sock.node.NeighborInterface(packet.Header.Dst); !ok {
+			return 0, fmt.Errorf("no route to %v", packet.Header.Dst)
+		}
+	}
+	sock.node.SendPacket(packet)
+	return len(data), nil
+}
+
+// Closes the socket. Pending and later reads and writes fail with ErrRawClosed.
+func (sock *RawSocket) Close() error {
+	sock.closeOnce.Do(func() {
+		close(sock.done)
+		node := sock.node
+		node.rawMtx.Lock()
+		defer node.rawMtx.Unlock()
+		socks := node.raw[sock.proto]
+		for i, s := range socks {
+			if s == sock {
+				node.raw[sock.proto] = append(socks[:i:i], socks[i+1:]...)
+				break
+			}
+		}
+		if len(node.raw[sock.proto]) == 0 {
+			delete(node.raw, sock.proto)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,287 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+var ribLog = util.NewLogger("rib")
+
+// RouteSource is the protocol that put an entry in the routing information base.
+type RouteSource string
+
+const (
+	SRC_CONNECTED RouteSource = "connected"
+	SRC_STATIC    RouteSource = "static"
+	SRC_BGP       RouteSource = "bgp"
+	SRC_LS        RouteSource = "ls"
+	SRC_RIP       RouteSource = "rip"
+)
+
+// Default administrative distance of each source. When several sources have a route to the same
+// prefix, the one with the lowest distance is used for forwarding.
+var defaultDistances = map[RouteSource]uint8{
+	SRC_CONNECTED: 0,
+	SRC_STATIC:    1,
+	SRC_BGP:       20,
+	SRC_LS:        110,
+	SRC_RIP:       120,
+}
+
+// StaticRoute is a configured route through a neighbour.
+type StaticRoute struct {
+	Route   Route
+	NextHop net.IP
+	Cost    uint32
+}
+
+// Gets the administrative distance of a source. rtMtx held on entry.
+func (node *Node) distance(source RouteSource) uint8 {
+	if d, exists := node.Distances[source]; exists {
+		return d
+	}
+	return 255
+}
+
+// Picks the best candidate for a prefix and installs it in the forwarding table, publishing an
+// event of type evType if the prefix is left without a route. Returns what RIP should now advertise
+// for the prefix and whether the forwarding table changed. rtMtx held on entry.
+func (node *Node) selectRoute(route Route, evType RouteEventType, cause string) (RIPEntry, bool) {
+	var best *Entry
+	for source, entry := range node.rib[route] {
+		if best == nil {
+			best = entry
+			continue
+		}
+		d, bestD := node.distance(source), node.distance(best.Source)
+		if d < bestD || (d == bestD && (entry.Cost < best.Cost || (entry.Cost == best.Cost && source < best.Source))) {
+			best = entry
+		}
+	}
+	advert := RIPEntry{Cost: util.INFINITY, Addr: util.Int2IP(route.Addr), Mask: util.Int2IP(route.Mask)}
+	old, exists := node.RoutingTable[route]
+	switch {
+	case best == nil && !exists:
+		return advert, false
+	case best == nil:
+		delete(node.RoutingTable, route)
+		node.publishRoute(evType, route, old.Interface, old.Cost, util.INFINITY, cause)
+		ribLog.Debug("removed route", "route", route, "cause", cause)
+		return advert, true
+	case !exists:
+		node.publishRoute(ROUTE_ADDED, route, best.Interface, util.INFINITY, best.Cost, cause)
+	case old == best:
+		advert.Cost = best.Cost
+		return advert, false
+	case old.Cost != best.Cost || old.Interface != best.Interface:
+		node.publishRoute(ROUTE_CHANGED, route, best.Interface, old.Cost, best.Cost, cause)
+	}
+	node.RoutingTable[route] = best
+	ribLog.Debug("installed route", "route", route, "source", best.Source, "cost", best.Cost, "cause", cause)
+	advert.Cost = best.Cost
+	return advert, true
+}
+
+// Adds or replaces the candidate from entry.Source for a prefix and reselects. rtMtx held on entry.
+func (node *Node) setCandidate(route Route, entry *Entry, cause string) (RIPEntry, bool) {
+	candidates, exists := node.rib[route]
+	if !exists {
+		candidates = make(map[RouteSource]*Entry)
+		node.rib[route] = candidates
+	}
+	candidates[entry.Source] = entry
+	return node.selectRoute(route, ROUTE_WITHDRAWN, cause)
+}
+
+// Gets the candidate from a source for a prefix. rtMtx held on entry.
+func (node *Node) candidate(route Route, source RouteSource) (*Entry, bool) {
+	entry, exists := node.rib[route][source]
+	return entry, exists
+}
+
+// Finds the longest-prefix candidate from a source, like matchRoute does for the forwarding table.
+func (node *Node) matchCandidate(addr net.IP, maxLen int, source RouteSource) (match *Entry, matched bool, len int) {
+	node.rtMtx.RLock()
+	defer node.rtMtx.RUnlock()
+	match, longestMask := nil, -1
+	for route, candidates := range node.rib {
+		entry, exists := candidates[source]
+		if !exists {
+			continue
+		}
+		len := util.MaskLen(util.Int2IP(route.Mask))
+		if util.IP2int(addr)&route.Mask == route.Addr&route.Mask && len > longestMask && len <= maxLen {
+			match = entry
+			longestMask = len
+		}
+	}
+	return match, match != nil, longestMask
+}
+
+// Recomputes the forwarding table after the distances change, returning what changed. rtMtx held
+// on entry.
+func (node *Node) reselectAll(cause string) []RIPEntry {
+	changed := make([]RIPEntry, 0)
+	for route := range node.rib {
+		if advert, ok := node.selectRoute(route, ROUTE_WITHDRAWN, cause); ok {
+			changed = append(changed, advert)
+		}
+	}
+	return changed
+}
+
+// Installs the configured static routes through an interface, if it is up. rtMtx held on entry.
+func (node *Node) installStatics(linkID int) []RIPEntry {
+	changed := make([]RIPEntry, 0)
+	interf := node.LocalInterfaces[linkID]
+	interf.Lock.RLock()
+	enabled := interf.Enabled
+	interf.Lock.RUnlock()
+	if !enabled {
+		return changed
+	}
+	for _, static := range node.StaticRoutes {
+		if !static.NextHop.Equal(interf.Remote) {
+			continue
+		}
+		entry := &Entry{Interface: interf, Cost: static.Cost, Source: SRC_STATIC}
+		if advert, ok := node.setCandidate(static.Route, entry, CAUSE_STATIC); ok {
+			changed = append(changed, advert)
+		}
+	}
+	return changed
+}
+
+// Applies a static route or distance directive:
+//
+//	[no] route <prefix> via <vip> [cost <n>]
+//	distance <source> <n>
+func (node *Node) applyRIBDirective(tokens []string) error {
+	if tokens[0] == "distance" {
+		if len(tokens) != 3 {
+			return errors.New("usage: distance <source> <n>")
+		}
+		source := RouteSource(tokens[1])
+		if _, exists := defaultDistances[source]; !exists {
+			return fmt.Errorf("unknown route source %v", tokens[1])
+		}
+		d, err := strconv.ParseUint(tokens[2], 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid distance %v", tokens[2])
+		}
+		node.rtMtx.Lock()
+		defer node.rtMtx.Unlock()
+		node.Distances[source] = uint8(d)
+		node.triggerRIB(node.reselectAll(CAUSE_DISTANCE))
+		return nil
+	}
+	remove := tokens[0] == "no"
+	if remove {
+		tokens = tokens[1:]
+	}
+	if (len(tokens) != 4 && len(tokens) != 6) || tokens[2] != "via" {
+		return errors.New("usage: [no] route <prefix> via <vip> [cost <n>]")
+	}
+	route, err := ParsePrefix(tokens[1])
+	if err != nil {
+		return err
+	}
+	static := StaticRoute{Route: route, NextHop: net.ParseIP(tokens[3]), Cost: 1}
+	if len(tokens) == 6 {
+		cost, err := strconv.Atoi(tokens[5])
+		if tokens[4] != "cost" || err != nil || cost < 1 || uint32(cost) > util.INFINITY {
+			return fmt.Errorf("invalid cost %v", tokens[5])
+		}
+		static.Cost = uint32(cost)
+	}
+	if remove {
+		node.RemoveStaticRoute(route)
+		return nil
+	}
+	return node.SetStaticRoute(static)
+}
+
+// Adds a static route, replacing any other static route to the same prefix. The next hop must be a
+// neighbour.
+func (node *Node) SetStaticRoute(static StaticRoute) error {
+	linkID, ok := node.NeighborInterface(static.NextHop)
+	if !ok {
+		return fmt.Errorf("%v is not on the other end of any interface", static.NextHop)
+	}
+	node.rtMtx.Lock()
+	defer node.rtMtx.Unlock()
+	changed := node.removeStaticRoute(static.Route)
+	node.StaticRoutes = append(node.StaticRoutes, static)
+	node.triggerRIB(append(changed, node.installStatics(linkID)...))
+	return nil
+}
+
+// Removes the static route to a prefix, if there is one.
+func (node *Node) RemoveStaticRoute(route Route) {
+	node.rtMtx.Lock()
+	defer node.rtMtx.Unlock()
+	node.triggerRIB(node.removeStaticRoute(route))
+}
+
+// Removes the static route to a prefix from the configuration and the RIB. rtMtx held on entry.
+func (node *Node) removeStaticRoute(route Route) []RIPEntry {
+	changed := make([]RIPEntry, 0)
+	for i, s := range node.StaticRoutes {
+		if s.Route == route {
+			node.StaticRoutes = append(node.StaticRoutes[:i], node.StaticRoutes[i+1:]...)
+			if advert, ok := node.removeRoute(route, SRC_STATIC, ROUTE_WITHDRAWN, CAUSE_STATIC); ok {
+				changed = append(changed, advert)
+			}
+			break
+		}
+	}
+	return changed
+}
+
+// Sends a triggered update for forwarding table changes, unless we run link-state. rtMtx held on
+// entry.
+func (node *Node) triggerRIB(changed []RIPEntry) {
+	if node.LinkState || node.UDPConn == nil || len(changed) == 0 {
+		return
+	}
+	node.sendTriggeredUpdate(changed)
+}
+
+// Prints every candidate route, marking the ones in the forwarding table.
+func (node *Node) printRIB() {
+	node.rtMtx.RLock()
+	defer node.rtMtx.RUnlock()
+	routes := make([]Route, 0, len(node.rib))
+	for route := range node.rib {
+		routes = append(routes, route)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Addr != routes[j].Addr {
+			return routes[i].Addr < routes[j].Addr
+		}
+		return routes[i].Mask < routes[j].Mask
+	})
+	log.Printf("  dst\t\t\tsource\t\tdist\tcost\tloc\n")
+	for _, route := range routes {
+		sources := make([]string, 0)
+		for source := range node.rib[route] {
+			sources = append(sources, string(source))
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			entry := node.rib[route][RouteSource(source)]
+			marker := " "
+			if node.RoutingTable[route] == entry {
+				marker = ">"
+			}
+			log.Printf("%v %v/%v\t\t%-9v\t%v\t%v\t%v\n", marker, util.Int2IP(route.Addr), util.MaskLen(util.Int2IP(route.Mask)),
+				source, node.distance(entry.Source), entry.Cost, entry.Interface.Addr)
+		}
+	}
+}
@@ -8,6 +8,8 @@ import (
 	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
 )
 
+var ripLog = util.NewLogger("rip")
+
 // RIPData is all of the ripdata.
 type RIPData struct {
 	Command uint16
@@ -49,9 +51,11 @@ func DeserializeRIPData(data []byte) (ripData RIPData, err error) {
 	return ripData, nil
 }
 
-// RIPEntry is an entry i a RIP Packet.
+// RIPEntry is an entry i a RIP Packet. The tag rides in the upper half of the cost field, which
+// costs never reach, so untagged entries look the same as always.
 type RIPEntry struct {
 	Cost uint32
+	Tag  uint32
 	Addr net.IP
 	Mask net.IP
 }
@@ -59,7 +63,7 @@ type RIPEntry struct {
 // Serialize RIPEntry
 func SerializeRIPEntry(ripEntry RIPEntry) (data []byte) {
 	data = make([]byte, 0)
-	data = append(data, util.Htonl(ripEntry.Cost)...)
+	data = append(data, util.Htonl(ripEntry.Tag<<16|ripEntry.Cost&0xffff)...)
 	data = append(data, util.Htonl(util.IP2int(ripEntry.Addr))...)
 	data = append(data, util.Htonl(util.IP2int(ripEntry.Mask))...)
 	return data
@@ -70,8 +74,10 @@ func DeserializeRIPEntry(data []byte) (ripEntry RIPEntry, err error) {
 	if len(data) < 12 {
 		return RIPEntry{}, errors.New("not enough data")
 	}
+	costField := util.Ntohl(data[0:4])
 	return RIPEntry{
-		Cost: util.Ntohl(data[0:4]),
+		Cost: costField & 0xffff,
+		Tag:  costField >> 16,
 		Addr: util.Int2IP(util.Ntohl(data[4:8])),
 		Mask: util.Int2IP(util.Ntohl(data[8:12])),
 	}, nil
@@ -81,6 +87,7 @@ func DeserializeRIPEntry(data []byte) (ripEntry RIPEntry, err error) {
 func EntryToRIPEntry(route *Route, entry *Entry) RIPEntry {
 	return RIPEntry{
 		Cost: entry.Cost,
+		Tag:  entry.Tag,
 		Addr: util.Int2IP(route.Addr),
 		Mask: util.Int2IP(route.Mask),
 	}
@@ -96,16 +103,21 @@ func RIPEntryToRoute(ripEntry *RIPEntry) Route {
 
 // Handles rip data.
 func RIPHandler(node *Node, packet *IPPacket, linkID int) error {
+	// Routes come from the link-state protocol instead, or not at all.
+	if node.LinkState || !node.RIPEnabled {
+		return nil
+	}
 	// Parse RIPData.
 	ripData, err := DeserializeRIPData(packet.Data)
 	if err != nil {
 		return err
 	}
+	node.ripReceived.count(ripData.Command)
 	// Print packet data for debugging
-	util.Debug.Printf("Received RIP entries:\n")
-	for _, entry := range ripData.Entries {
-		route := RIPEntryToRoute(&entry)
-		util.Debug.Printf("Cost %v to %v/%v\n", entry.Cost, util.Int2IP(route.Addr), util.MaskLen(util.Int2IP(route.Mask)))
+	if ripLog.Enabled(util.LEVEL_DEBUG) {
+		for _, entry := range ripData.Entries {
+			ripLog.Debug("received entry", "if", linkID, "route", RIPEntryToRoute(&entry), "cost", entry.Cost)
+		}
 	}
 	// Switch on command.
 	if ripData.Command == 1 {
@@ -114,60 +126,68 @@ func RIPHandler(node *Node, packet *IPPacket, linkID int) error {
 		if err != nil {
 			return err
 		}
-		interf := node.LocalInterfaces[linkID]
-		outgoingPacket := NewIPPacket(200, SerializeRIPData(outgoingRipData), util.DEFAULT_TTL, interf.Addr, interf.Remote)
-		interf.Send(node.UDPConn, outgoingPacket)
+		node.rtMtx.RLock()
+		outgoingRipData.Entries = node.exportRIPEntries(linkID, outgoingRipData.Entries)
+		node.rtMtx.RUnlock()
+		node.sendRIP(node.LocalInterfaces[linkID], outgoingRipData)
 		return nil
 	} else if ripData.Command == 2 {
+		// Ignore neighbours that BFD considers down.
+		if !node.bfdAllows(linkID) {
+			return nil
+		}
 		// RIP Response - handle each case differently.
 		entriesDiff := make([]RIPEntry, 0)
 		for _, ripEntry := range ripData.Entries {
 			route := RIPEntryToRoute(&ripEntry)
 			routeMaskLen := util.MaskLen(ripEntry.Mask)
-			if entry, found, matchLen := node.matchRoute(ripEntry.Addr, routeMaskLen); !found {
+			cost, tag := node.importRIPEntry(linkID, ripEntry)
+			// Never learn our own addresses.
+			node.rtMtx.RLock()
+			_, local := node.candidate(route, SRC_CONNECTED)
+			node.rtMtx.RUnlock()
+			if local {
+				continue
+			}
+			entry, found, matchLen := node.matchCandidate(ripEntry.Addr, routeMaskLen, SRC_RIP)
+			if !found {
 				// If we didn't know about this route...
 				// Ignore if it's cost infinity.
-				if ripEntry.Cost+1 >= util.INFINITY {
+				if cost >= util.INFINITY {
 					continue
 				}
 				// Add the entry into the routing table.
-				entry := &Entry{
-					Interface: node.LocalInterfaces[linkID],
-					Cost:      ripEntry.Cost + 1,
-					Death:     time.AfterFunc(util.RIP_ENTRY_TIMEOUT, node.newTimer(route)),
+				entry := node.newRIPEntry(route, node.LocalInterfaces[linkID], cost, tag, node.RIPTimeout)
+				if advert, changed := node.setRoute(route, entry, CAUSE_RIP); changed {
+					entriesDiff = append(entriesDiff, advert)
 				}
-				node.setRoute(route, entry)
-				entriesDiff = append(entriesDiff, EntryToRIPEntry(&route, entry))
-			} else if entry.Cost == 0 {
-				// In this case, this is a local interface entry.
-				continue
-			} else if (ripEntry.Cost+1 < entry.Cost) || (ripEntry.Cost+1 > entry.Cost && node.LocalInterfaces[linkID] == entry.Interface) {
+			} else if (cost < entry.Cost) || (cost > entry.Cost && node.LocalInterfaces[linkID] == entry.Interface) {
 				// If we did know about this route but want to replace it...
-				// Stop the old timer if:
-				//   1. This is not a local interface
-				//   2. The two entries match the same node
-				if entry.Cost != 0 && matchLen == routeMaskLen {
+				// Stop the old timer if the two entries match the same prefix.
+				if matchLen == routeMaskLen {
 					entry.Death.Stop()
 				}
 				// Ignore if it's cost infinity
-				if ripEntry.Cost+1 >= util.INFINITY {
+				if cost >= util.INFINITY {
 					node.rtMtx.Lock()
-					delete(node.RoutingTable, route)
+					advert, changed := node.removeRoute(route, SRC_RIP, ROUTE_WITHDRAWN, CAUSE_RIP)
 					node.rtMtx.Unlock()
+					if changed {
+						entriesDiff = append(entriesDiff, advert)
+					}
 					continue
 				}
 				// Add the entry into the routing table.
-				entry := &Entry{
-					Interface: node.LocalInterfaces[linkID],
-					Cost:      ripEntry.Cost + 1,
-					Death:     time.AfterFunc(util.RIP_ENTRY_TIMEOUT, node.newTimer(route)),
+				entry := node.newRIPEntry(route, node.LocalInterfaces[linkID], cost, tag, node.RIPTimeout)
+				if advert, changed := node.setRoute(route, entry, CAUSE_RIP); changed {
+					entriesDiff = append(entriesDiff, advert)
 				}
-				node.setRoute(route, entry)
-				entriesDiff = append(entriesDiff, EntryToRIPEntry(&route, entry))
 			} else if node.LocalInterfaces[linkID] == entry.Interface {
 				// If we did know about this route but don't want to replace it...
-				entry.Death.Reset(util.RIP_ENTRY_TIMEOUT)
-				util.Debug.Printf("resetting timer for entry %v\n", entry)
+				node.rtMtx.Lock()
+				entry.refresh(node.RIPTimeout)
+				node.rtMtx.Unlock()
+				ripLog.Debug("refreshed route", "route", route, "cost", entry.Cost)
 			} else {
 				// Do nothing if we receive a route we already know about from a new source at a higher cost.
 				continue
@@ -209,12 +229,14 @@ func (node *Node) sendRIPUpdates() {
 	// Send first update on startup.
 	node.sendRIPUpdate()
 	// Every tick, send updates.
-	timer := time.NewTicker(util.RIP_UPDATE_COOLDOWN)
+	timer := time.NewTicker(node.RIPUpdateInterval)
 	defer timer.Stop()
 	for {
 		select {
 		case <-timer.C:
 			node.sendRIPUpdate()
+		case <-node.ctx.Done():
+			return
 		}
 	}
 }
@@ -223,69 +245,74 @@ func (node *Node) sendRIPUpdates() {
 func (node *Node) sendRIPRequest() {
 	for _, interf := range node.LocalInterfaces {
 		// Split Horizon: filter relevant entries to forward
-		data := SerializeRIPData(RIPData{Command: 1})
-		packet := NewIPPacket(200, data, util.DEFAULT_TTL, interf.Addr, interf.Remote)
-		interf.Send(node.UDPConn, packet)
+		node.sendRIP(interf, RIPData{Command: 1})
 	}
 }
 
 // Sends a single RIP update to neighbours
 func (node *Node) sendRIPUpdate() {
-	for _, interf := range node.LocalInterfaces {
+	for linkID, interf := range node.LocalInterfaces {
 		// Split Horizon: filter relevant entries to forward
 		ripData, _ := node.generateRIPData()
 		node.rtMtx.RLock()
-		for i, entry := range ripData.Entries {
-			// Poison Reverse: make cost infinite when sending back
-			route := RIPEntryToRoute(&entry)
-			if rtEntry, exists := node.RoutingTable[route]; exists {
-				if rtEntry.Interface == interf && entry.Cost != 0 {
-					ripData.Entries[i].Cost = util.INFINITY
-				}
-			}
-		}
+		ripData.Entries = node.exportRIPEntries(linkID, ripData.Entries)
 		node.rtMtx.RUnlock()
-		data := SerializeRIPData(ripData)
-		packet := NewIPPacket(200, data, util.DEFAULT_TTL, interf.Addr, interf.Remote)
-		interf.Send(node.UDPConn, packet)
+		node.sendRIP(interf, ripData)
 	}
 }
 
+// Sends RIP data to the neighbour on an interface.
+func (node *Node) sendRIP(interf *Interface, ripData RIPData) {
+	node.ripSent.count(ripData.Command)
+	packet := NewIPPacket(200, SerializeRIPData(ripData), util.DEFAULT_TTL, interf.Addr, interf.Remote)
+	interf.Send(node.UDPConn, packet)
+}
+
 // Sends a triggered update. rtMtx held on entry
 func (node *Node) sendTriggeredUpdate(newEntries []RIPEntry) {
-	for _, interf := range node.LocalInterfaces {
+	for linkID, interf := range node.LocalInterfaces {
 		// Split Horizon: filter relevant entries to forward
 		ripData := RIPData{
 			Command: 2,
-			Entries: append(make([]RIPEntry, 0), newEntries...),
+			Entries: node.exportRIPEntries(linkID, newEntries),
 		}
-		for i, entry := range ripData.Entries {
-			// Poison Reverse: make cost infinite when sending back
-			route := RIPEntryToRoute(&entry)
-			if rtEntry, exists := node.RoutingTable[route]; exists {
-				if rtEntry.Interface == interf && entry.Cost != 0 {
-					ripData.Entries[i].Cost = util.INFINITY
-				}
-			}
+		if len(ripData.Entries) == 0 {
+			continue
 		}
-		data := SerializeRIPData(ripData)
-		packet := NewIPPacket(200, data, util.DEFAULT_TTL, interf.Addr, interf.Remote)
-		interf.Send(node.UDPConn, packet)
+		node.sendRIP(interf, ripData)
 	}
 }
 
+// Creates a RIP entry learned on an interface, which expires after lifetime unless refreshed.
+func (node *Node) newRIPEntry(route Route, interf *Interface, cost uint32, tag uint32, lifetime time.Duration) *Entry {
+	return &Entry{
+		Interface: interf,
+		Cost:      cost,
+		Tag:       tag,
+		Source:    SRC_RIP,
+		Death:     time.AfterFunc(lifetime, node.newTimer(route)),
+		Expires:   time.Now().Add(lifetime),
+	}
+}
+
+// Restarts the timer on a RIP entry after a neighbour advertises it again. rtMtx held on entry.
+func (entry *Entry) refresh(timeout time.Duration) {
+	entry.Death.Reset(timeout)
+	entry.Expires = time.Now().Add(timeout)
+	entry.Stale = false
+}
+
 // Create a new timer to expire the addr entry.
 func (node *Node) newTimer(route Route) func() {
 	expire := func() {
 		node.rtMtx.Lock()
-		entry, exists := node.RoutingTable[route]
+		entry, exists := node.candidate(route, SRC_RIP)
 		if exists {
 			entry.Death.Stop()
-			delete(node.RoutingTable, route)
-			newEntry := EntryToRIPEntry(&route, entry)
-			newEntry.Cost = 16
-			node.sendTriggeredUpdate([]RIPEntry{newEntry})
-			util.Debug.Printf("expiring entry %v\n", entry)
+			if advert, changed := node.removeRoute(route, SRC_RIP, ROUTE_EXPIRED, CAUSE_TIMEOUT); changed {
+				node.sendTriggeredUpdate([]RIPEntry{advert})
+			}
+			ripLog.Debug("expired route", "route", route, "cost", entry.Cost)
 		}
 		node.rtMtx.Unlock()
 	}
@@ -1,8 +1,8 @@
 package pkg
 
 import (
+	"fmt"
 	"net"
-	"time"
 
 	"github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
 )
@@ -21,12 +21,17 @@ func NewRoute(addr uint32, mask uint32) Route {
 	}
 }
 
+// Formats a route as a prefix, like 10.0.0.0/24.
+func (route Route) String() string {
+	return fmt.Sprintf("%v/%v", util.Int2IP(route.Addr), util.MaskLen(util.Int2IP(route.Mask)))
+}
+
 // Sets the Route Aggregation flag for the node
 func (node *Node) SetAggregate(flag bool) {
 	node.Aggregate = flag
 }
 
-// Aggregates new routes in the routing table
+// Aggregates new RIP routes in the routing information base
 func (node *Node) AggregateRoutes(newEntries []RIPEntry) []RIPEntry {
 	node.rtMtx.Lock()
 	entryChan := make(chan RIPEntry, 1024)
@@ -39,8 +44,8 @@ func (node *Node) AggregateRoutes(newEntries []RIPEntry) []RIPEntry {
 			// Check if sibling nodes exist
 			ourRoute := RIPEntryToRoute(&entry)
 			siblingRoute := getSibling(ourRoute)
-			if sibling, exists := node.RoutingTable[siblingRoute]; exists {
-				if current, exists := node.RoutingTable[ourRoute]; exists {
+			if sibling, exists := node.candidate(siblingRoute, SRC_RIP); exists {
+				if current, exists := node.candidate(ourRoute, SRC_RIP); exists {
 					// Check if we should merge
 					if sibling.Cost == current.Cost && sibling.Interface == current.Interface {
 						parentMask := util.IP2int(entry.Mask) << 1
@@ -49,7 +54,7 @@ func (node *Node) AggregateRoutes(newEntries []RIPEntry) []RIPEntry {
 							Addr: parentAddr,
 							Mask: parentMask,
 						}
-						if parent, exists := node.RoutingTable[parentRoute]; exists {
+						if parent, exists := node.candidate(parentRoute, SRC_RIP); exists {
 							if entry.Cost < parent.Cost || (entry.Cost > parent.Cost && current.Interface == parent.Interface) {
 								// Replace the current parent.
 								parent.Death.Stop()
@@ -58,15 +63,13 @@ func (node *Node) AggregateRoutes(newEntries []RIPEntry) []RIPEntry {
 								break
 							}
 						}
-						newEntry := &Entry{
-							Interface: current.Interface,
-							Cost:      current.Cost,
-							Death:     time.AfterFunc(util.RIP_ENTRY_TIMEOUT, node.newTimer(parentRoute)),
-						}
-						node.RoutingTable[parentRoute] = newEntry
+						newEntry := node.newRIPEntry(parentRoute, current.Interface, current.Cost, 0, node.RIPTimeout)
+						node.setCandidate(parentRoute, newEntry, CAUSE_AGGREGATE)
 						// Delete old entries
-						delete(node.RoutingTable, siblingRoute)
-						delete(node.RoutingTable, ourRoute)
+						sibling.Death.Stop()
+						current.Death.Stop()
+						node.removeRoute(siblingRoute, SRC_RIP, ROUTE_WITHDRAWN, CAUSE_AGGREGATE)
+						node.removeRoute(ourRoute, SRC_RIP, ROUTE_WITHDRAWN, CAUSE_AGGREGATE)
 						// Push to queue
 						ripEntry := EntryToRIPEntry(&parentRoute, newEntry)
 						entryChan <- ripEntry
@@ -84,7 +87,7 @@ done:
 	entriesDiff := make([]RIPEntry, 0)
 	for _, entry := range newEntries {
 		route := RIPEntryToRoute(&entry)
-		if _, exists := node.RoutingTable[route]; exists {
+		if _, exists := node.candidate(route, SRC_RIP); exists {
 			entriesDiff = append(entriesDiff, entry)
 		}
 	}
@@ -105,7 +108,7 @@ func (node *Node) matchRoute(addr net.IP, maxLen int) (match *Entry, matched boo
 			longestMask = len
 		}
 	}
-	util.Debug.Printf("matched route %v with mask len %v\n", addr, longestMask)
+	ipLog.Debug("matched route", "dst", addr, "mask_len", longestMask)
 	return match, *match != Entry{}, longestMask
 }
 
@@ -120,10 +123,81 @@ func getSibling(route Route) Route {
 	}
 }
 
-// Sets the given route
-func (node *Node) setRoute(route Route, entry *Entry) {
+// Sets the candidate route from entry.Source, returning what RIP should now advertise for the
+// prefix and whether the forwarding table changed.
+func (node *Node) setRoute(route Route, entry *Entry, cause string) (RIPEntry, bool) {
+	node.rtMtx.Lock()
+	defer node.rtMtx.Unlock()
+	return node.setCandidate(route, entry, cause)
+}
+
+// Removes the candidate route from a source, falling back to the next best candidate. An event of
+// type evType is published if no candidates are left. rtMtx held on entry.
+func (node *Node) removeRoute(route Route, source RouteSource, evType RouteEventType, cause string) (RIPEntry, bool) {
+	candidates, exists := node.rib[route]
+	if !exists {
+		return RIPEntry{}, false
+	}
+	if _, exists := candidates[source]; !exists {
+		return RIPEntry{}, false
+	}
+	delete(candidates, source)
+	if len(candidates) == 0 {
+		delete(node.rib, route)
+	}
+	return node.selectRoute(route, evType, cause)
+}
+
+// InstallRoute adds a candidate route learned by a protocol outside this package through the
+// given interface. If it becomes the best route to the prefix, it is advertised to RIP neighbours
+// right away, with its tag.
+func (node *Node) InstallRoute(route Route, linkID int, cost uint32, tag uint32, source RouteSource, detail string) {
+	interf := node.LocalInterfaces[linkID]
+	node.rtMtx.Lock()
+	defer node.rtMtx.Unlock()
+	current, exists := node.candidate(route, source)
+	if exists && current.Interface == interf && current.Cost == cost && current.Tag == tag && current.Detail == detail {
+		return
+	}
+	entry := &Entry{
+		Interface: interf,
+		Cost:      cost,
+		Tag:       tag,
+		Source:    source,
+		Detail:    detail,
+	}
+	if advert, ok := node.setCandidate(route, entry, string(source)+" update"); ok {
+		node.triggerRIB([]RIPEntry{advert})
+	}
+}
+
+// WithdrawRoute removes a candidate route installed by InstallRoute. If it was the best route to
+// the prefix, RIP neighbours hear about the next best route, or a poisoned one, right away.
+func (node *Node) WithdrawRoute(route Route, source RouteSource) {
 	node.rtMtx.Lock()
 	defer node.rtMtx.Unlock()
-	node.RoutingTable[route] = entry
-	util.Debug.Printf("setting route %v/%v with cost %v\n", util.Int2IP(route.Addr), util.MaskLen(util.Int2IP(route.Mask)), entry.Cost)
+	if advert, ok := node.removeRoute(route, source, ROUTE_WITHDRAWN, string(source)+" withdraw"); ok {
+		node.triggerRIB([]RIPEntry{advert})
+	}
+}
+
+// Routes returns a copy of the forwarding table.
+func (node *Node) Routes() map[Route]Entry {
+	node.rtMtx.RLock()
+	defer node.rtMtx.RUnlock()
+	routes := make(map[Route]Entry, len(node.RoutingTable))
+	for route, entry := range node.RoutingTable {
+		routes[route] = *entry
+	}
+	return routes
+}
+
+// NeighborInterface finds the interface whose remote end has the given address.
+func (node *Node) NeighborInterface(addr net.IP) (int, bool) {
+	for i, interf := range node.LocalInterfaces {
+		if interf.Remote.Equal(addr) {
+			return i, true
+		}
+	}
+	return -1, false
 }
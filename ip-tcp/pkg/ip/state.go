@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Saves learned routes to path every interval, and restores any routes already saved there as
+// stale entries, so that a restarted node keeps forwarding while its neighbours catch up.
+func (node *Node) SetStateFile(path string, interval time.Duration) error {
+	node.statePath = path
+	node.stateInterval = interval
+	return node.loadState()
+}
+
+// Writes every RIP route and its remaining lifetime to the state file. Link-state and BGP routes
+// are not saved; they are rebuilt by their own protocols.
+func (node *Node) SaveState() error {
+	if node.statePath == "" {
+		return nil
+	}
+	now := time.Now()
+	lines := []string{fmt.Sprintf("saved %v", now.Format(time.RFC3339Nano))}
+	node.rtMtx.RLock()
+	for route, candidates := range node.rib {
+		entry, exists := candidates[SRC_RIP]
+		if !exists || !entry.Expires.After(now) {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("route %v/%v via %v cost %v tag %v ttl %v",
+			util.Int2IP(route.Addr), util.MaskLen(util.Int2IP(route.Mask)), entry.Interface.Remote, entry.Cost, entry.Tag, entry.Expires.Sub(now)))
+	}
+	node.rtMtx.RUnlock()
+	// Write to a temporary file first so a crash never leaves half a snapshot behind.
+	tmp := node.statePath + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, node.statePath)
+}
+
+// Saves the state file every stateInterval.
+func (node *Node) saveStatePeriodically() {
+	timer := time.NewTicker(node.stateInterval)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+			if err := node.SaveState(); err != nil {
+				ribLog.Warn("error saving state", "file", node.statePath, "err", err)
+			}
+		case <-node.ctx.Done():
+			return
+		}
+	}
+}
+
+// Installs the routes in the state file as stale RIP routes with whatever lifetime they had left.
+// Neighbours' updates refresh or replace them as usual; the rest expire on their own.
+func (node *Node) loadState() error {
+	file, err := os.Open(node.statePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fileReader := bufio.NewScanner(file)
+	if !fileReader.Scan() {
+		return fileReader.Err()
+	}
+	tokens := strings.Fields(fileReader.Text())
+	if len(tokens) != 2 || tokens[0] != "saved" {
+		return fmt.Errorf("malformed state file header %q", fileReader.Text())
+	}
+	saved, err := time.Parse(time.RFC3339Nano, tokens[1])
+	if err != nil {
+		return err
+	}
+	elapsed := time.Since(saved)
+	restored := 0
+	node.rtMtx.Lock()
+	defer node.rtMtx.Unlock()
+	for fileReader.Scan() {
+		text := fileReader.Text()
+		tokens := strings.Fields(text)
+		if len(tokens) != 10 || tokens[0] != "route" || tokens[2] != "via" || tokens[4] != "cost" || tokens[6] != "tag" || tokens[8] != "ttl" {
+			return fmt.Errorf("malformed state file line %q", text)
+		}
+		route, err := ParsePrefix(tokens[1])
+		if err != nil {
+			return err
+		}
+		cost, costErr := strconv.ParseUint(tokens[5], 10, 32)
+		tag, tagErr := strconv.ParseUint(tokens[7], 10, 32)
+		ttl, ttlErr := time.ParseDuration(tokens[9])
+		if costErr != nil || tagErr != nil || ttlErr != nil {
+			return fmt.Errorf("malformed state file line %q", text)
+		}
+		// Skip routes that have timed out since, or whose neighbour is no longer configured.
+		lifetime := ttl - elapsed
+		if lifetime > node.RIPTimeout {
+			lifetime = node.RIPTimeout
+		}
+		linkID, ok := node.NeighborInterface(net.ParseIP(tokens[3]))
+		if lifetime <= 0 || !ok || uint32(cost) >= util.INFINITY {
+			continue
+		}
+		if _, local := node.candidate(route, SRC_CONNECTED); local {
+			continue
+		}
+		entry := node.newRIPEntry(route, node.LocalInterfaces[linkID], uint32(cost), uint32(tag), lifetime)
+		entry.Stale = true
+		node.setCandidate(route, entry, CAUSE_RESTART)
+		restored++
+	}
+	if err := fileReader.Err(); err != nil {
+		return err
+	}
+	ribLog.Info("restored routes", "file", node.statePath, "count", restored)
+	return nil
+}
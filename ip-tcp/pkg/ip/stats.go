@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	atomic "go.uber.org/atomic"
+)
+
+// Traffic counters for an interface.
+type interfaceCounters struct {
+	txPackets atomic.Uint64
+	txBytes   atomic.Uint64
+	rxPackets atomic.Uint64
+	rxBytes   atomic.Uint64
+	dropped   atomic.Uint64 // Packets dropped on the way in or out: interface down, bad checksum, mtu or loss.
+}
+
+// Counts a packet sent on the interface.
+func (c *interfaceCounters) sent(n int) {
+	c.txPackets.Inc()
+	c.txBytes.Add(uint64(n))
+}
+
+// Counts a packet received on the interface.
+func (c *interfaceCounters) received(n int) {
+	c.rxPackets.Inc()
+	c.rxBytes.Add(uint64(n))
+}
+
+// Counts of RIP messages, by command.
+type ripCounters struct {
+	requests  atomic.Uint64
+	responses atomic.Uint64
+}
+
+// Counts a message with the given RIP command.
+func (c *ripCounters) count(command uint16) {
+	switch command {
+	case 1:
+		c.requests.Inc()
+	case 2:
+		c.responses.Inc()
+	}
+}
+
+// InterfaceStats counts the traffic on one of our interfaces.
+type InterfaceStats struct {
+	ID        int
+	Name      string
+	Up        bool
+	TxPackets uint64
+	TxBytes   uint64
+	RxPackets uint64
+	RxBytes   uint64
+	Dropped   uint64
+}
+
+// Gets traffic counts for each of our interfaces.
+func (node *Node) InterfaceStats() []InterfaceStats {
+	stats := make([]InterfaceStats, 0, len(node.LocalInterfaces))
+	for i, interf := range node.LocalInterfaces {
+		interf.Lock.RLock()
+		up := interf.Enabled
+		interf.Lock.RUnlock()
+		stats = append(stats, InterfaceStats{
+			ID:        i,
+			Name:      interf.Name,
+			Up:        up,
+			TxPackets: interf.stats.txPackets.Load(),
+			TxBytes:   interf.stats.txBytes.Load(),
+			RxPackets: interf.stats.rxPackets.Load(),
+			RxBytes:   interf.stats.rxBytes.Load(),
+			Dropped:   interf.stats.dropped.Load(),
+		})
+	}
+	return stats
+}
+
+// RoutingStats summarises the routing table and the churn in it.
+type RoutingStats struct {
+	Routes      int                       // Prefixes in the forwarding table.
+	Candidates  int                       // Candidate routes in the RIB, across every source.
+	Changes     map[RouteEventType]uint64 // Forwarding table changes since startup, by type.
+	RIPSent     map[string]uint64         // RIP messages sent, by command: request or response.
+	RIPReceived map[string]uint64         // RIP messages received, by command.
+}
+
+// Gets the size of the routing table and counts of changes and RIP messages.
+func (node *Node) RoutingStats() RoutingStats {
+	stats := RoutingStats{
+		Changes: make(map[RouteEventType]uint64),
+		RIPSent: map[string]uint64{
+			"request":  node.ripSent.requests.Load(),
+			"response": node.ripSent.responses.Load(),
+		},
+		RIPReceived: map[string]uint64{
+			"request":  node.ripReceived.requests.Load(),
+			"response": node.ripReceived.responses.Load(),
+		},
+	}
+	node.rtMtx.RLock()
+	stats.Routes = len(node.RoutingTable)
+	for _, candidates := range node.rib {
+		stats.Candidates += len(candidates)
+	}
+	node.rtMtx.RUnlock()
+	node.subMtx.Lock()
+	for evType, n := range node.routeChanges {
+		stats.Changes[evType] = n
+	}
+	node.subMtx.Unlock()
+	return stats
+}
@@ -0,0 +1,140 @@
+package pkg
+
+import (
+	"errors"
+	"log"
+	"strconv"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Summary is a prefix advertised in place of the more-specific routes it covers.
+type Summary struct {
+	Route     Route
+	Interface int // Interface to summarize on, or -1 for every interface.
+}
+
+// Checks if the summary covers the given route. Only more-specific routes are covered, so a
+// neighbour advertising the summary back to us can't keep it alive.
+func (s *Summary) Covers(route Route) bool {
+	return route.Mask != s.Route.Mask && route.Mask&s.Route.Mask == s.Route.Mask && route.Addr&s.Route.Mask == s.Route.Addr
+}
+
+// Applies a summary directive: [no] summary <prefix> [on if <n>]. policyMtx held on entry.
+func (node *Node) applySummary(tokens []string) error {
+	remove := tokens[0] == "no"
+	if remove {
+		tokens = tokens[1:]
+	}
+	if (len(tokens) != 2 && len(tokens) != 5) || tokens[0] != "summary" {
+		return errors.New("usage: [no] summary <prefix> [on if <n>]")
+	}
+	route, err := ParsePrefix(tokens[1])
+	if err != nil {
+		return err
+	}
+	summary := Summary{Route: route, Interface: -1}
+	if len(tokens) == 5 {
+		if tokens[2] != "on" || tokens[3] != "if" {
+			return errors.New("usage: [no] summary <prefix> [on if <n>]")
+		}
+		inum, err := strconv.Atoi(tokens[4])
+		if err != nil || inum < 0 || inum >= len(node.LocalInterfaces) {
+			return errors.New("invalid interface " + tokens[4])
+		}
+		summary.Interface = inum
+	}
+	for i, s := range node.Summaries {
+		if s == summary {
+			if remove {
+				node.Summaries = append(node.Summaries[:i], node.Summaries[i+1:]...)
+			}
+			return nil
+		}
+	}
+	if remove {
+		return errors.New("no such summary")
+	}
+	node.Summaries = append(node.Summaries, summary)
+	return nil
+}
+
+// Checks if a summary for exactly this route is advertised on an interface. policyMtx held on
+// entry.
+func (node *Node) summarizes(linkID int, route Route) bool {
+	for _, s := range node.Summaries {
+		if s.Route == route && (s.Interface == -1 || s.Interface == linkID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Replaces entries covered by a summary on this interface with the summary itself. The summary is
+// advertised with the lowest cost of any contributing route in the routing table, and with cost
+// infinity once the last contributor is gone. rtMtx and policyMtx held on entry.
+func (node *Node) summarizeRIPEntries(linkID int, entries []RIPEntry) []RIPEntry {
+	interf := node.LocalInterfaces[linkID]
+	touched := make([]bool, len(node.Summaries))
+	summarized := make([]RIPEntry, 0, len(entries))
+	for _, entry := range entries {
+		route := RIPEntryToRoute(&entry)
+		covered := false
+		for i := range node.Summaries {
+			s := &node.Summaries[i]
+			if s.Interface != -1 && s.Interface != linkID {
+				continue
+			}
+			if s.Covers(route) {
+				touched[i] = true
+				covered = true
+			} else if route == s.Route {
+				// The summary replaces any route learned for the same prefix.
+				covered = true
+			}
+		}
+		if !covered {
+			summarized = append(summarized, entry)
+		}
+	}
+	for i, wasTouched := range touched {
+		if !wasTouched {
+			continue
+		}
+		s := &node.Summaries[i]
+		cost, contributors, reverse := util.INFINITY, 0, true
+		for route, rtEntry := range node.RoutingTable {
+			if !s.Covers(route) {
+				continue
+			}
+			contributors++
+			if rtEntry.Cost < cost {
+				cost = rtEntry.Cost
+			}
+			if rtEntry.Interface != interf || rtEntry.Source == SRC_CONNECTED {
+				reverse = false
+			}
+		}
+		// Poison Reverse: every contributor was learned from this interface.
+		if contributors > 0 && reverse {
+			cost = util.INFINITY
+		}
+		summarized = append(summarized, RIPEntry{
+			Cost: cost,
+			Addr: util.Int2IP(s.Route.Addr),
+			Mask: util.Int2IP(s.Route.Mask),
+		})
+	}
+	return summarized
+}
+
+// Prints the configured summaries. policyMtx held on entry.
+func (node *Node) printSummaries() {
+	for _, s := range node.Summaries {
+		on := "all"
+		if s.Interface != -1 {
+			on = strconv.Itoa(s.Interface)
+		}
+		log.Printf("summary %v/%v on if %v\n", util.Int2IP(s.Route.Addr), util.MaskLen(util.Int2IP(s.Route.Mask)), on)
+	}
+}
@@ -0,0 +1,173 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+)
+
+// Every state a socket can be in, so each state always has a connection count.
+var tcpStates = []tcp.TCPState{
+	tcp.S_LISTEN, tcp.S_SYN_SENT, tcp.S_SYN_RCVD, tcp.S_ESTABLISHED, tcp.S_FIN_WAIT_1, tcp.S_FIN_WAIT_2,
+	tcp.S_CLOSE_WAIT, tcp.S_CLOSING, tcp.S_LAST_ACK, tcp.S_TIME_WAIT, tcp.S_CLOSED,
+}
+
+// Route change types, so each always has a count.
+var routeEventTypes = []ip.RouteEventType{ip.ROUTE_ADDED, ip.ROUTE_CHANGED, ip.ROUTE_WITHDRAWN, ip.ROUTE_EXPIRED}
+
+// Creates a handler that serves a node's metrics in the Prometheus text format.
+func Handler(node *ip.Node, driver *tcp.Driver) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Write(w, node, driver)
+	})
+}
+
+// Serves metrics at /metrics on addr in the background. An address without a host, like :9100,
+// listens on localhost only.
+func Serve(addr string, node *ip.Node, driver *tcp.Driver) (*http.Server, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "localhost"
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler(node, driver))
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	return server, nil
+}
+
+// Writes every metric for a node and its TCP stack.
+func Write(out io.Writer, node *ip.Node, driver *tcp.Driver) {
+	w := &writer{out: out}
+
+	interfaces := node.InterfaceStats()
+	w.family("node_interface_up", "Whether the interface is up.", "gauge")
+	for _, s := range interfaces {
+		w.sample("node_interface_up", boolValue(s.Up), "if", strconv.Itoa(s.ID), "name", s.Name)
+	}
+	counters := []struct {
+		name, help string
+		value      func(ip.InterfaceStats) uint64
+	}{
+		{"node_interface_tx_packets_total", "Packets sent on the interface.", func(s ip.InterfaceStats) uint64 { return s.TxPackets }},
+		{"node_interface_tx_bytes_total", "Bytes sent on the interface.", func(s ip.InterfaceStats) uint64 { return s.TxBytes }},
+		{"node_interface_rx_packets_total", "Packets received on the interface.", func(s ip.InterfaceStats) uint64 { return s.RxPackets }},
+		{"node_interface_rx_bytes_total", "Bytes received on the interface.", func(s ip.InterfaceStats) uint64 { return s.RxBytes }},
+		{"node_interface_dropped_packets_total", "Packets dropped on the interface.", func(s ip.InterfaceStats) uint64 { return s.Dropped }},
+	}
+	for _, counter := range counters {
+		w.family(counter.name, counter.help, "counter")
+		for _, s := range interfaces {
+			w.sample(counter.name, float64(counter.value(s)), "if", strconv.Itoa(s.ID), "name", s.Name)
+		}
+	}
+
+	routing := node.RoutingStats()
+	w.family("node_routes", "Prefixes in the forwarding table.", "gauge")
+	w.sample("node_routes", float64(routing.Routes))
+	w.family("node_rib_candidates", "Candidate routes in the routing information base.", "gauge")
+	w.sample("node_rib_candidates", float64(routing.Candidates))
+	w.family("node_route_changes_total", "Changes to the forwarding table.", "counter")
+	for _, evType := range routeEventTypes {
+		w.sample("node_route_changes_total", float64(routing.Changes[evType]), "type", string(evType))
+	}
+	w.family("node_rip_messages_total", "RIP messages sent and received.", "counter")
+	for _, command := range []string{"request", "response"} {
+		w.sample("node_rip_messages_total", float64(routing.RIPSent[command]), "direction", "sent", "command", command)
+		w.sample("node_rip_messages_total", float64(routing.RIPReceived[command]), "direction", "received", "command", command)
+	}
+
+	sockets := driver.Sockets()
+	byState := make(map[tcp.TCPState]int)
+	for _, info := range sockets {
+		byState[info.State]++
+	}
+	w.family("node_tcp_connections", "Sockets in each TCP state.", "gauge")
+	for _, state := range tcpStates {
+		w.sample("node_tcp_connections", float64(byState[state]), "state", string(state))
+	}
+	conns := make([]tcp.SocketInfo, 0, len(sockets))
+	for _, info := range sockets {
+		if info.State != tcp.S_LISTEN {
+			conns = append(conns, info)
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].ID < conns[j].ID })
+	perConn := []struct {
+		name, help, typ string
+		value           func(tcp.SocketInfo) float64
+	}{
+		{"node_tcp_retransmits_total", "Segments the connection has sent again.", "counter", func(s tcp.SocketInfo) float64 { return float64(s.Retransmits) }},
+		{"node_tcp_srtt_seconds", "Smoothed round-trip time of the connection.", "gauge", func(s tcp.SocketInfo) float64 { return s.SRTT.Seconds() }},
+		{"node_tcp_rto_seconds", "Retransmission timeout of the connection.", "gauge", func(s tcp.SocketInfo) float64 { return s.RTO.Seconds() }},
+		{"node_tcp_send_window_bytes", "Window last advertised by the peer.", "gauge", func(s tcp.SocketInfo) float64 { return float64(s.SendWindow) }},
+		{"node_tcp_receive_window_bytes", "Free space in the receive buffer.", "gauge", func(s tcp.SocketInfo) float64 { return float64(s.ReceiveWindow) }},
+		{"node_tcp_receive_buffer_bytes", "Size of the receive buffer, which autotuning grows.", "gauge", func(s tcp.SocketInfo) float64 { return float64(s.ReceiveBuffer) }},
+		{"node_tcp_cwnd_bytes", "Congestion window of the connection.", "gauge", func(s tcp.SocketInfo) float64 { return float64(s.Cwnd) }},
+		{"node_tcp_ssthresh_bytes", "Slow start threshold of the connection.", "gauge", func(s tcp.SocketInfo) float64 { return float64(s.Ssthresh) }},
+	}
+	for _, metric := range perConn {
+		w.family(metric.name, metric.help, metric.typ)
+		for _, s := range conns {
+			w.sample(metric.name, metric.value(s), "socket", strconv.Itoa(s.ID),
+				"local", net.JoinHostPort(s.LocalAddr.String(), strconv.Itoa(int(s.LocalPort))),
+				"remote", net.JoinHostPort(s.RemoteAddr.String(), strconv.Itoa(int(s.RemotePort))))
+		}
+	}
+}
+
+// writer writes metrics in the Prometheus text exposition format.
+type writer struct {
+	out io.Writer
+}
+
+// Writes the help and type lines that start a metric family.
+func (w *writer) family(name string, help string, typ string) {
+	fmt.Fprintf(w.out, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, typ)
+}
+
+// Writes a single sample, with labels given as alternating names and values.
+func (w *writer) sample(name string, value float64, labels ...string) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "%v=\"%v\"", labels[i], escapeLabel(labels[i+1]))
+		}
+		b.WriteString("}")
+	}
+	fmt.Fprintf(&b, " %v\n", strconv.FormatFloat(value, 'g', -1, 64))
+	io.WriteString(w.out, b.String())
+}
+
+// Escapes a label value.
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// Converts a bool to a 0 or 1 sample.
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
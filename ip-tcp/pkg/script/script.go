@@ -0,0 +1,243 @@
+package script
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Runner runs a scenario: REPL commands, one per line, mixed with directives that wait for or
+// check the node's state.
+type Runner struct {
+	node    *ip.Node
+	driver  *tcp.Driver
+	Timeout time.Duration // How long wait-route, wait-state and expect-read wait before failing.
+}
+
+// Creates a runner for a node and its TCP stack.
+func NewRunner(node *ip.Node, driver *tcp.Driver) *Runner {
+	return &Runner{node: node, driver: driver, Timeout: util.SCRIPT_WAIT_TIMEOUT}
+}
+
+// Runs every line of a script in order, stopping early at q. Blank lines and lines starting with
+// # are skipped. Returns how many checks failed, or an error if the script itself is malformed.
+//
+//	sleep <duration>
+//	wait-route <prefix>
+//	wait-state <socket> <state>
+//	expect-read <socket> <data>
+//	assert-route <prefix> [cost <n>] [via <vip>]
+//	assert-route <prefix> none
+func (r *Runner) Run(script io.Reader) (failures int, err error) {
+	scanner := bufio.NewScanner(script)
+	checks := 0
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		log.Printf("%v%v\n", util.PROMPT, line)
+		tokens := strings.Fields(line)
+		var checkErr error
+		switch tokens[0] {
+		case "sleep":
+			if len(tokens) != 2 {
+				return failures, fmt.Errorf("line %v: usage: sleep <duration>", lineNum)
+			}
+			d, err := parseDuration(tokens[1])
+			if err != nil {
+				return failures, fmt.Errorf("line %v: %v", lineNum, err)
+			}
+			time.Sleep(d)
+			continue
+		case "wait-route":
+			if len(tokens) != 2 {
+				return failures, fmt.Errorf("line %v: usage: wait-route <prefix>", lineNum)
+			}
+			route, err := ip.ParsePrefix(tokens[1])
+			if err != nil {
+				return failures, fmt.Errorf("line %v: %v", lineNum, err)
+			}
+			checkErr = r.waitRoute(route)
+		case "wait-state":
+			if len(tokens) != 3 {
+				return failures, fmt.Errorf("line %v: usage: wait-state <socket> <state>", lineNum)
+			}
+			sockID, err := strconv.Atoi(tokens[1])
+			if err != nil {
+				return failures, fmt.Errorf("line %v: invalid socket %v", lineNum, tokens[1])
+			}
+			checkErr = r.waitState(sockID, tcp.TCPState(strings.ToUpper(tokens[2])))
+		case "expect-read":
+			if len(tokens) < 3 {
+				return failures, fmt.Errorf("line %v: usage: expect-read <socket> <data>", lineNum)
+			}
+			sockID, err := strconv.Atoi(tokens[1])
+			if err != nil {
+				return failures, fmt.Errorf("line %v: invalid socket %v", lineNum, tokens[1])
+			}
+			checkErr = r.expectRead(sockID, strings.Join(tokens[2:], " "))
+		case "assert-route":
+			check, err := parseRouteCheck(tokens[1:])
+			if err != nil {
+				return failures, fmt.Errorf("line %v: %v", lineNum, err)
+			}
+			checkErr = r.assertRoute(check)
+		default:
+			// Anything else is a REPL command.
+			readyChan := make(chan bool, 1)
+			if _, done := r.driver.HandleStdin(strings.Split(line, " "), readyChan); done {
+				log.Printf("script stopped at line %v\n", lineNum)
+				return failures, nil
+			}
+			continue
+		}
+		checks++
+		if checkErr != nil {
+			failures++
+			log.Printf("FAIL line %v: %v\n", lineNum, checkErr)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return failures, err
+	}
+	log.Printf("script finished: %v of %v checks failed\n", failures, checks)
+	return failures, nil
+}
+
+// routeCheck is what assert-route expects of a prefix.
+type routeCheck struct {
+	route   ip.Route
+	none    bool   // The prefix should have no route.
+	cost    int    // Expected cost, or -1 for any.
+	nextHop net.IP // Expected next hop, or nil for any.
+}
+
+// Parses the arguments of assert-route.
+func parseRouteCheck(tokens []string) (check routeCheck, err error) {
+	if len(tokens) == 0 {
+		return check, errors.New("usage: assert-route <prefix> [cost <n>] [via <vip>] | <prefix> none")
+	}
+	if check.route, err = ip.ParsePrefix(tokens[0]); err != nil {
+		return check, err
+	}
+	check.cost = -1
+	for i := 1; i < len(tokens); i++ {
+		switch {
+		case tokens[i] == "none" && len(tokens) == 2:
+			check.none = true
+		case tokens[i] == "cost" && i+1 < len(tokens):
+			i++
+			if check.cost, err = strconv.Atoi(tokens[i]); err != nil || check.cost < 0 {
+				return check, fmt.Errorf("invalid cost %v", tokens[i])
+			}
+		case tokens[i] == "via" && i+1 < len(tokens):
+			i++
+			if check.nextHop = net.ParseIP(tokens[i]); check.nextHop == nil {
+				return check, fmt.Errorf("invalid address %v", tokens[i])
+			}
+		default:
+			return check, fmt.Errorf("unexpected %v", tokens[i])
+		}
+	}
+	return check, nil
+}
+
+// Checks the routing table against an assert-route.
+func (r *Runner) assertRoute(check routeCheck) error {
+	entry, exists := r.node.Routes()[check.route]
+	switch {
+	case check.none && exists:
+		return fmt.Errorf("expected no route, got cost %v via %v", entry.Cost, entry.Interface.Remote)
+	case check.none:
+		return nil
+	case !exists:
+		return errors.New("no route")
+	case check.cost >= 0 && entry.Cost != uint32(check.cost):
+		return fmt.Errorf("expected cost %v, got %v", check.cost, entry.Cost)
+	case check.nextHop != nil && !entry.Interface.Remote.Equal(check.nextHop):
+		return fmt.Errorf("expected next hop %v, got %v", check.nextHop, entry.Interface.Remote)
+	}
+	return nil
+}
+
+// Waits for a prefix to appear in the routing table.
+func (r *Runner) waitRoute(route ip.Route) error {
+	return r.poll(func() bool {
+		_, exists := r.node.Routes()[route]
+		return exists
+	}, "timed out waiting for route")
+}
+
+// Waits for a socket to reach a state.
+func (r *Runner) waitState(sockID int, state tcp.TCPState) error {
+	return r.poll(func() bool {
+		for _, info := range r.driver.Sockets() {
+			if info.ID == sockID && info.State == state {
+				return true
+			}
+		}
+		return false
+	}, fmt.Sprintf("timed out waiting for socket %v to be %v", sockID, state))
+}
+
+// Calls done until it returns true, failing after the timeout.
+func (r *Runner) poll(done func() bool, msg string) error {
+	deadline := time.Now().Add(r.Timeout)
+	for !done() {
+		if time.Now().After(deadline) {
+			return errors.New(msg)
+		}
+		time.Sleep(util.SCRIPT_POLL_INTERVAL)
+	}
+	return nil
+}
+
+// Reads exactly as many bytes as expected from a socket and compares them.
+func (r *Runner) expectRead(sockID int, expected string) error {
+	c, err := r.driver.Socket(sockID)
+	if err != nil {
+		return err
+	}
+	// Bound the read with a deadline rather than abandoning it, so it never outlives the step and
+	// takes bytes meant for a later one.
+	if err := c.SetReadDeadline(time.Now().Add(r.Timeout)); err != nil {
+		return err
+	}
+	defer c.SetReadDeadline(time.Time{})
+	data := make([]byte, len(expected))
+	n, err := c.Read(data, uint32(len(data)), true)
+	data = data[:n]
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		return errors.New("timed out waiting for data")
+	} else if err != nil {
+		return err
+	}
+	if string(data) != expected {
+		return fmt.Errorf("expected %q, read %q", expected, data)
+	}
+	return nil
+}
+
+// Parses a duration like 500ms, or a plain number of seconds.
+func parseDuration(s string) (time.Duration, error) {
+	if secs, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(secs * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %v", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,171 @@
+package tcp
+
+import (
+	"fmt"
+	"time"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// BBRMode is the phase a BBR connection is in.
+type BBRMode string
+
+const (
+	BBR_STARTUP   BBRMode = "STARTUP"
+	BBR_DRAIN     BBRMode = "DRAIN"
+	BBR_PROBE_BW  BBRMode = "PROBE_BW"
+	BBR_PROBE_RTT BBRMode = "PROBE_RTT"
+)
+
+// Window gains for each round of PROBE_BW: probe for more bandwidth, drain the queue that made,
+// then cruise.
+var bbrCycleGains = []float64{1.25, 0.75, 1, 1, 1, 1, 1, 1}
+
+// BBR sizes the window from a model of the path: the bottleneck bandwidth and the minimum
+// round-trip time. This is a simplified BBR: we don't pace, so the gains apply to the window, and
+// loss is ignored except for timeouts.
+type BBR struct {
+	mss            uint32
+	cwnd           uint32
+	mode           BBRMode
+	bwSamples      []float64     // Delivery rate of each recent round, in bytes per second.
+	btlBw          float64       // Bottleneck bandwidth: the largest recent delivery rate.
+	minRTT         time.Duration // Smallest recent round-trip time.
+	minRTTStamp    time.Time     // When minRTT was last measured or confirmed.
+	roundStart     time.Time     // When the current round began.
+	roundDelivered uint32        // Bytes acked in the current round.
+	fullBw         float64       // Bandwidth when startup last grew by enough.
+	fullBwRounds   int           // Rounds since startup last grew by enough.
+	fullPipe       bool          // Whether startup has filled the pipe.
+	cycleIndex     int           // Position in bbrCycleGains.
+	probeRTTDone   time.Time     // When PROBE_RTT ends.
+}
+
+// Creates simplified BBR congestion control.
+func NewBBR(mss uint32) CongestionControl {
+	return &BBR{mss: mss, cwnd: initialWindow(mss), mode: BBR_STARTUP}
+}
+
+func (b *BBR) Name() string {
+	return "bbr"
+}
+
+func (b *BBR) Window() uint32 {
+	return b.cwnd
+}
+
+func (b *BBR) Ssthresh() uint32 {
+	return util.TCP_MAX_CWND
+}
+
+// Gets the bandwidth-delay product in bytes.
+func (b *BBR) bdp() float64 {
+	return b.btlBw * b.minRTT.Seconds()
+}
+
+func (b *BBR) OnAck(acked uint32, flight uint32) {
+	now := time.Now()
+	if b.roundStart.IsZero() {
+		b.roundStart = now
+	}
+	b.roundDelivered += acked
+	// Treat each minimum round-trip time as a round, and take its delivery rate as a sample.
+	rtt := b.minRTT
+	if rtt == 0 {
+		rtt = util.DEFAULT_RTT
+	}
+	if elapsed := now.Sub(b.roundStart); elapsed >= rtt {
+		b.bwSamples = append(b.bwSamples, float64(b.roundDelivered)/elapsed.Seconds())
+		if len(b.bwSamples) > util.BBR_BW_WINDOW {
+			b.bwSamples = b.bwSamples[1:]
+		}
+		b.btlBw = 0
+		for _, sample := range b.bwSamples {
+			if sample > b.btlBw {
+				b.btlBw = sample
+			}
+		}
+		b.roundStart, b.roundDelivered = now, 0
+		b.endRound(now, flight)
+	}
+	b.cwnd = b.targetWindow(acked)
+}
+
+// Moves between modes at the end of a round.
+func (b *BBR) endRound(now time.Time, flight uint32) {
+	switch b.mode {
+	case BBR_STARTUP:
+		// The pipe is full once the bandwidth stops growing by a quarter a round.
+		if b.btlBw >= b.fullBw*1.25 {
+			b.fullBw, b.fullBwRounds = b.btlBw, 0
+		} else if b.fullBwRounds++; b.fullBwRounds >= util.BBR_FULL_BW_ROUNDS {
+			b.fullPipe = true
+			b.mode = BBR_DRAIN
+		}
+	case BBR_DRAIN:
+		if float64(flight) <= b.bdp() {
+			b.mode, b.cycleIndex = BBR_PROBE_BW, 0
+		}
+	case BBR_PROBE_BW:
+		b.cycleIndex = (b.cycleIndex + 1) % len(bbrCycleGains)
+	case BBR_PROBE_RTT:
+		if now.After(b.probeRTTDone) {
+			b.minRTTStamp = now
+			b.mode = BBR_PROBE_BW
+			if !b.fullPipe {
+				b.mode = BBR_STARTUP
+			}
+		}
+	}
+	// If the minimum round-trip time hasn't been seen for a while, drain the queue to measure it.
+	if b.mode != BBR_PROBE_RTT && b.minRTT != 0 && now.Sub(b.minRTTStamp) > util.BBR_MIN_RTT_WINDOW {
+		b.mode = BBR_PROBE_RTT
+		b.probeRTTDone = now.Add(util.BBR_PROBE_RTT_DURATION)
+		b.minRTT = 0
+	}
+}
+
+// Computes the window for the current mode.
+func (b *BBR) targetWindow(acked uint32) uint32 {
+	minCwnd := float64(util.BBR_MIN_CWND * b.mss)
+	var cwnd float64
+	switch b.mode {
+	case BBR_STARTUP:
+		// Grow like slow start until the pipe is full.
+		cwnd = float64(b.cwnd) + float64(acked)
+	case BBR_DRAIN:
+		cwnd = b.bdp()
+	case BBR_PROBE_BW:
+		cwnd = util.BBR_CWND_GAIN * bbrCycleGains[b.cycleIndex] * b.bdp()
+	case BBR_PROBE_RTT:
+		cwnd = minCwnd
+	}
+	if cwnd < minCwnd {
+		cwnd = minCwnd
+	}
+	if cwnd > float64(util.TCP_MAX_CWND) {
+		cwnd = float64(util.TCP_MAX_CWND)
+	}
+	return uint32(cwnd)
+}
+
+func (b *BBR) OnLoss(loss LossType, flight uint32) {
+	// The model isn't built from losses, but after a timeout everything in flight is gone.
+	if loss == LOSS_TIMEOUT {
+		b.cwnd = util.BBR_MIN_CWND * b.mss
+	}
+}
+
+func (b *BBR) OnRTTSample(rtt time.Duration) {
+	if b.minRTT == 0 || rtt <= b.minRTT {
+		b.minRTT, b.minRTTStamp = rtt, time.Now()
+	}
+}
+
+func (b *BBR) OnIdleRestart() {
+	// The model still holds after an idle period, so keep the window.
+}
+
+func (b *BBR) State() string {
+	return fmt.Sprintf("mode=%v cwnd=%v btl_bw=%.0fB/s min_rtt=%v", b.mode, b.cwnd, b.btlBw, b.minRTT)
+}
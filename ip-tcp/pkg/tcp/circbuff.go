@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"math"
@@ -18,12 +19,14 @@ type CircBuff struct {
 	left uint32
 	next uint32
 
-	fragments []fragment
+	fragments    []fragment
+	lastFragment uint32 // Start of the fragment received most recently, for SACK.
 
 	finRecvd bool
 	finSeq   uint32
 
 	waitChan chan bool
+	closeErr error // Returned by reads once the buffer is closed.
 	lock     sync.RWMutex
 }
 
@@ -58,7 +61,8 @@ func (cb *CircBuff) setFin(seqNum uint32) {
 	cb.finRecvd = true
 	cb.finSeq = seqNum
 	if cb.waitChan != nil {
-		cb.waitChan <- true
+		// Wake every reader, including ones that have stopped waiting.
+		close(cb.waitChan)
 		cb.waitChan = nil
 	}
 }
@@ -132,27 +136,31 @@ func (cb *CircBuff) PushData(lIdx uint32, data []byte) (success bool, err error)
 	// If within bounds, update our state.
 	if lIdx == cb.next {
 		cb.next += uint32(len(data))
+		// Take in fragments we now reach, and drop ones we've since received again.
 		last := 0
 		for _, f := range cb.fragments {
-			if f.start == cb.next {
-				cb.next += f.len
-				last += 1
-			} else {
+			if seqLess(cb.next, f.start) {
 				break
 			}
+			if end := f.start + f.len; seqLess(cb.next, end) {
+				cb.next = end
+			}
+			last += 1
 		}
 		cb.fragments = cb.fragments[last:]
 	} else {
-		fragment, added := fragment{lIdx, uint32(len(data))}, false
-		for i, f := range cb.fragments {
-			if f.start > lIdx {
-				cb.fragments = append(append(cb.fragments[:i], fragment), cb.fragments[i:]...)
-				added = true
-				break
-			}
+		// Keep fragments in order, without duplicates.
+		cb.lastFragment = lIdx
+		i := 0
+		for i < len(cb.fragments) && seqLess(cb.fragments[i].start, lIdx) {
+			i++
 		}
-		if !added {
-			cb.fragments = append(cb.fragments, fragment)
+		if i == len(cb.fragments) || cb.fragments[i].start != lIdx {
+			cb.fragments = append(cb.fragments, fragment{})
+			copy(cb.fragments[i+1:], cb.fragments[i:])
+			cb.fragments[i] = fragment{lIdx, uint32(len(data))}
+		} else if cb.fragments[i].len < uint32(len(data)) {
+			cb.fragments[i].len = uint32(len(data))
 		}
 	}
 
@@ -169,14 +177,100 @@ func (cb *CircBuff) PushData(lIdx uint32, data []byte) (success bool, err error)
 		return false, fmt.Errorf("error copying data; buffer may be in an inconsistent state. copied %d bytes when should have copied %d", n, len(data))
 	}
 	if cb.waitChan != nil {
-		cb.waitChan <- true
+		// Wake every reader, including ones that have stopped waiting.
+		close(cb.waitChan)
 		cb.waitChan = nil
 	}
 	return true, nil
 }
 
+// Gets up to max SACK blocks for the fragments received early (RFC 2018). The first holds the
+// fragment received most recently, and the rest follow in sequence order.
+func (cb *CircBuff) SACKBlocks(max int) []SACKBlock {
+	cb.lock.RLock()
+	defer cb.lock.RUnlock()
+	if len(cb.fragments) == 0 || max == 0 {
+		return nil
+	}
+	// Merge fragments that touch or overlap.
+	blocks, first := make([]SACKBlock, 0), 0
+	for _, f := range cb.fragments {
+		end := f.start + f.len
+		if n := len(blocks); n > 0 && !seqLess(blocks[n-1].Right, f.start) {
+			if seqLess(blocks[n-1].Right, end) {
+				blocks[n-1].Right = end
+			}
+		} else {
+			blocks = append(blocks, SACKBlock{f.start, end})
+		}
+		if f.start == cb.lastFragment {
+			first = len(blocks) - 1
+		}
+	}
+	blocks = append(append([]SACKBlock{blocks[first]}, blocks[:first]...), blocks[first+1:]...)
+	if len(blocks) > max {
+		blocks = blocks[:max]
+	}
+	return blocks
+}
+
+// Gets the size of the buffer.
+func (cb *CircBuff) GetSize(lock bool) uint32 {
+	if lock {
+		cb.lock.RLock()
+		defer cb.lock.RUnlock()
+	}
+	return cb.size
+}
+
+// Grows the buffer to size bytes, keeping what it holds. It never shrinks, since that could drop
+// data the window already let in. size must be a power of 2 as well, or sequence numbers would map
+// to different slots once they wrap; other sizes are ignored.
+func (cb *CircBuff) Grow(size uint32) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	if size <= cb.size || size&(size-1) != 0 {
+		return
+	}
+	// Everything held, including fragments, is within a buffer's length of left.
+	buff := make([]byte, size)
+	for i := uint32(0); i < cb.size; {
+		src, dst := (cb.left+i)%cb.size, (cb.left+i)%size
+		n := cb.size - i
+		if cb.size-src < n {
+			n = cb.size - src
+		}
+		if size-dst < n {
+			n = size - dst
+		}
+		copy(buff[dst:dst+n], cb.buff[src:src+n])
+		i += n
+	}
+	cb.buff, cb.size = buff, size
+}
+
+// Fails pending and later reads with err.
+func (cb *CircBuff) close(err error) {
+	cb.lock.Lock()
+	defer cb.lock.Unlock()
+	cb.closeErr = err
+	if cb.waitChan != nil {
+		close(cb.waitChan)
+		cb.waitChan = nil
+	}
+}
+
+// errCancelled is returned by pullData when it stops waiting for data.
+var errCancelled = errors.New("wait cancelled")
+
 // Pull up to n bytes.
 func (cb *CircBuff) PullData(n uint32) ([]byte, error) {
+	return cb.pullData(n, nil, nil)
+}
+
+// Pull up to n bytes, failing with errCancelled if deadline or cancel is closed while waiting for
+// data.
+func (cb *CircBuff) pullData(n uint32, deadline <-chan struct{}, cancel <-chan struct{}) ([]byte, error) {
 	// If no data, no-op.
 	if n == 0 {
 		return make([]byte, 0), nil
@@ -185,6 +279,12 @@ func (cb *CircBuff) PullData(n uint32) ([]byte, error) {
 	// Read-lock the buffer.
 	cb.lock.Lock()
 
+	// If the connection is gone, say so.
+	if cb.closeErr != nil {
+		cb.lock.Unlock()
+		return []byte{}, cb.closeErr
+	}
+
 	// If we've fin'd, say so.
 	if cb.finRecvd && cb.finSeq == cb.left {
 		cb.lock.Unlock()
@@ -193,11 +293,23 @@ func (cb *CircBuff) PullData(n uint32) ([]byte, error) {
 
 	// Otherwise, if there is no data, wait.
 	if cb.GetReadySize(false) == 0 {
-		wc := make(chan bool)
-		cb.waitChan = wc
+		if isDone(cancel) {
+			cb.lock.Unlock()
+			return []byte{}, errCancelled
+		}
+		if cb.waitChan == nil {
+			cb.waitChan = make(chan bool)
+		}
+		wc := cb.waitChan
 		cb.lock.Unlock()
-		<-wc
-		return cb.PullData(n)
+		select {
+		case <-wc:
+		case <-deadline:
+			return []byte{}, errCancelled
+		case <-cancel:
+			return []byte{}, errCancelled
+		}
+		return cb.pullData(n, deadline, cancel)
 	}
 
 	defer cb.lock.Unlock()
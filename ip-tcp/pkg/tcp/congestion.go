@@ -0,0 +1,249 @@
+package tcp
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// LossType is how a loss was detected.
+type LossType string
+
+const (
+	LOSS_DUPACK  LossType = "dupack"  // Three duplicate acks; the connection enters fast recovery.
+	LOSS_TIMEOUT LossType = "timeout" // The retransmission timer expired.
+)
+
+// CongestionControl decides how many bytes a connection may have in flight. The connection detects
+// losses and runs fast recovery itself, and tells the algorithm what happened through the hooks.
+// Calls are serialised by the connection.
+type CongestionControl interface {
+	Name() string
+	Window() uint32   // Congestion window in bytes.
+	Ssthresh() uint32 // Slow start threshold in bytes, or TCP_MAX_CWND if there isn't one.
+	// Handles an ack for `acked` new bytes outside fast recovery, with `flight` bytes still
+	// outstanding after it.
+	OnAck(acked uint32, flight uint32)
+	// Handles a loss, with `flight` bytes outstanding when it was detected.
+	OnLoss(loss LossType, flight uint32)
+	// Handles a round-trip time measured from a segment that wasn't retransmitted.
+	OnRTTSample(rtt time.Duration)
+	// Handles sending again after the connection was idle for longer than an RTO.
+	OnIdleRestart()
+	// Describes the algorithm's state, as space-separated key=value pairs.
+	State() string
+}
+
+// CongestionControlFactory creates an algorithm for a connection with the given MSS.
+type CongestionControlFactory func(mss uint32) CongestionControl
+
+var (
+	congestionControls = map[string]CongestionControlFactory{
+		"reno":  NewReno,
+		"cubic": NewCubic,
+		"bbr":   NewBBR,
+	}
+	ccMtx sync.RWMutex
+)
+
+// Makes a congestion control algorithm available by name, replacing any with the same name.
+func RegisterCongestionControl(name string, factory CongestionControlFactory) {
+	ccMtx.Lock()
+	defer ccMtx.Unlock()
+	congestionControls[name] = factory
+}
+
+// Lists the names of the available congestion control algorithms.
+func CongestionControls() []string {
+	ccMtx.RLock()
+	defer ccMtx.RUnlock()
+	names := make([]string, 0, len(congestionControls))
+	for name := range congestionControls {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Creates the named congestion control algorithm.
+func NewCongestionControl(name string, mss uint32) (CongestionControl, error) {
+	ccMtx.RLock()
+	factory, exists := congestionControls[name]
+	ccMtx.RUnlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown congestion control %q; have %v", name, CongestionControls())
+	}
+	return factory(mss), nil
+}
+
+// Gets the initial congestion window from RFC 3390.
+func initialWindow(mss uint32) uint32 {
+	return util.Min(4*mss, util.Max(2*mss, 4380))
+}
+
+// A connection's congestion state: its algorithm, plus fast recovery, which applies whichever
+// algorithm is in use. Without SACK, recovery is NewReno's (RFC 6582), inflating the window by a
+// segment for each duplicate ack. With SACK, the window stays at what the algorithm gave on loss,
+// and the connection counts what is in flight from its scoreboard instead (RFC 6675). Windows are
+// in bytes.
+type congestion struct {
+	mtx         sync.Mutex
+	mss         uint32
+	algo        CongestionControl
+	inRecovery  bool      // Whether we're in fast recovery.
+	recover     uint32    // Highest sequence number sent when recovery or the last timeout began.
+	recoveryWnd uint32    // Window during fast recovery, inflated by duplicates and deflated by partial acks.
+	sack        bool      // Whether the peer sends SACK blocks.
+	lastSend    time.Time // When we last sent a segment.
+}
+
+// Creates congestion state for a connection whose first sequence number is iss.
+func newCongestion(algo CongestionControl, mss uint32, iss uint32) *congestion {
+	return &congestion{mss: mss, algo: algo, recover: iss}
+}
+
+// Gets the congestion window.
+func (cc *congestion) window() uint32 {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	if cc.inRecovery {
+		return cc.recoveryWnd
+	}
+	return cc.algo.Window()
+}
+
+// Fills in the congestion fields of a socket's info.
+func (cc *congestion) describe(info *SocketInfo) {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	info.Cwnd, info.Ssthresh, info.InRecovery = cc.algo.Window(), cc.algo.Ssthresh(), cc.inRecovery
+	if cc.inRecovery {
+		info.Cwnd = cc.recoveryWnd
+	}
+	info.Congestion, info.CongestionState = cc.algo.Name(), cc.algo.State()
+}
+
+// Gets the algorithm's name.
+func (cc *congestion) name() string {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	return cc.algo.Name()
+}
+
+// Replaces the algorithm, created for segments of mss bytes, which starts from its own initial
+// window.
+func (cc *congestion) setAlgorithm(algo CongestionControl, mss uint32) {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	cc.algo, cc.mss = algo, mss
+	cc.inRecovery = false
+}
+
+// Sets whether the peer sends SACK blocks.
+func (cc *congestion) setSACK(sack bool) {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	cc.sack = sack
+}
+
+// Handles an ACK for `acked` new bytes up to ackNum, with `flight` bytes still outstanding after
+// it. Returns true on a partial ACK during fast recovery, when the segment at ackNum should be
+// retransmitted.
+func (cc *congestion) onAck(ackNum uint32, acked uint32, flight uint32) (retransmit bool) {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	if !cc.inRecovery {
+		cc.algo.OnAck(acked, flight)
+		return false
+	}
+	if seqLess(cc.recover, ackNum) {
+		// Full ACK: leave recovery, going back to the algorithm's window.
+		cc.inRecovery = false
+		return false
+	}
+	if cc.sack {
+		// Partial ACK: the scoreboard decides what to resend, and the window stays put.
+		return true
+	}
+	// Partial ACK: deflate by the amount acked, add back a segment and resend the next hole.
+	if acked < cc.recoveryWnd {
+		cc.recoveryWnd -= acked
+	} else {
+		cc.recoveryWnd = cc.mss
+	}
+	if acked >= cc.mss {
+		cc.recoveryWnd += cc.mss
+	}
+	return true
+}
+
+// Handles the nth duplicate ACK for ackNum, with `flight` bytes outstanding and sndNxt the next
+// sequence number to be sent. lost is whether the SACK scoreboard already considers the segment at
+// ackNum lost. Returns true on the third duplicate, or once it's lost, when the segment should be
+// fast retransmitted.
+func (cc *congestion) onDupAck(n uint32, ackNum uint32, flight uint32, sndNxt uint32, lost bool) (retransmit bool) {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	if cc.inRecovery {
+		// Each duplicate means a segment has left the network, which the scoreboard tracks with SACK.
+		if !cc.sack {
+			cc.recoveryWnd = util.Min(cc.recoveryWnd+cc.mss, util.TCP_MAX_CWND)
+		}
+		return false
+	}
+	// Only enter recovery once per window of data, so losses that came before a timeout or an
+	// earlier recovery don't shrink the window again.
+	if (n != util.TCP_DUP_THRESH && !lost) || !seqLess(cc.recover, ackNum) {
+		return false
+	}
+	cc.algo.OnLoss(LOSS_DUPACK, flight)
+	cc.recoveryWnd = cc.algo.Window()
+	if !cc.sack {
+		cc.recoveryWnd += util.TCP_DUP_THRESH * cc.mss
+	}
+	cc.recover = sndNxt - 1
+	cc.inRecovery = true
+	return true
+}
+
+// Handles a retransmission timeout, with `flight` bytes outstanding and sndNxt the next sequence
+// number to be sent.
+func (cc *congestion) onTimeout(flight uint32, sndNxt uint32) {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	cc.algo.OnLoss(LOSS_TIMEOUT, flight)
+	cc.recover = sndNxt - 1
+	cc.inRecovery = false
+}
+
+// Passes on a round-trip time sample.
+func (cc *congestion) onRTTSample(rtt time.Duration) {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	cc.algo.OnRTTSample(rtt)
+}
+
+// Tells the algorithm if we're about to send after being idle for longer than rto, with
+// `outstanding` bytes not yet acked.
+func (cc *congestion) restartIfIdle(outstanding uint32, rto time.Duration) {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	if outstanding == 0 && !cc.lastSend.IsZero() && time.Since(cc.lastSend) > rto {
+		cc.algo.OnIdleRestart()
+	}
+}
+
+// Records that we sent a segment.
+func (cc *congestion) sent() {
+	cc.mtx.Lock()
+	defer cc.mtx.Unlock()
+	cc.lastSend = time.Now()
+}
+
+// Checks if sequence number a comes before b, allowing for wraparound.
+func seqLess(a uint32, b uint32) bool {
+	return int32(a-b) < 0
+}
@@ -1,9 +1,11 @@
 package tcp
 
 import (
+	"context"
 	"errors"
 	"math/rand"
 	"net"
+	"os"
 	"sync"
 	"time"
 
@@ -25,28 +27,72 @@ type Conn struct {
 	driver  *Driver         // Pointer to the "link layer".
 	mailbox chan *TCPPacket // Channel of incoming packets for this socket.
 
-	readyConns chan *Conn // Channel of connections ready to be accepted; should add self to this channel after handshake.
+	readyConns chan *Conn      // Channel of connections ready to be accepted; should add self to this channel after handshake.
+	acceptCtx  context.Context // Cancelled when the listener that would accept us is closed.
 
 	sendBuffer chan *TCPPacket  // Channel of outgoing packets for this socket.
 	sentBuffer []*Retransmitter // Map of sent packets, waiting to time out to retry.
 	srtt       *SRTT            // RTT calculator
+	rtoExpiry  time.Time        // When the retransmission timer fires, or zero if it isn't running.
+	backoff    uint32           // Consecutive timeouts, each doubling the RTO.
 	stbMtx     sync.Mutex
+	rtoWake    chan struct{} // Wakes the retransmit thread when the timer is started or restarted.
+
+	synSent    time.Time     // When we first sent our SYN, to measure the handshake round trip. Guarded by stMtx.
+	synRetried bool          // Whether the SYN was sent again, making the measurement ambiguous. Guarded by stMtx.
+	estSignal  chan struct{} // Wakes Connect once the connection is established.
 
 	seqNum        *atomic.Uint32 // Index of next byte we'll send
 	remoteWinSize *atomic.Uint32 // Last advertised window size
 	remoteAckNum  *atomic.Uint32 // How much they've acked
 	numDupeAcks   *atomic.Uint32 // How many times we've gotten this ack
+	retransmits   atomic.Uint64  // Segments sent again after a timeout or duplicate acks.
+	sndNxt        atomic.Uint32  // Sequence number after the last segment we've sent.
+	cc            *congestion    // Congestion window.
+	ackSignal     chan struct{}  // Wakes the send thread when an ack may have opened the window.
+
+	mss         atomic.Uint32 // Largest segment the peer accepts, less any options we send with data.
+	tsEnabled   atomic.Bool   // Whether both ends send timestamps.
+	tsOffset    uint32        // Start of our timestamp clock.
+	tsRecent    atomic.Uint32 // Latest timestamp from the peer, which we echo.
+	wsEnabled   atomic.Bool   // Whether both ends scale their windows.
+	sndShift    atomic.Uint32 // Shift applied to windows the peer advertises.
+	rcvShift    atomic.Uint32 // Shift applied to windows we advertise.
+	sackEnabled atomic.Bool   // Whether both ends send SACK blocks.
+
+	tuneMtx    sync.Mutex
+	tuneStart  time.Time // When the current autotuning measurement began.
+	tuneCopied uint32    // Bytes the application read since then.
 
 	receiveBuffer *CircBuff // Circular receive buffer
 
+	ctx      context.Context // Cancelled when the driver is closed, or the connection reaches CLOSED.
+	cancel   context.CancelFunc
+	abortErr atomic.Error // Why the connection was aborted, if it was.
+	timeWait *time.Timer  // Moves the connection from TIME_WAIT to CLOSED.
+
+	closed    chan struct{} // Closed once the application closes its handle, with Close or Abort.
+	closeOnce sync.Once
+
+	log *util.Logger // Tags messages with this connection.
+
 	canRead   atomic.Bool // Indicates whether this socket is open for reading.
 	canWrite  atomic.Bool // Indicates whether this socket is open for writing.
 	writeMtx  sync.Mutex
 	writeCond *sync.Cond
+
+	readDeadline  *deadline
+	writeDeadline *deadline
 }
 
 // Create a connection on this node.
 func (d *Driver) Connect(localAddr net.IP, localPort uint16, remoteAddr net.IP, remotePort uint16) (*Conn, error) {
+	return d.ConnectContext(context.Background(), localAddr, localPort, remoteAddr, remotePort)
+}
+
+// Create a connection on this node, giving up on the handshake with ctx.Err() if ctx is done
+// first.
+func (d *Driver) ConnectContext(ctx context.Context, localAddr net.IP, localPort uint16, remoteAddr net.IP, remotePort uint16) (*Conn, error) {
 	// Initialize connection.
 	initialSeqNum := rand.Uint32()
 	c := &Conn{
@@ -66,27 +112,58 @@ func (d *Driver) Connect(localAddr net.IP, localPort uint16, remoteAddr net.IP,
 		numDupeAcks:   atomic.NewUint32(0),
 		canRead:       *atomic.NewBool(true),
 		canWrite:      *atomic.NewBool(false),
-		srtt:          NewSRTT(util.SRTT_INITIAL_GUESS, util.SRTT_ALPHA, util.SRTT_BETA, util.SRTT_MIN, util.SRTT_MAX),
+		srtt:          NewSRTT(util.TCP_RTO_INITIAL, util.TCP_RTO_MIN, util.TCP_RTO_MAX),
+		cc:            newCongestion(d.newCongestionControl(), d.mss, initialSeqNum),
+		ackSignal:     make(chan struct{}, 1),
+		rtoWake:       make(chan struct{}, 1),
+		estSignal:     make(chan struct{}, 1),
+		closed:        make(chan struct{}),
 	}
+	c.sndNxt.Store(initialSeqNum + 1)
+	c.mss.Store(d.mss)
+	c.tsOffset = rand.Uint32()
 	c.writeCond = sync.NewCond(&c.writeMtx)
+	c.readDeadline = newDeadline(nil)
+	c.writeDeadline = newDeadline(c.wakeWriters)
+	c.ctx, c.cancel = context.WithCancel(d.ctx)
+	if d.ctx.Err() != nil {
+		c.cancel()
+		return nil, ErrClosed
+	}
 	// Bind connection to driver
 	cID := ConnID{util.IP2int(localAddr), localPort, util.IP2int(remoteAddr), remotePort}
+	c.log = tcpLog.With("conn", cID)
 	d.bindConnection(cID, c)
-	d.createSocket(cID)
+	d.createSocket(&socket{conn: c})
 	// Start connection utilities.
-	go c.sendThread()
-	go c.receiveThread()
-	go c.retransmitThread()
+	c.start()
 	// Send SYN packet; retry up to X times total.
 	seqnum := c.seqNum.Load()
+	c.synSent = time.Now()
 	c.sendControlMsgManually(F_SYN, seqnum, true)
 	ticker, tries, sent := time.NewTicker(util.TCP_SYN_TIMEOUT_DURATION), 1, false
-	for tries <= util.TCP_MAX_RETRIES {
-		<-ticker.C
+	defer ticker.Stop()
+	for tries <= d.maxRetries {
+		select {
+		case <-ticker.C:
+		case <-c.estSignal:
+		case <-c.ctx.Done():
+			c.release()
+			return nil, c.closeErr()
+		case <-ctx.Done():
+			c.abort(ErrClosed)
+			c.release()
+			return nil, ctx.Err()
+		}
 		c.stMtx.Lock()
 		if c.state == S_SYN_SENT {
 			tries += 1
+			c.synRetried = true
 			c.sendControlMsgManually(F_SYN, seqnum, false)
+		} else if c.state == S_CLOSED {
+			c.stMtx.Unlock()
+			c.release()
+			return nil, c.closeErr()
 		} else {
 			sent = true
 			c.stMtx.Unlock()
@@ -95,13 +172,113 @@ func (d *Driver) Connect(localAddr net.IP, localPort uint16, remoteAddr net.IP,
 		c.stMtx.Unlock()
 	}
 	if !sent {
-		return nil, errors.New("syn timeout")
+		c.abort(ErrTimeout)
+		c.release()
+		return nil, ErrTimeout
 	}
 	return c, nil
 }
 
+// Starts the connection's send, receive and retransmit threads.
+func (c *Conn) start() {
+	c.driver.spawn(c.sendThread)
+	c.driver.spawn(c.receiveThread)
+	c.driver.spawn(c.retransmitThread)
+}
+
+// Tears the connection down once the driver is closed or the connection reaches CLOSED: pending and
+// later reads and writes fail, our timers stop and the driver forgets the connection.
+func (c *Conn) stop() {
+	c.stMtx.Lock()
+	if c.receiveBuffer != nil && !c.handleClosed() {
+		c.receiveBuffer.close(c.closeErr())
+	}
+	if c.timeWait != nil {
+		c.timeWait.Stop()
+	}
+	c.stMtx.Unlock()
+	c.wakeWriters()
+	c.driver.unbindConnection(c)
+}
+
+// Gives up the application's handle on the connection, so pending and later reads and writes fail
+// with ErrClosed. Its socket descriptor is freed once the connection has been torn down as well.
+func (c *Conn) release() {
+	c.closeOnce.Do(func() { close(c.closed) })
+	c.stMtx.Lock()
+	if c.receiveBuffer != nil {
+		c.receiveBuffer.close(ErrClosed)
+	}
+	c.stMtx.Unlock()
+	c.wakeWriters()
+	c.driver.releaseSocket(c)
+}
+
+// Checks if the application has closed its handle on the connection.
+func (c *Conn) handleClosed() bool {
+	return isDone(c.closed)
+}
+
+// Wakes writers waiting for the connection to open for writing.
+func (c *Conn) wakeWriters() {
+	c.writeMtx.Lock()
+	c.writeCond.Broadcast()
+	c.writeMtx.Unlock()
+}
+
+// Gives up on the connection: it moves to CLOSED, and pending and later reads and writes fail
+// with err.
+func (c *Conn) abort(err error) {
+	c.abortErr.Store(err)
+	if err == ErrClosed {
+		c.log.Debug("connection aborted")
+	} else {
+		c.log.Warn("connection aborted", "err", err)
+	}
+	c.stMtx.Lock()
+	c.setState(S_CLOSED)
+	c.stMtx.Unlock()
+}
+
+// Gets the error that operations fail with once the connection has stopped.
+func (c *Conn) closeErr() error {
+	if err := c.abortErr.Load(); err != nil {
+		return err
+	}
+	return ErrClosed
+}
+
+// Waits for d, returning false if the driver was closed first.
+func (c *Conn) sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
+
 // Read n bytes into buf from the connection.
 func (c *Conn) Read(buf []byte, n uint32, block bool) (bytes_read uint32, err error) {
+	return c.read(buf, n, block, nil, nil)
+}
+
+// Reads like Read, but stops waiting for data once ctx is done, failing with ctx.Err().
+func (c *Conn) ReadContext(ctx context.Context, buf []byte, n uint32, block bool) (uint32, error) {
+	return c.read(buf, n, block, ctx.Done(), ctx.Err)
+}
+
+// Reads up to n bytes that have already arrived, without waiting. Fails with ErrWouldBlock if
+// there are none.
+func (c *Conn) TryRead(buf []byte, n uint32) (uint32, error) {
+	return c.read(buf, n, false, alreadyDone, func() error { return ErrWouldBlock })
+}
+
+// Reads n bytes into buf, giving up on waiting for data once cancel is closed, with the error
+// from cancelErr.
+func (c *Conn) read(buf []byte, n uint32, block bool, cancel <-chan struct{}, cancelErr func() error) (uint32, error) {
 	if !c.canRead.Load() {
 		return 0, errors.New("Operation not permitted")
 	}
@@ -110,15 +287,25 @@ func (c *Conn) Read(buf []byte, n uint32, block bool) (bytes_read uint32, err er
 	if bufLen < n {
 		toRead = bufLen
 	}
+	if c.readDeadline.passed() {
+		return 0, os.ErrDeadlineExceeded
+	}
 	// Get the data.
 	var data []byte
 	for uint32(len(data)) < n {
-		d, err := c.receiveBuffer.PullData(toRead - uint32(len(data)))
+		d, err := c.receiveBuffer.pullData(toRead-uint32(len(data)), c.readDeadline.wait(), cancel)
+		if err == errCancelled {
+			err = os.ErrDeadlineExceeded
+			if isDone(cancel) {
+				err = cancelErr()
+			}
+		}
 		if err != nil {
 			copy(buf[:len(data)], data)
 			return uint32(len(data)), err
 		}
 		data = append(data, d...)
+		c.autotune(uint32(len(d)))
 		if !block {
 			break
 		}
@@ -130,18 +317,64 @@ func (c *Conn) Read(buf []byte, n uint32, block bool) (bytes_read uint32, err er
 
 // Write as many bytes in buf as possible into the connection.
 func (c *Conn) Write(buf []byte) (bytesWritten uint32, err error) {
+	return c.write(buf, true)
+}
+
+// Writes as much of buf as the connection takes without waiting. Fails with ErrWouldBlock if that
+// is nothing, for instance before the connection is established or while the send thread is busy.
+func (c *Conn) TryWrite(buf []byte) (uint32, error) {
+	bytesWritten, err := c.write(buf, false)
+	if err == ErrWouldBlock && bytesWritten > 0 {
+		err = nil
+	}
+	return bytesWritten, err
+}
+
+// Writes buf into the connection. Without block, stops with ErrWouldBlock instead of waiting.
+func (c *Conn) write(buf []byte, block bool) (bytesWritten uint32, err error) {
 	// Create segments.
 	bytesWritten, bufLen := 0, uint32(len(buf))
 	for bytesWritten < bufLen {
 		// Wait until the connection is established before sending anything
 		c.writeMtx.Lock()
-		if !c.canWrite.Load() {
+		for block && !c.canWrite.Load() && c.writeErr() == nil {
 			c.writeCond.Wait()
 		}
+		if err := c.writeErr(); err != nil {
+			c.writeMtx.Unlock()
+			return bytesWritten, err
+		}
+		if !c.canWrite.Load() {
+			c.writeMtx.Unlock()
+			return bytesWritten, ErrWouldBlock
+		}
 		// Send data
-		toWrite := util.Min(bufLen-bytesWritten, util.MAX_PACKET_SIZE)
-		packet := c.NewTCPPacket(c.localAddr, c.remoteAddr, buf[bytesWritten:bytesWritten+toWrite], []byte{}, F_ACK, c.seqNum.Load())
-		c.sendBuffer <- packet
+		toWrite := util.Min(bufLen-bytesWritten, c.segmentSize())
+		packet := c.NewTCPPacket(c.localAddr, c.remoteAddr, buf[bytesWritten:bytesWritten+toWrite], F_ACK, c.seqNum.Load())
+		sent := false
+		select {
+		case c.sendBuffer <- packet:
+			sent = true
+		default:
+		}
+		if !sent && !block {
+			c.writeMtx.Unlock()
+			return bytesWritten, ErrWouldBlock
+		}
+		if !sent {
+			select {
+			case c.sendBuffer <- packet:
+			case <-c.ctx.Done():
+				c.writeMtx.Unlock()
+				return bytesWritten, c.closeErr()
+			case <-c.closed:
+				c.writeMtx.Unlock()
+				return bytesWritten, ErrClosed
+			case <-c.writeDeadline.wait():
+				c.writeMtx.Unlock()
+				return bytesWritten, os.ErrDeadlineExceeded
+			}
+		}
 		c.seqNum.Add(toWrite)
 		c.writeMtx.Unlock()
 		bytesWritten += toWrite
@@ -149,9 +382,61 @@ func (c *Conn) Write(buf []byte) (bytesWritten uint32, err error) {
 	return bytesWritten, nil
 }
 
-// Close this connection.
+// Close this connection. Pending and later reads and writes fail with ErrClosed. Its socket
+// descriptor can't be used afterwards, and is freed once the connection reaches CLOSED.
 func (c *Conn) Close() error {
 	c.triggerClose()
+	c.release()
+	return nil
+}
+
+// Aborts this connection (RFC 793's ABORT call): unsent and unread data is discarded, and pending
+// and later reads and writes fail with ErrClosed. A peer that may think the connection is open
+// is sent a RST.
+func (c *Conn) Abort() error {
+	c.stMtx.Lock()
+	state := c.state
+	c.stMtx.Unlock()
+	switch state {
+	case S_SYN_RCVD, S_ESTABLISHED, S_FIN_WAIT_1, S_FIN_WAIT_2, S_CLOSE_WAIT:
+		c.send(c.NewTCPPacket(c.localAddr, c.remoteAddr, []byte{}, F_RST|F_ACK, c.sndNxt.Load()))
+	}
+	c.abort(ErrClosed)
+	c.release()
+	return nil
+}
+
+// Gets the error a write fails with before sending anything, if any.
+func (c *Conn) writeErr() error {
+	switch {
+	case c.handleClosed():
+		return ErrClosed
+	case c.ctx.Err() != nil:
+		return c.closeErr()
+	case c.writeDeadline.passed():
+		return os.ErrDeadlineExceeded
+	}
+	return nil
+}
+
+// Sets the read and write deadlines, as with net.Conn.
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// Sets the time after which pending and later reads fail with os.ErrDeadlineExceeded. The zero
+// time means reads never time out.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+// Sets the time after which pending and later writes fail with os.ErrDeadlineExceeded. The zero
+// time means writes never time out. Bytes already queued are still sent.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
 	return nil
 }
 
@@ -175,11 +460,24 @@ func (c *Conn) Shutdown(cmd int) error {
 	return nil
 }
 
+// Parses a shutdown type for Shutdown from read, write or both.
+func ParseShutdown(mode string) (int, error) {
+	switch mode {
+	case "write":
+		return 1, nil
+	case "read":
+		return 2, nil
+	case "both":
+		return 3, nil
+	}
+	return 0, errors.New("mode is not valid")
+}
+
 // Send a control message with the given flags. `inc` specifies if this is a zero-data packet or not.
 func (c *Conn) sendControlMsgManually(flags uint16, seqnum uint32, inc bool) {
 	// Construct and send the packet.
-	pkt := c.NewTCPPacket(c.localAddr, c.remoteAddr, []byte{}, []byte{}, flags, seqnum)
-	c.driver.node.Send(6, pkt.Serialize(), util.DEFAULT_TTL, pkt.srcAddr, pkt.destAddr)
+	pkt := c.NewTCPPacket(c.localAddr, c.remoteAddr, []byte{}, flags, seqnum)
+	c.send(pkt)
 	if inc {
 		c.seqNum.Add(1)
 	}
@@ -190,8 +488,12 @@ func (c *Conn) sendControlMsg(flags uint16, inc bool) {
 	// See if we need to add some dummy data.
 	data := []byte{}
 	// Construct and send the packet.
-	pkt := c.NewTCPPacket(c.localAddr, c.remoteAddr, data, []byte{}, flags, c.seqNum.Load())
-	c.sendBuffer <- pkt
+	pkt := c.NewTCPPacket(c.localAddr, c.remoteAddr, data, flags, c.seqNum.Load())
+	select {
+	case c.sendBuffer <- pkt:
+	case <-c.ctx.Done():
+		return
+	}
 	if inc {
 		c.seqNum.Add(1)
 	}
@@ -199,8 +501,190 @@ func (c *Conn) sendControlMsg(flags uint16, inc bool) {
 
 // Sends an ACK. Notice that this bypasses the typical TCP sending protocol, and doesn't retry.
 func (c *Conn) sendAck() {
-	packet := c.NewTCPPacket(c.localAddr, c.remoteAddr, []byte{}, []byte{}, F_ACK, c.seqNum.Load())
-	c.driver.node.Send(6, packet.Serialize(), util.DEFAULT_TTL, c.localAddr, c.remoteAddr)
+	packet := c.NewTCPPacket(c.localAddr, c.remoteAddr, []byte{}, F_ACK, c.seqNum.Load())
+	c.send(packet)
+}
+
+// Sends a segment, with the options and checksum as of now. Retransmissions go through here
+// too, so they carry a fresh timestamp.
+func (c *Conn) send(pkt *TCPPacket) {
+	out := *pkt
+	if c.receiveBuffer != nil {
+		out.ackNum = c.receiveBuffer.GetAckNum(true)
+		out.winSize = c.advertisedWindow(pkt.isSyn())
+	}
+	opts := c.segmentOptions(pkt.flags)
+	if c.sackEnabled.Load() && c.receiveBuffer != nil && len(pkt.data) == 0 {
+		// Only segments without data carry SACK blocks, so data segments keep to the MSS.
+		opts.SACKBlocks = c.receiveBuffer.SACKBlocks(opts.maxSACKBlocks())
+	}
+	out.setOptions(opts)
+	out.checksum = 0
+	out.checksum = TCPChecksum(&out)
+	c.driver.node.Send(6, out.Serialize(), util.DEFAULT_TTL, out.srcAddr, out.destAddr)
+}
+
+// Gets the options for a segment with the given flags. SYNs carry our MSS and offer timestamps,
+// window scaling and SACK. A SYN+ACK only offers what the SYN did. Timestamps are then sent on
+// every segment if both ends offered them.
+func (c *Conn) segmentOptions(flags uint16) TCPOptions {
+	opts := TCPOptions{Timestamps: c.tsEnabled.Load()}
+	if flags&F_SYN != 0 {
+		opts.MSS = uint16(c.driver.mss)
+		opts.WindowScaling = c.wsEnabled.Load()
+		opts.WindowScale = windowShift(c.driver.maxWindow)
+		opts.SACKPermitted = c.sackEnabled.Load()
+		if flags&F_ACK == 0 {
+			opts.Timestamps, opts.WindowScaling, opts.SACKPermitted = true, true, true
+		}
+	}
+	if opts.Timestamps {
+		opts.TSVal = c.tsClock()
+		opts.TSEcr = c.tsRecent.Load()
+	}
+	return opts
+}
+
+// Gets our timestamp clock, which ticks every TCP_TS_TICK from a random offset.
+func (c *Conn) tsClock() uint32 {
+	return uint32(time.Since(tsEpoch)/util.TCP_TS_TICK) + c.tsOffset
+}
+
+// Takes the peer's MSS and whether it sends timestamps from its SYN or SYN+ACK. stMtx held on
+// entry.
+func (c *Conn) negotiate(packet *TCPPacket) {
+	c.tsEnabled.Store(packet.opts.Timestamps)
+	c.tsRecent.Store(packet.opts.TSVal)
+	// Windows are only scaled if both ends offer to (RFC 7323 section 2.2).
+	c.wsEnabled.Store(packet.opts.WindowScaling)
+	if packet.opts.WindowScaling {
+		sndShift := packet.opts.WindowScale
+		if sndShift > util.TCP_MAX_WINDOW_SHIFT {
+			sndShift = util.TCP_MAX_WINDOW_SHIFT
+		}
+		c.sndShift.Store(uint32(sndShift))
+		c.rcvShift.Store(uint32(windowShift(c.driver.maxWindow)))
+	}
+	c.sackEnabled.Store(packet.opts.SACKPermitted)
+	c.cc.setSACK(packet.opts.SACKPermitted)
+	peerMSS := util.TCP_DEFAULT_MSS
+	if packet.opts.MSS != 0 {
+		peerMSS = util.Max(uint32(packet.opts.MSS), util.TCP_MIN_MSS)
+	}
+	c.mss.Store(util.Min(c.driver.mss, peerMSS))
+	// Nothing has been sent yet, so the congestion window can start over in the new segment size.
+	if algo, err := NewCongestionControl(c.cc.name(), c.segmentSize()); err == nil {
+		c.cc.setAlgorithm(algo, c.segmentSize())
+	}
+	c.log.Debug("negotiated options", "mss", c.mss.Load(), "timestamps", packet.opts.Timestamps,
+		"wscale", packet.opts.WindowScaling, "snd_shift", c.sndShift.Load(), "rcv_shift", c.rcvShift.Load(),
+		"sack", packet.opts.SACKPermitted)
+}
+
+// Gets the smallest window scale that can advertise a window of size bytes.
+func windowShift(size uint32) uint8 {
+	shift := uint8(0)
+	for size>>shift > uint32(^uint16(0)) && shift < util.TCP_MAX_WINDOW_SHIFT {
+		shift++
+	}
+	return shift
+}
+
+// Gets the window to put in a segment: the free space in our receive buffer, scaled down unless
+// it's a SYN.
+func (c *Conn) advertisedWindow(syn bool) uint16 {
+	window := c.receiveBuffer.GetWindowSize(true)
+	if !syn {
+		window >>= c.rcvShift.Load()
+	}
+	return uint16(util.Min(window, uint32(^uint16(0))))
+}
+
+// Gets the window the peer advertised in a segment, in bytes.
+func (c *Conn) peerWindow(packet *TCPPacket) uint32 {
+	if packet.isSyn() {
+		return uint32(packet.winSize)
+	}
+	return uint32(packet.winSize) << c.sndShift.Load()
+}
+
+// Grows the receive buffer when the application reads more than half of it in a round trip, so
+// the window stays ahead of what the sender could send (receive buffer autotuning, as in Linux).
+// Each growth doubles it, up to the largest window we can advertise, rounded down to a power of 2
+// so the buffer stays one.
+func (c *Conn) autotune(copied uint32) {
+	c.tuneMtx.Lock()
+	defer c.tuneMtx.Unlock()
+	now := time.Now()
+	if c.tuneStart.IsZero() {
+		c.tuneStart = now
+	}
+	c.tuneCopied += copied
+	rtt := c.srtt.GetSRTT()
+	if rtt == 0 {
+		rtt = util.DEFAULT_RTT
+	}
+	if now.Sub(c.tuneStart) < rtt {
+		return
+	}
+	size := c.receiveBuffer.GetSize(true)
+	limit := util.PowerOf2Floor(util.Min(c.driver.maxWindow, uint32(^uint16(0))<<c.rcvShift.Load()))
+	if 2*c.tuneCopied >= size && size < limit {
+		grown := util.Min(2*size, limit)
+		c.receiveBuffer.Grow(grown)
+		c.log.Debug("grew receive buffer", "from", size, "to", grown, "copied", c.tuneCopied, "rtt", rtt)
+	}
+	c.tuneStart, c.tuneCopied = now, 0
+}
+
+// Gets how much data fits in a segment: the MSS less the options we send with data.
+func (c *Conn) segmentSize() uint32 {
+	if c.tsEnabled.Load() {
+		return c.mss.Load() - (OPT_TIMESTAMP_LEN + 2)
+	}
+	return c.mss.Load()
+}
+
+// Checks the timestamp of a segment on an established connection, dropping old duplicates
+// (PAWS, RFC 7323 section 5) and remembering the timestamp to echo. Returns false if the segment
+// should be dropped.
+func (c *Conn) checkTimestamp(packet *TCPPacket) bool {
+	if !c.tsEnabled.Load() || packet.isSyn() || packet.isRst() {
+		return true
+	}
+	if !packet.opts.Timestamps {
+		c.log.Debug("dropping segment without a timestamp", "seq", packet.seqNum)
+		return false
+	}
+	tsRecent := c.tsRecent.Load()
+	if seqLess(packet.opts.TSVal, tsRecent) {
+		if len(packet.data) == 0 && !packet.isFin() {
+			// Acks overtake each other harmlessly, and they're how the window opens.
+			return true
+		}
+		c.log.Debug("dropping old duplicate", "seq", packet.seqNum, "tsval", packet.opts.TSVal, "ts_recent", tsRecent)
+		c.sendAck()
+		return false
+	}
+	// Only segments that don't leave a hole update what we echo, so the peer's measurements include
+	// any time spent waiting for a retransmission.
+	if c.receiveBuffer != nil && !seqLess(c.receiveBuffer.GetAckNum(true), packet.seqNum) {
+		c.tsRecent.Store(packet.opts.TSVal)
+	}
+	return true
+}
+
+// Measures a round trip from the timestamp a segment echoes, or returns 0 if it makes no sense.
+func (c *Conn) timestampRTT(tsEcr uint32) time.Duration {
+	rtt := time.Duration(c.tsClock()-tsEcr) * util.TCP_TS_TICK
+	if rtt > util.TCP_RTO_MAX {
+		return 0
+	}
+	if rtt < util.TCP_TS_TICK {
+		// Anything shorter than a tick reads as 0.
+		return util.TCP_TS_TICK
+	}
+	return rtt
 }
 
 // Initiate RTO retry
@@ -210,20 +694,67 @@ func (c *Conn) initiateRto(pkt *TCPPacket) {
 		c:           c,
 		pkt:         pkt,
 		firstSeqNum: pkt.seqNum,
+		sent:        time.Now(),
 		len:         uint32(len(pkt.data)),
 		retried:     *atomic.NewUint32(0),
 	}
 	c.sentBuffer = append(c.sentBuffer, rt)
+	if c.rtoExpiry.IsZero() {
+		c.restartRetransmitTimer()
+	}
 	c.stbMtx.Unlock()
 }
 
+// (Re)starts the retransmission timer with the current, backed off, RTO. stbMtx held on entry.
+func (c *Conn) restartRetransmitTimer() {
+	c.rtoExpiry = time.Now().Add(c.srtt.GetBackedOffRTO(c.backoff))
+	select {
+	case c.rtoWake <- struct{}{}:
+	default:
+	}
+}
+
+// Handles an ack of new data: restarts the retransmission timer if anything is still outstanding,
+// or stops it, and forgets earlier timeouts. stbMtx held on entry.
+func (c *Conn) resetRetransmitTimer() {
+	c.backoff = 0
+	for _, rt := range c.sentBuffer {
+		if !rt.acked {
+			c.restartRetransmitTimer()
+			return
+		}
+	}
+	c.rtoExpiry = time.Time{}
+}
+
+// Adds a round-trip time sample.
+func (c *Conn) addRTTSample(rtt time.Duration) {
+	c.srtt.AddPoint(rtt)
+	c.cc.onRTTSample(rtt)
+}
+
 // Connection thread to handle sending packets using sliding window protocol.
 func (c *Conn) sendThread() {
 	for {
-		pkt := <-c.sendBuffer
+		var pkt *TCPPacket
+		select {
+		case pkt = <-c.sendBuffer:
+		case <-c.ctx.Done():
+			return
+		}
 		// See if we would be overflowing window size.
 		toSend := uint32(len(pkt.data))
 		currSeq := pkt.seqNum
+		// Wait for room in the congestion window, and in the peer's window while acks for
+		// outstanding data can still open it.
+		c.cc.restartIfIdle(currSeq-c.remoteAckNum.Load(), c.srtt.GetRTO())
+		for !c.cwndAllows(currSeq, toSend) || c.windowBlocked(currSeq, toSend) {
+			select {
+			case <-c.ackSignal:
+			case <-c.ctx.Done():
+				return
+			}
+		}
 
 		lastAcked := c.remoteAckNum.Load()
 		lastWinSize := c.remoteWinSize.Load()
@@ -231,44 +762,129 @@ func (c *Conn) sendThread() {
 
 		if availableSpace <= toSend {
 			// If we would overflow window size, zero window probe until we get everything.
-			zwpSent := uint32(0)
+			zwpSent, probed := uint32(0), false
 			for zwpSent < toSend {
-				// Sent a ZWP packet to grab window size.
-				zwpPkt := c.NewTCPPacket(c.localAddr, c.remoteAddr, []byte{pkt.data[zwpSent]}, []byte{}, F_ACK, currSeq)
-				c.driver.node.Send(6, zwpPkt.Serialize(), util.DEFAULT_TTL, zwpPkt.srcAddr, zwpPkt.destAddr)
+				// Sent a ZWP packet to grab window size. The fragments below start after the probed
+				// byte, so the first probe for each byte is retransmitted until it's acked.
+				zwpPkt := c.NewTCPPacket(c.localAddr, c.remoteAddr, []byte{pkt.data[zwpSent]}, F_ACK, currSeq)
+				c.send(zwpPkt)
+				if !probed {
+					c.initiateRto(zwpPkt)
+					probed = true
+				}
 				// Grab the remote window size, calculate how much we can send.
-				time.Sleep(util.TCP_ZWP_UPDATE_DURATION)
+				if !c.sleep(util.TCP_ZWP_UPDATE_DURATION) {
+					return
+				}
 				lastAcked = c.remoteAckNum.Load()
 				lastWinSize = c.remoteWinSize.Load()
 				availableSpace = lastWinSize - (currSeq - lastAcked)
 				canSend := util.Min(availableSpace, toSend-zwpSent)
 				// Send as much as we can right now, if we can.
 				if canSend > 0 {
-					fragPkt := c.NewTCPPacket(c.localAddr, c.remoteAddr, pkt.data[zwpSent+1:zwpSent+canSend], []byte{}, F_ACK, currSeq+1)
-					c.driver.node.Send(6, fragPkt.Serialize(), util.DEFAULT_TTL, fragPkt.srcAddr, fragPkt.destAddr)
+					fragPkt := c.NewTCPPacket(c.localAddr, c.remoteAddr, pkt.data[zwpSent+1:zwpSent+canSend], F_ACK, currSeq+1)
+					c.send(fragPkt)
 					c.initiateRto(fragPkt)
 					currSeq += canSend
 					zwpSent += canSend
+					probed = false
 				}
 				// Wait until window size increases.
-				time.Sleep(util.TCP_ZWP_WAIT_DURATION)
+				if !c.sleep(util.TCP_ZWP_WAIT_DURATION) {
+					return
+				}
 			}
 		} else {
 			// If we're okay with window size, just send the packet, set up retransmission timeout.
-			c.driver.node.Send(6, pkt.Serialize(), util.DEFAULT_TTL, pkt.srcAddr, pkt.destAddr)
+			c.send(pkt)
 			c.initiateRto(pkt)
 		}
+		c.sndNxt.Store(pkt.seqNum + toSend)
+		c.cc.sent()
+	}
+}
+
+// Checks if the congestion window has room for n bytes starting at seq. A segment may always be
+// sent when nothing is outstanding. With SACK, what counts is the scoreboard's estimate of what
+// is in the network, and segments considered lost are retransmitted before any new data.
+func (c *Conn) cwndAllows(seq uint32, n uint32) bool {
+	cwnd := c.cc.window()
+	outstanding := seq - c.remoteAckNum.Load()
+	if outstanding == 0 {
+		return true
+	}
+	if c.sackEnabled.Load() {
+		c.stbMtx.Lock()
+		defer c.stbMtx.Unlock()
+		return c.nextLost() == nil && c.pipe()+n <= cwnd
+	}
+	return outstanding+n <= cwnd
+}
+
+// Checks if n bytes starting at seq don't fit in the peer's window while data is outstanding, so
+// an ack should open it. With nothing outstanding, we probe the window instead.
+func (c *Conn) windowBlocked(seq uint32, n uint32) bool {
+	acked := c.remoteAckNum.Load()
+	return seq != acked && seq-acked+n > c.remoteWinSize.Load()
+}
+
+// Wakes the send thread if it's waiting for the congestion window to open.
+func (c *Conn) signalAck() {
+	select {
+	case c.ackSignal <- struct{}{}:
+	default:
+	}
+}
+
+// Retransmits the unacked segment starting at seq, if there is one.
+func (c *Conn) retransmitAt(seq uint32) {
+	c.stbMtx.Lock()
+	defer c.stbMtx.Unlock()
+	for _, rt := range c.sentBuffer {
+		if rt.firstSeqNum == seq && !rt.acked {
+			c.retransmit(rt)
+			return
+		}
+	}
+}
+
+// Sends a segment again. stbMtx held on entry.
+func (c *Conn) retransmit(rt *Retransmitter) {
+	rt.execute()
+	rt.retried.Add(1)
+	rt.lost, rt.rexmitted = true, true
+	c.retransmits.Inc()
+}
+
+// Forgets segments at the front of the sent buffer that have been acked. stbMtx held on entry.
+func (c *Conn) dropAcked() {
+	for len(c.sentBuffer) > 0 && c.sentBuffer[0].acked {
+		c.sentBuffer = c.sentBuffer[1:]
 	}
 }
 
 // Connection thread to handle incoming control data.
 func (c *Conn) receiveThread() {
+	defer c.stop()
 	for {
-		pkt := <-c.mailbox
+		var pkt *TCPPacket
+		select {
+		case pkt = <-c.mailbox:
+		case <-c.ctx.Done():
+			return
+		}
+		if !c.checkTimestamp(pkt) {
+			continue
+		}
+		if pkt.isRst() {
+			c.handleReset(pkt)
+			continue
+		}
 		if len(pkt.data) > 0 {
 			// Handle Data component
 			_, err := c.receiveBuffer.PushData(pkt.seqNum, pkt.data)
 			if err != nil {
+				c.log.Debug("dropping segment", "seq", pkt.seqNum, "len", len(pkt.data), "err", err)
 			}
 			c.sendAck()
 		}
@@ -276,30 +892,128 @@ func (c *Conn) receiveThread() {
 	}
 }
 
-// Connection thread to handle retransmitting data.
+// Connection thread to handle retransmitting data. There is one retransmission timer for the
+// connection (RFC 6298): it runs while data is outstanding, restarts on each ack of new data, and
+// when it fires resends the earliest unacked segment and doubles the RTO. The connection is
+// aborted after too many timeouts in a row.
 func (c *Conn) retransmitThread() {
 	for {
-		// Wait RTO for the next retransmission.
-		rto := c.srtt.GetRTO()
-		if rto <= time.Duration(0) {
-			rto = util.DEFAULT_RTO
-		}
-		time.Sleep(rto)
-		// Check if there is anything in the buffer to retransmit.
 		c.stbMtx.Lock()
-		for len(c.sentBuffer) > 0 {
-			// If so, retransmit the first thing that hasn't yet been acked and then continue.
-			rt := c.sentBuffer[0]
-			if !rt.acked {
-				rt.execute()
-				rt.retried.Add(1)
-				c.sentBuffer = append(c.sentBuffer[1:], rt)
-				break
-			}
-			c.sentBuffer = c.sentBuffer[1:]
+		expiry := c.rtoExpiry
+		c.stbMtx.Unlock()
+		var timer *time.Timer
+		var fired <-chan time.Time
+		if !expiry.IsZero() {
+			timer = time.NewTimer(time.Until(expiry))
+			fired = timer.C
+		}
+		aborted := false
+		select {
+		case <-fired:
+			aborted = c.retransmitTimeout()
+		case <-c.rtoWake:
+		case <-c.ctx.Done():
+			aborted = true
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if aborted {
+			return
 		}
+	}
+}
+
+// Handles the retransmission timer firing. Returns true if the connection was aborted.
+func (c *Conn) retransmitTimeout() bool {
+	c.stbMtx.Lock()
+	if c.rtoExpiry.IsZero() || time.Now().Before(c.rtoExpiry) {
+		// Stopped or restarted since.
+		c.stbMtx.Unlock()
+		return false
+	}
+	// Drop what has been acked, and resend the first thing that hasn't.
+	c.dropAcked()
+	if len(c.sentBuffer) == 0 {
+		c.rtoExpiry = time.Time{}
 		c.stbMtx.Unlock()
+		return false
+	}
+	if c.backoff >= c.driver.maxRTOs {
+		c.rtoExpiry = time.Time{}
+		c.stbMtx.Unlock()
+		c.abort(ErrTimeout)
+		return true
+	}
+	rt := c.sentBuffer[0]
+	c.backoff++
+	c.log.Debug("retransmitting", "seq", rt.firstSeqNum, "len", rt.len, "rto", c.srtt.GetBackedOffRTO(c.backoff-1), "backoff", c.backoff)
+	if c.sackEnabled.Load() {
+		c.markAllLost()
+	}
+	c.retransmit(rt)
+	c.restartRetransmitTimer()
+	c.stbMtx.Unlock()
+	sndNxt := c.sndNxt.Load()
+	c.cc.onTimeout(sndNxt-c.remoteAckNum.Load(), sndNxt)
+	return false
+}
+
+// Get the socket descriptor of this connection.
+func (c *Conn) SocketID() int {
+	return c.sockId
+}
+
+// Get the local address and port of this connection.
+func (c *Conn) LocalVIP() (net.IP, uint16) {
+	return c.localAddr, c.localPort
+}
+
+// Get the remote address and port of this connection.
+func (c *Conn) RemoteVIP() (net.IP, uint16) {
+	return c.remoteAddr, c.remotePort
+}
+
+// Describes the connection and its statistics.
+func (c *Conn) Info() SocketInfo {
+	c.stMtx.Lock()
+	state := c.state
+	c.stMtx.Unlock()
+	info := SocketInfo{
+		ID:           c.sockId,
+		LocalAddr:    c.localAddr,
+		LocalPort:    c.localPort,
+		RemoteAddr:   c.remoteAddr,
+		RemotePort:   c.remotePort,
+		State:        state,
+		Retransmits:  c.retransmits.Load(),
+		SRTT:         c.srtt.GetSRTT(),
+		RTO:          c.srtt.GetRTO(),
+		SendWindow:   c.remoteWinSize.Load(),
+		SendScale:    uint8(c.sndShift.Load()),
+		ReceiveScale: uint8(c.rcvShift.Load()),
+		MSS:          c.mss.Load(),
+		Timestamps:   c.tsEnabled.Load(),
+		SACK:         c.sackEnabled.Load(),
+	}
+	if c.receiveBuffer != nil {
+		info.ReceiveWindow = c.receiveBuffer.GetWindowSize(true)
+		info.ReceiveBuffer = c.receiveBuffer.GetSize(true)
+	}
+	c.cc.describe(&info)
+	return info
+}
+
+// Switches the connection to the named congestion control algorithm, which starts from its
+// initial window.
+func (c *Conn) SetCongestionControl(name string) error {
+	algo, err := NewCongestionControl(name, c.segmentSize())
+	if err != nil {
+		return err
 	}
+	c.cc.setAlgorithm(algo, c.segmentSize())
+	c.log.Debug("congestion control changed", "cc", name)
+	return nil
 }
 
 func (c *Conn) getID() ConnID {
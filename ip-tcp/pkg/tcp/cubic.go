@@ -0,0 +1,104 @@
+package tcp
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Cubic grows the window along a cubic curve of the time since the last loss (RFC 8312), so
+// growth doesn't depend on the round-trip time. Windows on the curve are in segments.
+type Cubic struct {
+	mss        uint32
+	cwnd       uint32
+	ssthresh   uint32
+	wMax       float64       // Window just before the last reduction.
+	k          float64       // Seconds from the start of the epoch until the curve reaches wMax.
+	epochStart time.Time     // When the current congestion avoidance epoch began, or zero.
+	minRTT     time.Duration // Smallest round-trip time seen.
+}
+
+// Creates CUBIC congestion control.
+func NewCubic(mss uint32) CongestionControl {
+	return &Cubic{mss: mss, cwnd: initialWindow(mss), ssthresh: util.TCP_MAX_CWND}
+}
+
+func (c *Cubic) Name() string {
+	return "cubic"
+}
+
+func (c *Cubic) Window() uint32 {
+	return c.cwnd
+}
+
+func (c *Cubic) Ssthresh() uint32 {
+	return c.ssthresh
+}
+
+func (c *Cubic) OnAck(acked uint32, flight uint32) {
+	if c.cwnd < c.ssthresh {
+		// Slow start.
+		c.cwnd = util.Min(c.cwnd+util.Min(acked, c.mss), util.TCP_MAX_CWND)
+		return
+	}
+	now, cwnd := time.Now(), float64(c.cwnd)/float64(c.mss)
+	if c.epochStart.IsZero() {
+		c.epochStart = now
+		if cwnd < c.wMax {
+			c.k = math.Cbrt((c.wMax - cwnd) / util.CUBIC_C)
+		} else {
+			c.k, c.wMax = 0, cwnd
+		}
+	}
+	rtt := c.minRTT
+	if rtt == 0 {
+		rtt = util.DEFAULT_RTT
+	}
+	// Aim for where the curve will be a round trip from now, but no lower than what Reno would
+	// have reached in the same time.
+	elapsed := now.Sub(c.epochStart).Seconds()
+	t := elapsed + rtt.Seconds()
+	target := util.CUBIC_C*math.Pow(t-c.k, 3) + c.wMax
+	reno := c.wMax*util.CUBIC_BETA + 3*(1-util.CUBIC_BETA)/(1+util.CUBIC_BETA)*elapsed/rtt.Seconds()
+	target = math.Min(math.Max(target, reno), 1.5*cwnd)
+	inc := float64(c.mss) / 100 / cwnd
+	if target > cwnd {
+		inc = float64(c.mss) * (target - cwnd) / cwnd
+	}
+	c.cwnd = util.Min(c.cwnd+util.Max(1, uint32(inc)), util.TCP_MAX_CWND)
+}
+
+func (c *Cubic) OnLoss(loss LossType, flight uint32) {
+	cwnd := float64(c.cwnd) / float64(c.mss)
+	c.epochStart = time.Time{}
+	// Fast convergence: give up some of our share if the window is shrinking.
+	if cwnd < c.wMax {
+		c.wMax = cwnd * (1 + util.CUBIC_BETA) / 2
+	} else {
+		c.wMax = cwnd
+	}
+	c.ssthresh = util.Max(uint32(float64(c.cwnd)*util.CUBIC_BETA), 2*c.mss)
+	if loss == LOSS_TIMEOUT {
+		c.cwnd = c.mss
+	} else {
+		c.cwnd = c.ssthresh
+	}
+}
+
+func (c *Cubic) OnRTTSample(rtt time.Duration) {
+	if c.minRTT == 0 || rtt < c.minRTT {
+		c.minRTT = rtt
+	}
+}
+
+func (c *Cubic) OnIdleRestart() {
+	// Don't count the idle time as time on the curve.
+	c.epochStart = time.Time{}
+	c.cwnd = util.Min(c.cwnd, initialWindow(c.mss))
+}
+
+func (c *Cubic) State() string {
+	return fmt.Sprintf("cwnd=%v ssthresh=%v w_max=%.1f k=%.3fs min_rtt=%v", c.cwnd, c.ssthresh, c.wMax, c.k, c.minRTT)
+}
@@ -0,0 +1,96 @@
+package tcp
+
+import (
+	"sync"
+	"time"
+)
+
+// A deadline for one direction of a connection, as net.Conn has. Waiters select on the channel
+// from wait, which is closed once the deadline has passed.
+type deadline struct {
+	mtx   sync.Mutex
+	timer *time.Timer
+	done  chan struct{}
+	wake  func() // Called when the deadline passes, for waiters on a condition variable.
+}
+
+func newDeadline(wake func()) *deadline {
+	return &deadline{done: make(chan struct{}), wake: wake}
+}
+
+// Sets the deadline. The zero time means there is none.
+func (d *deadline) set(t time.Time) {
+	if d.reset(t) {
+		d.expired()
+	}
+}
+
+// Sets the deadline, and reports whether it passed just now. Waiters are woken without holding mtx,
+// since they hold their own locks while checking the deadline.
+func (d *deadline) reset(t time.Time) bool {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// Let the timer finish closing done.
+		<-d.done
+	}
+	d.timer = nil
+	passed := isDone(d.done)
+	if t.IsZero() {
+		if passed {
+			d.done = make(chan struct{})
+		}
+		return false
+	}
+	if dur := time.Until(t); dur > 0 {
+		if passed {
+			d.done = make(chan struct{})
+		}
+		done := d.done
+		d.timer = time.AfterFunc(dur, func() {
+			close(done)
+			d.expired()
+		})
+		return false
+	}
+	if !passed {
+		close(d.done)
+	}
+	return !passed
+}
+
+// Gets a channel that is closed once the deadline passes.
+func (d *deadline) wait() <-chan struct{} {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.done
+}
+
+// Checks if the deadline has passed.
+func (d *deadline) passed() bool {
+	return isDone(d.wait())
+}
+
+// Wakes waiters that can't select on done.
+func (d *deadline) expired() {
+	if d.wake != nil {
+		d.wake()
+	}
+}
+
+// A channel that is always closed, for waits that mustn't block.
+var alreadyDone = make(chan struct{})
+
+func init() {
+	close(alreadyDone)
+}
+
+// Checks if a channel has been closed.
+func isDone(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -1,7 +1,9 @@
 package tcp
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -15,6 +17,28 @@ import (
 	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
 )
 
+var tcpLog = util.NewLogger("tcp")
+
+// Where every connection's timestamp clock counts from, before its random offset.
+var tsEpoch = time.Now()
+
+// ErrClosed is returned by pending and later operations on a socket once it or its driver is closed.
+var ErrClosed = errors.New("socket closed")
+
+// ErrTimeout is returned by Connect, and by pending and later operations on a connection that was
+// aborted, when the peer stops responding.
+var ErrTimeout = errors.New("connection timed out")
+
+// ErrReset is returned by pending and later operations on a connection the peer reset.
+var ErrReset = errors.New("connection reset")
+
+// ErrRefused is returned by Connect when the peer answers our SYN with a RST.
+var ErrRefused = errors.New("connection refused")
+
+// ErrWouldBlock is returned by the non-blocking TryRead, TryWrite and TryAccept when they would
+// have to wait.
+var ErrWouldBlock = errors.New("operation would block")
+
 // ConnID uniquely identifies a connection.
 type ConnID struct {
 	localAddr  uint32
@@ -23,41 +47,181 @@ type ConnID struct {
 	remotePort uint16
 }
 
+// Formats a connection as local-remote, like 10.0.0.1:1024-10.0.0.2:80.
+func (id ConnID) String() string {
+	return fmt.Sprintf("%v:%v-%v:%v", util.Int2IP(id.localAddr), id.localPort, util.Int2IP(id.remoteAddr), id.remotePort)
+}
+
 // Driver is like the "link layer" or "os" for the TCP stack.
 type Driver struct {
 	node     *ip.Node // The node in the underlying network.
 	nextPort uint16
 
+	windowSize uint32 // Initial size of each connection's receive buffer.
+	maxWindow  uint32 // Largest a receive buffer may grow to.
+	mss        uint32 // Largest payload we put in a segment.
+	maxRetries int    // Handshake retransmissions before giving up.
+	maxRTOs    uint32 // Consecutive retransmission timeouts before a connection is aborted.
+	cc         string // Congestion control for new connections. Guarded by mtx.
+
 	connTable   map[ConnID]*Conn     // Table of all connections.
 	listTable   map[ConnID]*Listener // Table of all listeners.
-	socketTable []ConnID             // Table of socket descriptors.
+	socketTable []*socket            // Table of socket descriptors, nil where free.
 	mtx         sync.Mutex           // Mutex for all tables
+
+	ctx       context.Context // Cancelled by Close, or when the node is closed.
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup // Goroutines started for sockets.
+	wgMtx     sync.Mutex     // Orders spawn against Close.
+	closed    bool           // Guarded by wgMtx.
+	closeOnce sync.Once
 }
 
-// Create a new driver.
+// Create a new driver, using the node's TCP defaults where it has any.
 func InitDriver(node *ip.Node) *Driver {
-	return &Driver{
+	d := &Driver{
 		node:        node,
 		nextPort:    1024,
+		windowSize:  util.TCP_WINDOW_SIZE,
+		maxWindow:   util.TCP_MAX_WINDOW_SIZE,
+		mss:         util.MAX_PACKET_SIZE,
+		maxRetries:  util.TCP_MAX_RETRIES,
+		maxRTOs:     util.TCP_MAX_RETRANSMITS,
+		cc:          util.TCP_DEFAULT_CC,
 		connTable:   make(map[ConnID]*Conn),
 		listTable:   make(map[ConnID]*Listener),
-		socketTable: make([]ConnID, 0),
+		socketTable: make([]*socket, 0),
+	}
+	d.ctx, d.cancel = context.WithCancel(node.Context())
+	if node.TCP.WindowSize != 0 {
+		d.windowSize = node.TCP.WindowSize
+	}
+	if node.TCP.MaxWindowSize != 0 {
+		d.maxWindow = node.TCP.MaxWindowSize
+	}
+	d.maxWindow = util.Max(d.maxWindow, d.windowSize)
+	if node.TCP.MSS != 0 {
+		d.mss = node.TCP.MSS
+	}
+	if node.TCP.MaxRetries != 0 {
+		d.maxRetries = node.TCP.MaxRetries
 	}
+	if node.TCP.MaxRetransmits != 0 {
+		d.maxRTOs = uint32(node.TCP.MaxRetransmits)
+	}
+	if node.TCP.CongestionControl != "" {
+		if err := d.SetCongestionControl(node.TCP.CongestionControl); err != nil {
+			tcpLog.Warn("keeping default congestion control", "cc", d.cc, "err", err)
+		}
+	}
+	return d
 }
 
-// Clean up driver resources.
-func (d *Driver) teardown() {
+// Sets the congestion control algorithm for new connections.
+func (d *Driver) SetCongestionControl(name string) error {
+	if _, err := NewCongestionControl(name, d.mss); err != nil {
+		return err
+	}
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
-	// Close each socket.
-	for _, conn := range d.connTable {
-		conn.Close()
+	d.cc = name
+	return nil
+}
+
+// Gets the name of the congestion control algorithm for new connections.
+func (d *Driver) CongestionControl() string {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	return d.cc
+}
+
+// Creates the congestion control algorithm for a new connection.
+func (d *Driver) newCongestionControl() CongestionControl {
+	algo, _ := NewCongestionControl(d.CongestionControl(), d.mss)
+	return algo
+}
+
+// Runs f in a goroutine that Close waits for. Does nothing once the driver is closed.
+func (d *Driver) spawn(f func()) {
+	d.wgMtx.Lock()
+	defer d.wgMtx.Unlock()
+	if d.closed {
+		return
 	}
-	for _, list := range d.listTable {
-		list.Close()
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		f()
+	}()
+}
+
+// Stops every socket's goroutines and timers right away, without waiting for the peer. Pending
+// reads, writes and accepts fail with ErrClosed. The node is left running. Safe to call more than
+// once.
+func (d *Driver) Close() error {
+	d.closeOnce.Do(func() {
+		d.wgMtx.Lock()
+		d.closed = true
+		d.wgMtx.Unlock()
+		d.cancel()
+		d.wg.Wait()
+	})
+	return nil
+}
+
+// Closes every connection and waits for our FINs to be acknowledged, or for ctx to be done, before
+// calling Close. Returns ctx.Err() if the connections didn't finish closing in time.
+func (d *Driver) Shutdown(ctx context.Context) error {
+	d.mtx.Lock()
+	conns := make([]*Conn, 0, len(d.connTable))
+	for _, c := range d.connTable {
+		conns = append(conns, c)
+	}
+	d.mtx.Unlock()
+	for _, c := range conns {
+		c.Close()
 	}
-	// Close the link layer.
-	d.node.UDPConn.Close()
+	ticker := time.NewTicker(util.TCP_SHUTDOWN_POLL_INTERVAL)
+	defer ticker.Stop()
+	var err error
+	for err == nil && !allClosed(conns) {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	d.Close()
+	return err
+}
+
+// Checks if every connection has closed, or is only waiting on the peer.
+func allClosed(conns []*Conn) bool {
+	for _, c := range conns {
+		c.stMtx.Lock()
+		state := c.state
+		c.stMtx.Unlock()
+		if state != S_CLOSED && state != S_TIME_WAIT && state != S_FIN_WAIT_2 {
+			return false
+		}
+	}
+	return true
+}
+
+// Clean up driver resources.
+func (d *Driver) teardown() {
+	ctx, cancel := context.WithTimeout(context.Background(), util.TCP_SHUTDOWN_TIMEOUT)
+	defer cancel()
+	d.Shutdown(ctx)
+}
+
+// Hands out the next ephemeral port for an outgoing connection.
+func (d *Driver) EphemeralPort() uint16 {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	port := d.nextPort
+	d.nextPort += 1
+	return port
 }
 
 // Register our connection in the driver
@@ -74,47 +238,84 @@ func (d *Driver) bindListener(ID ConnID, l *Listener) {
 	d.listTable[ID] = l
 }
 
-// Create an entry in the socket table
-func (d *Driver) createSocket(id ConnID) int {
+// Removes a connection that has been torn down from the connection table. Its socket descriptor
+// stays taken until the application closes it too.
+func (d *Driver) unbindConnection(c *Conn) {
+	d.mtx.Lock()
+	if d.connTable[c.getID()] == c {
+		delete(d.connTable, c.getID())
+	}
+	d.mtx.Unlock()
+	d.releaseSocket(c)
+}
+
+// Removes a closed listener from the driver, freeing its socket descriptor.
+func (d *Driver) unbindListener(l *Listener) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	delete(d.listTable, l.getListID())
+	d.socketTable[l.sockId] = nil
+}
+
+// An entry in the socket table: a connection or a listener.
+type socket struct {
+	conn     *Conn
+	listener *Listener
+}
+
+// Create an entry in the socket table, reusing the lowest free descriptor, and give it to the
+// socket.
+func (d *Driver) createSocket(s *socket) int {
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
 	sk := 0
-	for ; sk < len(d.socketTable); sk++ {
-		if _, found := d.connTable[d.socketTable[sk]]; found {
-			continue
-		}
-		if _, found := d.listTable[d.socketTable[sk]]; found {
-			continue
-		}
-		d.socketTable[sk] = id
-		return sk
+	for sk < len(d.socketTable) && d.socketTable[sk] != nil {
+		sk++
+	}
+	if sk == len(d.socketTable) {
+		d.socketTable = append(d.socketTable, s)
+	} else {
+		d.socketTable[sk] = s
+	}
+	if s.conn != nil {
+		s.conn.sockId = sk
+	} else {
+		s.listener.sockId = sk
 	}
-	d.socketTable = append(d.socketTable, id)
 	return sk
 }
 
+// Frees a connection's socket descriptor once the application has closed it and the connection
+// has been torn down, whichever happens last.
+func (d *Driver) releaseSocket(c *Conn) {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	if c.sockId < 0 || !c.handleClosed() || c.ctx.Err() == nil {
+		return
+	}
+	if s := d.socketTable[c.sockId]; s != nil && s.conn == c {
+		d.socketTable[c.sockId] = nil
+	}
+}
+
 // get socket by socket id
 func (d *Driver) getConnSocket(sockID int) *Conn {
-	// Check bounds.
-	if sockID >= len(d.socketTable) {
-		return nil
-	}
-	// Lock mutexes.
 	d.mtx.Lock()
 	defer d.mtx.Unlock()
-	// Grab socket.
-	cid := d.socketTable[sockID]
-	if c, found := d.connTable[cid]; found {
-		return c
+	// Check bounds.
+	if sockID < 0 || sockID >= len(d.socketTable) || d.socketTable[sockID] == nil {
+		return nil
 	}
-	return nil
+	return d.socketTable[sockID].conn
 }
 
 // Handle incoming TCP packets.
 func (d *Driver) TCPHandler(node *ip.Node, packet *ip.IPPacket, _ int) error {
 	// Get the TCP Packet.
 	tcpPacket := &TCPPacket{}
-	tcpPacket.Deserialize(packet.Data)
+	if err := tcpPacket.Deserialize(packet.Data); err != nil {
+		return err
+	}
 	tcpPacket.srcAddr = packet.Header.Src
 	tcpPacket.destAddr = packet.Header.Dst
 	// Check for an open connection first.
@@ -129,8 +330,12 @@ func (d *Driver) TCPHandler(node *ip.Node, packet *ip.IPPacket, _ int) error {
 	d.mtx.Unlock()
 	// If found, send the packet to the connection.
 	if ok {
-		c.mailbox <- tcpPacket
-		return nil
+		select {
+		case c.mailbox <- tcpPacket:
+			return nil
+		case <-c.ctx.Done():
+		}
+		// The connection is gone, so the packet goes to a listener or gets a RST like any other.
 	}
 	// If no corresponding connection, find a suitable listener.
 	d.mtx.Lock()
@@ -140,12 +345,50 @@ func (d *Driver) TCPHandler(node *ip.Node, packet *ip.IPPacket, _ int) error {
 	d.mtx.Unlock()
 	// If found, send the packet to the listener.
 	if ok {
-		l.mailbox <- tcpPacket
+		select {
+		case l.mailbox <- tcpPacket:
+		case <-l.ctx.Done():
+		}
 		return nil
 	}
+	if d.ctx.Err() == nil {
+		d.sendReset(tcpPacket)
+	}
 	return errors.New("no connection or open listener found")
 }
 
+// Answers a segment that no connection can take with a RST (RFC 793 section 3.4). If the segment
+// has an ACK, the RST takes its sequence number from it, so the sender accepts it. Otherwise the
+// RST acks the segment. A RST is never answered.
+func (d *Driver) sendReset(packet *TCPPacket) {
+	if packet.isRst() {
+		return
+	}
+	rst := &TCPPacket{
+		srcPort:  packet.destPort,
+		srcAddr:  packet.destAddr,
+		destPort: packet.srcPort,
+		destAddr: packet.srcAddr,
+		offset:   5,
+		flags:    F_RST,
+	}
+	if packet.isAck() {
+		rst.seqNum = packet.ackNum
+	} else {
+		rst.flags |= F_ACK
+		rst.ackNum = packet.seqNum + uint32(len(packet.data))
+		if packet.isSyn() {
+			rst.ackNum++
+		}
+		if packet.isFin() {
+			rst.ackNum++
+		}
+	}
+	rst.checksum = TCPChecksum(rst)
+	tcpLog.Debug("sending reset", "to", fmt.Sprintf("%v:%v", rst.destAddr, rst.destPort), "port", rst.srcPort, "seq", rst.seqNum)
+	d.node.Send(6, rst.Serialize(), util.DEFAULT_TTL, rst.srcAddr, rst.destAddr)
+}
+
 // Run this driver.
 func (d *Driver) Run() {
 	// Cleanup resources.
@@ -176,17 +419,13 @@ func (d *Driver) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 		log.Println("socket\tlocal-addr\tport\t\tdst-addr\tport\tstatus")
 		log.Println("--------------------------------------------------------------")
 		// Print out each connection.
-		d.mtx.Lock()
-		for sk := 0; sk < len(d.socketTable); sk++ {
-			cid := d.socketTable[sk]
-			if c, found := d.connTable[cid]; found {
-				log.Printf("%d\t%v\t%d\t\t%v\t%d\t%s\n", sk, util.Int2IP(cid.localAddr), cid.localPort, util.Int2IP(cid.remoteAddr), cid.remotePort, c.state)
-			}
-			if _, found := d.listTable[cid]; found {
-				log.Printf("%d\t%v\t\t%d\t\t%v\t\t%d\t%s\n", sk, util.Int2IP(0), cid.localPort, util.Int2IP(cid.remoteAddr), cid.remotePort, "LISTEN")
+		for _, info := range d.Sockets() {
+			if info.State == S_LISTEN {
+				log.Printf("%d\t%v\t\t%d\t\t%v\t\t%d\t%s\n", info.ID, util.Int2IP(0), info.LocalPort, info.RemoteAddr, info.RemotePort, info.State)
+			} else {
+				log.Printf("%d\t%v\t%d\t\t%v\t%d\t%s\n", info.ID, info.LocalAddr, info.LocalPort, info.RemoteAddr, info.RemotePort, info.State)
 			}
 		}
-		d.mtx.Unlock()
 
 	case "a": // Accept on a port
 		if len(tokens) < 2 {
@@ -197,21 +436,10 @@ func (d *Driver) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 		if err != nil {
 			goto done
 		}
-		listener, err := d.Listen(d.node.GetOpenAddr(), uint16(port))
-		if err != nil {
+		if err := d.Serve(uint16(port)); err != nil {
 			log.Println("could not create listener")
 			goto done
 		}
-		go func() {
-			for {
-				sockID, err := listener.Accept()
-				if err != nil {
-					log.Println("Accept() returned error:", err)
-					continue
-				}
-				log.Printf("v_accept() on socket %v returned 1\n", sockID)
-			}
-		}()
 
 	case "c": // Connects to a host
 		if len(tokens) < 3 {
@@ -220,13 +448,12 @@ func (d *Driver) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 		}
 		remoteAddr := net.ParseIP(tokens[1])
 		port, _ := strconv.Atoi(tokens[2])
-		_, err := d.Connect(d.node.GetOpenAddr(), d.nextPort, remoteAddr, uint16(port))
+		_, err := d.Connect(d.node.GetOpenAddr(), d.EphemeralPort(), remoteAddr, uint16(port))
 		if err != nil {
 			log.Printf("v_connect() error: %v\n", err)
 		} else {
 			log.Printf("v_connect() returned 0\n")
 		}
-		d.nextPort += 1
 
 	case "s": // Sends data on a socket
 		if len(tokens) < 3 {
@@ -240,18 +467,17 @@ func (d *Driver) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 		}
 		payload := strings.Join(tokens[2:], " ")
 		// Send data on the specified socket
-		c := d.getConnSocket(sockID)
-		if c != nil {
-			bytesWritten, err := c.Write([]byte(payload))
-			if err != nil {
-				log.Printf("v_write() error: %v\n", err)
-			} else {
-				log.Printf("v_write() on %v bytes returned %v\n", len(payload), bytesWritten)
-			}
-		} else {
-			log.Println("socket is not valid")
+		c, err := d.Socket(sockID)
+		if err != nil {
+			log.Println(err)
 			goto done
 		}
+		bytesWritten, err := c.Write([]byte(payload))
+		if err != nil {
+			log.Printf("v_write() error: %v\n", err)
+		} else {
+			log.Printf("v_write() on %v bytes returned %v\n", len(payload), bytesWritten)
+		}
 
 	case "r": // Receive data
 		if len(tokens) < 3 {
@@ -271,21 +497,20 @@ func (d *Driver) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 		block := len(tokens) == 4 && tokens[3] == "y"
 		// Read data on the specified socket
 		buf := make([]byte, bytesToRead)
-		c := d.getConnSocket(sockID)
-		if c != nil {
-			bytesRead, err := c.Read(buf, uint32(bytesToRead), block)
-			if err != nil {
-				log.Printf("v_read() error: %v\n", err)
-			} else {
-				log.Printf("v_read() on %v bytes returned %v; contents of buffer: '%s'\n", bytesToRead, bytesRead, string(buf))
-			}
-		} else {
-			log.Println("socket is not valid")
+		c, err := d.Socket(sockID)
+		if err != nil {
+			log.Println(err)
 			goto done
 		}
+		bytesRead, err := c.Read(buf, uint32(bytesToRead), block)
+		if err != nil {
+			log.Printf("v_read() error: %v\n", err)
+		} else {
+			log.Printf("v_read() on %v bytes returned %v; contents of buffer: '%s'\n", bytesToRead, bytesRead, string(buf))
+		}
 
 	case "sd": // Shutsdown a socket
-		if len(tokens) < 2 {
+		if len(tokens) < 3 {
 			log.Println("usage: sd [socket] (read/write/both)")
 			goto done
 		}
@@ -294,30 +519,21 @@ func (d *Driver) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 			log.Println("socket is not valid")
 			goto done
 		}
-		mode, cmd := tokens[2], 0
-		if mode == "write" {
-			cmd = 1
-		} else if mode == "read" {
-			cmd = 2
-		} else if mode == "both" {
-			cmd = 3
-		} else {
-			log.Println("mode is not valid")
+		cmd, err := ParseShutdown(tokens[2])
+		if err != nil {
+			log.Println(err)
 			goto done
 		}
-		c := d.getConnSocket(sockID)
-		if c != nil {
-			err = c.Shutdown(cmd)
-			if err != nil {
-				log.Printf("v_shutdown() error: %v\n", err)
-				goto done
-			} else {
-				log.Printf("v_shutdown() returned 0\n")
-			}
-		} else {
-			log.Println("socket is not valid")
+		c, err := d.Socket(sockID)
+		if err != nil {
+			log.Println(err)
 			goto done
 		}
+		if err = c.Shutdown(cmd); err != nil {
+			log.Printf("v_shutdown() error: %v\n", err)
+		} else {
+			log.Printf("v_shutdown() returned 0\n")
+		}
 
 	case "cl": // Closes a socket
 		if len(tokens) < 2 {
@@ -329,49 +545,91 @@ func (d *Driver) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 			log.Println("socket is not valid")
 			goto done
 		}
-		c := d.getConnSocket(sockID)
-		if c != nil {
-			c.Close()
-		} else {
+		c, err := d.Socket(sockID)
+		if err != nil {
+			log.Println(err)
+			goto done
+		}
+		c.Close()
+
+	case "ab": // Aborts a socket, resetting the connection
+		if len(tokens) < 2 {
+			log.Println("usage: ab [socket]")
+			goto done
+		}
+		sockID, err := strconv.Atoi(tokens[1])
+		if err != nil {
 			log.Println("socket is not valid")
 			goto done
 		}
+		c, err := d.Socket(sockID)
+		if err != nil {
+			log.Println(err)
+			goto done
+		}
+		c.Abort()
+
+	case "window": // Lists window sizes for a socket
+		if len(tokens) < 2 {
+			log.Println("usage: window [socket]")
+			goto done
+		}
+		sockID, err := strconv.Atoi(tokens[1])
+		if err != nil {
+			log.Println("socket is not valid")
+			goto done
+		}
+		c, err := d.Socket(sockID)
+		if err != nil {
+			log.Println(err)
+			goto done
+		}
+		info := c.Info()
+		log.Printf("send window: %v\nreceive window: %v\ncwnd: %v\nssthresh: %v\n", info.SendWindow, info.ReceiveWindow, info.Cwnd, info.Ssthresh)
+		log.Printf("congestion control: %v (%v)\n", info.Congestion, info.CongestionState)
+		log.Printf("receive buffer: %v\nwindow scale: send %v, receive %v\n", info.ReceiveBuffer, info.SendScale, info.ReceiveScale)
+		log.Printf("mss: %v\ntimestamps: %v\nsack: %v\n", info.MSS, info.Timestamps, info.SACK)
+		if info.InRecovery {
+			log.Println("in fast recovery")
+		}
+
+	case "cc": // Shows or sets congestion control, for new connections or for a socket
+		switch len(tokens) {
+		case 1:
+			log.Printf("default: %v\navailable: %v\n", d.CongestionControl(), strings.Join(CongestionControls(), ", "))
+		case 2:
+			if err := d.SetCongestionControl(tokens[1]); err != nil {
+				log.Println(err)
+			}
+		default:
+			sockID, err := strconv.Atoi(tokens[1])
+			if err != nil {
+				log.Println("socket is not valid")
+				goto done
+			}
+			c, err := d.Socket(sockID)
+			if err != nil {
+				log.Println(err)
+				goto done
+			}
+			if err := c.SetCongestionControl(tokens[2]); err != nil {
+				log.Println(err)
+			}
+		}
 
 	case "sf": // Send file
 		if len(tokens) < 4 {
 			log.Println("usage: sf [filename] [ip] [port]")
 			goto done
 		}
-		file, err := os.Open(tokens[1])
-		if err != nil {
-			log.Printf("sf error: %v\n", err)
-		}
 		remoteAddr := net.ParseIP(tokens[2])
 		port, err := strconv.Atoi(tokens[3])
 		if err != nil {
 			log.Printf("sf error: %v\n", err)
 			goto done
 		}
-		log.Printf("STARTING SENDFILE: %v\n", time.Now())
-		c, err := d.Connect(d.node.GetOpenAddr(), d.nextPort, remoteAddr, uint16(port))
-		d.nextPort += 1
-		if err != nil {
+		if err := d.SendFile(tokens[1], remoteAddr, uint16(port)); err != nil {
 			log.Printf("sf error: %v\n", err)
-			file.Close()
-		} else {
-			go func() {
-				for {
-					buf := make([]byte, util.MAX_PACKET_SIZE)
-					bytesRead, err := file.Read(buf)
-					if bytesRead <= 0 || err == io.EOF {
-						file.Close()
-						c.Close()
-						log.Printf("FINISHED SENDFILE: %v\n", time.Now())
-						return
-					}
-					c.Write(buf[:bytesRead])
-				}
-			}()
 		}
 
 	case "rf": // Reads a file
@@ -379,44 +637,14 @@ func (d *Driver) HandleStdin(tokens []string, readyChan chan bool) (found bool,
 			log.Println("usage: rf [filename] [port]")
 			goto done
 		}
-		file, err := os.OpenFile(tokens[1], os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
-		if err != nil {
-			log.Printf("rf error: %v\n", err)
-		}
 		port, err := strconv.Atoi(tokens[2])
 		if err != nil {
-			file.Close()
 			log.Printf("rf error: %v\n", err)
 			goto done
 		}
-		listener, err := d.Listen(d.node.GetOpenAddr(), uint16(port))
-		if err != nil {
-			file.Close()
-			log.Println("could not create listener")
-			goto done
+		if err := d.ReceiveFile(tokens[1], uint16(port)); err != nil {
+			log.Printf("rf error: %v\n", err)
 		}
-		go func() {
-			sockID, err := listener.Accept()
-			listener.Close()
-			if err != nil {
-				log.Println("Accept() returned error:", err)
-				file.Close()
-				return
-			}
-			c := d.getConnSocket(sockID)
-			// Read data until the connection closes
-			for {
-				buf := make([]byte, util.MAX_FRAME_SIZE)
-				bytesRead, err := c.Read(buf, uint32(len(buf)), true)
-				file.Write(buf[:bytesRead])
-				if err == io.EOF {
-					break
-				}
-			}
-			log.Printf("FINISHED RECVFILE: %v\n", time.Now())
-			file.Close()
-			c.Close()
-		}()
 	case "q": // Quit
 		return true, true
 	default:
@@ -426,3 +654,164 @@ done:
 	readyChan <- true
 	return true, false
 }
+
+// SocketInfo describes an entry in the socket table. Listeners leave the connection statistics zero.
+type SocketInfo struct {
+	ID              int
+	LocalAddr       net.IP
+	LocalPort       uint16
+	RemoteAddr      net.IP
+	RemotePort      uint16
+	State           TCPState
+	Retransmits     uint64        // Segments sent again.
+	SRTT            time.Duration // Smoothed round-trip time.
+	RTO             time.Duration // Current retransmission timeout.
+	SendWindow      uint32        // Window last advertised by the peer.
+	ReceiveWindow   uint32        // Free space in our receive buffer.
+	Cwnd            uint32        // Congestion window.
+	Ssthresh        uint32        // Slow start threshold.
+	InRecovery      bool          // Whether the connection is in fast recovery.
+	Congestion      string        // Name of the congestion control algorithm.
+	CongestionState string        // The algorithm's own state, as key=value pairs.
+	ReceiveBuffer   uint32        // Size of our receive buffer, which autotuning grows.
+	SendScale       uint8         // Shift applied to windows the peer advertises.
+	ReceiveScale    uint8         // Shift applied to windows we advertise.
+	MSS             uint32        // Largest segment the peer accepts.
+	Timestamps      bool          // Whether both ends send timestamps.
+	SACK            bool          // Whether both ends send SACK blocks.
+}
+
+// Lists the open sockets. A connection is listed until it has been torn down and the application
+// has closed it.
+func (d *Driver) Sockets() []SocketInfo {
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	infos := make([]SocketInfo, 0)
+	for sk, s := range d.socketTable {
+		if s == nil {
+			continue
+		}
+		if s.conn != nil {
+			info := s.conn.Info()
+			info.ID = sk
+			infos = append(infos, info)
+			continue
+		}
+		infos = append(infos, SocketInfo{
+			ID:         sk,
+			LocalAddr:  s.listener.addr,
+			LocalPort:  s.listener.port,
+			RemoteAddr: util.Int2IP(0),
+			State:      S_LISTEN,
+		})
+	}
+	return infos
+}
+
+// Gets the connection with the given socket descriptor. Once the application has closed it, this
+// fails with ErrClosed until the connection is torn down and the descriptor is freed.
+func (d *Driver) Socket(sockID int) (*Conn, error) {
+	c := d.getConnSocket(sockID)
+	if c == nil {
+		return nil, errors.New("socket is not valid")
+	}
+	if c.handleClosed() {
+		return nil, ErrClosed
+	}
+	return c, nil
+}
+
+// Listens on a port and accepts connections in the background.
+func (d *Driver) Serve(port uint16) error {
+	listener, err := d.Listen(d.node.GetOpenAddr(), port)
+	if err != nil {
+		return err
+	}
+	d.spawn(func() {
+		for {
+			sockID, err := listener.Accept()
+			if err == ErrClosed {
+				return
+			}
+			if err != nil {
+				log.Println("Accept() returned error:", err)
+				continue
+			}
+			log.Printf("v_accept() on socket %v returned 1\n", sockID)
+		}
+	})
+	return nil
+}
+
+// Connects to the given address and sends the whole file in the background, then closes the
+// connection.
+func (d *Driver) SendFile(filename string, remoteAddr net.IP, port uint16) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	log.Printf("STARTING SENDFILE: %v\n", time.Now())
+	c, err := d.Connect(d.node.GetOpenAddr(), d.EphemeralPort(), remoteAddr, port)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	d.spawn(func() {
+		defer file.Close()
+		for {
+			buf := make([]byte, util.MAX_FRAME_SIZE)
+			bytesRead, err := file.Read(buf)
+			if bytesRead <= 0 || err == io.EOF {
+				c.Close()
+				log.Printf("FINISHED SENDFILE: %v\n", time.Now())
+				return
+			}
+			if _, err := c.Write(buf[:bytesRead]); err != nil {
+				log.Printf("sf error: %v\n", err)
+				return
+			}
+		}
+	})
+	return nil
+}
+
+// Listens on a port and, in the background, writes everything sent by the first connection to
+// the file until it closes.
+func (d *Driver) ReceiveFile(filename string, port uint16) error {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	listener, err := d.Listen(d.node.GetOpenAddr(), port)
+	if err != nil {
+		file.Close()
+		return errors.New("could not create listener")
+	}
+	d.spawn(func() {
+		c, err := listener.AcceptConn()
+		listener.Close()
+		if err != nil {
+			log.Println("Accept() returned error:", err)
+			file.Close()
+			return
+		}
+		// Read data until the connection closes
+		for {
+			buf := make([]byte, util.MAX_FRAME_SIZE)
+			bytesRead, err := c.Read(buf, uint32(len(buf)), true)
+			file.Write(buf[:bytesRead])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				log.Printf("rf error: %v\n", err)
+				file.Close()
+				return
+			}
+		}
+		log.Printf("FINISHED RECVFILE: %v\n", time.Now())
+		file.Close()
+		c.Close()
+	})
+	return nil
+}
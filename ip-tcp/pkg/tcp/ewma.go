@@ -1,36 +1,90 @@
 package tcp
 
 import (
-	"math"
+	"sync"
 	"time"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
 )
 
-// A smoothed round-trip time struct.
+// A round-trip time estimator that computes the retransmission timeout as in RFC 6298: a smoothed
+// mean and a smoothed mean deviation of the samples.
 type SRTT struct {
-	alpha  float64
-	beta   float64
-	srtt   float64
-	minRtt float64
-	maxRtt float64
-}
-
-// Recommended: alpha = [0.8, 0.9], beta = [1.3, 2.0]
-func NewSRTT(initialGuess, alpha, beta, minRtt, maxRtt float64) *SRTT {
-	return &SRTT{
-		alpha:  alpha,
-		beta:   beta,
-		srtt:   initialGuess,
-		minRtt: minRtt,
-		maxRtt: maxRtt,
+	mtx     sync.Mutex
+	srtt    time.Duration // Smoothed round-trip time, or zero before the first sample.
+	rttvar  time.Duration // Round-trip time variation.
+	rto     time.Duration
+	minRTO  time.Duration
+	maxRTO  time.Duration
+	samples uint64
+}
+
+// Creates an estimator whose timeout starts at initialRTO and stays within [minRTO, maxRTO].
+func NewSRTT(initialRTO, minRTO, maxRTO time.Duration) *SRTT {
+	return &SRTT{rto: initialRTO, minRTO: minRTO, maxRTO: maxRTO}
+}
+
+// Adds a measured rtt. Samples must not come from retransmitted segments (Karn's rule).
+func (srtt *SRTT) AddPoint(rtt time.Duration) {
+	srtt.mtx.Lock()
+	defer srtt.mtx.Unlock()
+	if srtt.samples == 0 {
+		srtt.srtt, srtt.rttvar = rtt, rtt/2
+	} else {
+		delta := srtt.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		srtt.rttvar = time.Duration((1-util.RTT_BETA)*float64(srtt.rttvar) + util.RTT_BETA*float64(delta))
+		srtt.srtt = time.Duration((1-util.RTT_ALPHA)*float64(srtt.srtt) + util.RTT_ALPHA*float64(rtt))
+	}
+	srtt.samples++
+	variation := util.RTT_K * srtt.rttvar
+	if variation < util.TCP_CLOCK_GRANULARITY {
+		variation = util.TCP_CLOCK_GRANULARITY
 	}
+	srtt.rto = srtt.clamp(srtt.srtt + variation)
 }
 
-// Adds a measured rtt.
-func (srtt *SRTT) AddPoint(rtt float64) {
-	srtt.srtt = srtt.alpha*srtt.srtt + (1.0-srtt.alpha)*rtt
+// Keeps a timeout within the allowed range.
+func (srtt *SRTT) clamp(rto time.Duration) time.Duration {
+	if rto < srtt.minRTO {
+		return srtt.minRTO
+	}
+	if rto > srtt.maxRTO {
+		return srtt.maxRTO
+	}
+	return rto
+}
+
+// Gets the smoothed round-trip time.
+func (srtt *SRTT) GetSRTT() time.Duration {
+	srtt.mtx.Lock()
+	defer srtt.mtx.Unlock()
+	return srtt.srtt
+}
+
+// Gets the round-trip time variation.
+func (srtt *SRTT) GetRTTVar() time.Duration {
+	srtt.mtx.Lock()
+	defer srtt.mtx.Unlock()
+	return srtt.rttvar
 }
 
 // Calculates what the rto should be.
 func (srtt *SRTT) GetRTO() time.Duration {
-	return time.Duration(math.Max(srtt.minRtt, math.Min(srtt.maxRtt, 3.0*srtt.beta*srtt.srtt)))
+	srtt.mtx.Lock()
+	defer srtt.mtx.Unlock()
+	return srtt.rto
+}
+
+// Calculates the rto after backing off for the given number of consecutive timeouts.
+func (srtt *SRTT) GetBackedOffRTO(backoff uint32) time.Duration {
+	srtt.mtx.Lock()
+	defer srtt.mtx.Unlock()
+	rto := srtt.rto
+	for i := uint32(0); i < backoff && rto < srtt.maxRTO; i++ {
+		rto *= 2
+	}
+	return srtt.clamp(rto)
 }
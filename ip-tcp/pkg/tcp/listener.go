@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"context"
 	"errors"
 	"math/rand"
 	"net"
@@ -12,10 +13,12 @@ import (
 
 // Struct to denote a particular listener socket.
 type Listener struct {
+	sockId     int // Identifies this socket.
 	driver     *Driver
 	mailbox    chan *TCPPacket
 	readyConns chan *Conn
-	closeChan  chan bool
+	ctx        context.Context // Cancelled by Close, or when the driver is closed.
+	cancel     context.CancelFunc
 
 	addr net.IP
 	port uint16
@@ -34,26 +37,67 @@ func (d *Driver) Listen(addr net.IP, port uint16) (*Listener, error) {
 		driver:     d,
 		mailbox:    make(chan *TCPPacket),
 		readyConns: make(chan *Conn),
-		closeChan:  make(chan bool),
 		addr:       addr,
 		port:       port,
 	}
+	l.ctx, l.cancel = context.WithCancel(d.ctx)
+	if d.ctx.Err() != nil {
+		l.cancel()
+		return nil, ErrClosed
+	}
 	d.bindListener(id, l)
-	d.createSocket(id)
-	go l.receiveThread()
+	d.createSocket(&socket{listener: l})
+	d.spawn(l.receiveThread)
 	return l, nil
 }
 
 // Grab a new connection and finish the three-way handshake.
 func (l *Listener) Accept() (int, error) {
-	c := <-l.readyConns
-	sockID := l.driver.createSocket(c.getID())
-	return sockID, nil
+	c, err := l.AcceptConn()
+	if err != nil {
+		return -1, err
+	}
+	return c.sockId, nil
+}
+
+// Grab a new connection, returning the connection itself rather than its socket.
+func (l *Listener) AcceptConn() (*Conn, error) {
+	return l.AcceptContext(context.Background())
+}
+
+// Grab a new connection, giving up with ctx.Err() if ctx is done first.
+func (l *Listener) AcceptContext(ctx context.Context) (*Conn, error) {
+	var c *Conn
+	select {
+	case c = <-l.readyConns:
+	case <-l.ctx.Done():
+		return nil, ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	l.driver.createSocket(&socket{conn: c})
+	return c, nil
 }
 
-// Close this listener.
+// Grab a connection that is ready to be accepted, without waiting. Fails with ErrWouldBlock if
+// there is none.
+func (l *Listener) TryAccept() (*Conn, error) {
+	var c *Conn
+	select {
+	case c = <-l.readyConns:
+	default:
+		if l.ctx.Err() != nil {
+			return nil, ErrClosed
+		}
+		return nil, ErrWouldBlock
+	}
+	l.driver.createSocket(&socket{conn: c})
+	return c, nil
+}
+
+// Close this listener. Connections it hasn't handed out yet are aborted.
 func (l *Listener) Close() error {
-	l.closeChan <- true
+	l.cancel()
 	return nil
 }
 
@@ -61,15 +105,22 @@ func (l *Listener) Close() error {
 func (l *Listener) receiveThread() {
 	for {
 		select {
-		case <-l.closeChan:
-			l.driver.mtx.Lock()
-			delete(l.driver.listTable, l.getListID())
-			l.driver.mtx.Unlock()
+		case <-l.ctx.Done():
+			l.driver.unbindListener(l)
 			return
 		case pkt := <-l.mailbox:
+			if pkt.isRst() {
+				continue
+			}
+			if pkt.isAck() {
+				// Nothing here could have been acked (RFC 793 section 3.9, LISTEN).
+				l.driver.sendReset(pkt)
+				continue
+			}
 			if pkt.isSyn() {
 				initialSeqNum := rand.Uint32()
 				c := &Conn{
+					sockId:        -1, // Until the connection is accepted.
 					localAddr:     pkt.destAddr,
 					localPort:     pkt.destPort,
 					remoteAddr:    pkt.srcAddr,
@@ -78,6 +129,7 @@ func (l *Listener) receiveThread() {
 					driver:        l.driver,
 					mailbox:       make(chan *TCPPacket),
 					readyConns:    l.readyConns, // when connecting through a listener, should populate.
+					acceptCtx:     l.ctx,
 					sendBuffer:    make(chan *TCPPacket),
 					sentBuffer:    make([]*Retransmitter, 0),
 					seqNum:        atomic.NewUint32(initialSeqNum),
@@ -86,15 +138,25 @@ func (l *Listener) receiveThread() {
 					numDupeAcks:   atomic.NewUint32(0),
 					canRead:       *atomic.NewBool(true),
 					canWrite:      *atomic.NewBool(false),
-					srtt:          NewSRTT(util.SRTT_INITIAL_GUESS, util.SRTT_ALPHA, util.SRTT_BETA, util.SRTT_MIN, util.SRTT_MAX),
+					srtt:          NewSRTT(util.TCP_RTO_INITIAL, util.TCP_RTO_MIN, util.TCP_RTO_MAX),
+					cc:            newCongestion(l.driver.newCongestionControl(), l.driver.mss, initialSeqNum),
+					ackSignal:     make(chan struct{}, 1),
+					rtoWake:       make(chan struct{}, 1),
+					estSignal:     make(chan struct{}, 1),
+					closed:        make(chan struct{}),
 				}
+				c.sndNxt.Store(initialSeqNum + 1)
+				c.mss.Store(l.driver.mss)
+				c.tsOffset = rand.Uint32()
 				c.writeCond = sync.NewCond(&c.writeMtx)
+				c.readDeadline = newDeadline(nil)
+				c.writeDeadline = newDeadline(c.wakeWriters)
+				c.ctx, c.cancel = context.WithCancel(l.driver.ctx)
 				cID := ConnID{util.IP2int(pkt.destAddr), pkt.destPort, util.IP2int(pkt.srcAddr), pkt.srcPort}
+				c.log = tcpLog.With("conn", cID)
 				l.driver.bindConnection(cID, c)
 				// Start connection utilities.
-				go c.sendThread()
-				go c.receiveThread()
-				go c.retransmitThread()
+				c.start()
 				// Handle SYN packet
 				c.StateMachine(pkt)
 			}
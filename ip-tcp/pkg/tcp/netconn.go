@@ -0,0 +1,114 @@
+package tcp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"time"
+)
+
+// NetConn adapts a connection to net.Conn, so the standard library can run over the virtual
+// network. Its addresses are the connection's virtual ones.
+type NetConn struct {
+	c *Conn
+}
+
+// Wraps a connection as a net.Conn.
+func NewNetConn(c *Conn) *NetConn {
+	return &NetConn{c: c}
+}
+
+// Gets the wrapped connection.
+func (nc *NetConn) Conn() *Conn {
+	return nc.c
+}
+
+// Reads whatever is ready, up to len(b) bytes, waiting until something is.
+func (nc *NetConn) Read(b []byte) (int, error) {
+	n, err := nc.c.Read(b, uint32(len(b)), false)
+	return int(n), err
+}
+
+// Writes all of b, unless the connection fails or the write deadline passes first.
+func (nc *NetConn) Write(b []byte) (int, error) {
+	n, err := nc.c.Write(b)
+	return int(n), err
+}
+
+// Closes the connection.
+func (nc *NetConn) Close() error {
+	return nc.c.Close()
+}
+
+func (nc *NetConn) LocalAddr() net.Addr {
+	ip, port := nc.c.LocalVIP()
+	return &net.TCPAddr{IP: ip, Port: int(port)}
+}
+
+func (nc *NetConn) RemoteAddr() net.Addr {
+	ip, port := nc.c.RemoteVIP()
+	return &net.TCPAddr{IP: ip, Port: int(port)}
+}
+
+func (nc *NetConn) SetDeadline(t time.Time) error {
+	return nc.c.SetDeadline(t)
+}
+
+func (nc *NetConn) SetReadDeadline(t time.Time) error {
+	return nc.c.SetReadDeadline(t)
+}
+
+func (nc *NetConn) SetWriteDeadline(t time.Time) error {
+	return nc.c.SetWriteDeadline(t)
+}
+
+// NetListener adapts a listener to net.Listener.
+type NetListener struct {
+	l *Listener
+}
+
+// Wraps a listener as a net.Listener.
+func NewNetListener(l *Listener) *NetListener {
+	return &NetListener{l: l}
+}
+
+// Waits for the next connection. Fails with ErrClosed once the listener is closed.
+func (nl *NetListener) Accept() (net.Conn, error) {
+	c, err := nl.l.AcceptConn()
+	if err != nil {
+		return nil, err
+	}
+	return NewNetConn(c), nil
+}
+
+// Closes the listener.
+func (nl *NetListener) Close() error {
+	return nl.l.Close()
+}
+
+func (nl *NetListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: nl.l.addr, Port: int(nl.l.port)}
+}
+
+// Connects to a virtual address given as "vip:port", from our first enabled interface and an
+// ephemeral port. Gives up on the handshake if ctx is done first.
+func (d *Driver) Dial(ctx context.Context, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	remoteAddr := net.ParseIP(host)
+	if remoteAddr == nil || remoteAddr.To4() == nil {
+		return nil, errors.New("address is not a virtual IP")
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, errors.New("port is not valid")
+	}
+	c, err := d.ConnectContext(ctx, d.node.GetOpenAddr(), d.EphemeralPort(), remoteAddr.To4(), uint16(port))
+	if err != nil {
+		return nil, err
+	}
+	return NewNetConn(c), nil
+}
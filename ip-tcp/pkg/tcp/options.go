@@ -0,0 +1,128 @@
+package tcp
+
+import (
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// TCP option kinds.
+const (
+	OPT_END       = 0
+	OPT_NOP       = 1
+	OPT_MSS       = 2
+	OPT_WSCALE    = 3
+	OPT_SACK_PERM = 4
+	OPT_SACK      = 5
+	OPT_TIMESTAMP = 8
+)
+
+// Lengths of options, including their kind and length bytes.
+const (
+	OPT_MSS_LEN       = 4
+	OPT_WSCALE_LEN    = 3
+	OPT_SACK_PERM_LEN = 2
+	OPT_SACK_LEN      = 2 // Plus 8 bytes per block.
+	OPT_TIMESTAMP_LEN = 10
+	OPT_MAX_LEN       = 40 // Most options a segment can carry.
+)
+
+// SACKBlock is a range of sequence numbers the receiver holds beyond its ack, from Left up to but
+// not including Right.
+type SACKBlock struct {
+	Left  uint32
+	Right uint32
+}
+
+// TCPOptions are the options in a segment that we understand. Others are skipped when parsing.
+type TCPOptions struct {
+	MSS           uint16      // Largest segment the sender accepts, or 0 if not given. Only sent on SYNs.
+	WindowScaling bool        // Whether the segment has a window scale option (RFC 7323). Only sent on SYNs.
+	WindowScale   uint8       // How far the sender will shift the windows it advertises.
+	Timestamps    bool        // Whether the segment has a timestamp option (RFC 7323).
+	TSVal         uint32      // Sender's timestamp clock.
+	TSEcr         uint32      // Most recent timestamp received from us.
+	SACKPermitted bool        // Whether the sender can take SACK blocks (RFC 2018). Only sent on SYNs.
+	SACKBlocks    []SACKBlock // Data received beyond the ack, most recent first.
+}
+
+// Gets how many SACK blocks fit alongside the other options.
+func (opts *TCPOptions) maxSACKBlocks() int {
+	room := OPT_MAX_LEN - len(opts.Serialize()) - 2 - OPT_SACK_LEN
+	if room < 0 {
+		return 0
+	}
+	return room / 8
+}
+
+// Serializes options, padded to a multiple of 4 bytes.
+func (opts *TCPOptions) Serialize() []byte {
+	buf := make([]byte, 0)
+	if opts.MSS != 0 {
+		buf = append(buf, OPT_MSS, OPT_MSS_LEN)
+		buf = append(buf, util.Htons(opts.MSS)...)
+	}
+	if opts.WindowScaling {
+		buf = append(buf, OPT_NOP, OPT_WSCALE, OPT_WSCALE_LEN, opts.WindowScale)
+	}
+	if opts.Timestamps {
+		// Aligned as RFC 7323 Appendix A suggests.
+		buf = append(buf, OPT_NOP, OPT_NOP, OPT_TIMESTAMP, OPT_TIMESTAMP_LEN)
+		buf = append(buf, util.Htonl(opts.TSVal)...)
+		buf = append(buf, util.Htonl(opts.TSEcr)...)
+	}
+	if opts.SACKPermitted {
+		buf = append(buf, OPT_NOP, OPT_NOP, OPT_SACK_PERM, OPT_SACK_PERM_LEN)
+	}
+	if len(opts.SACKBlocks) > 0 {
+		buf = append(buf, OPT_NOP, OPT_NOP, OPT_SACK, byte(OPT_SACK_LEN+8*len(opts.SACKBlocks)))
+		for _, block := range opts.SACKBlocks {
+			buf = append(buf, util.Htonl(block.Left)...)
+			buf = append(buf, util.Htonl(block.Right)...)
+		}
+	}
+	for len(buf)%4 != 0 {
+		buf = append(buf, OPT_END)
+	}
+	return buf
+}
+
+// Parses options. Unknown options are skipped, known ones with the wrong length are ignored, and
+// parsing stops at the end of the list or at anything malformed, keeping what was read so far.
+func (opts *TCPOptions) Deserialize(buf []byte) {
+	*opts = TCPOptions{}
+	for i := 0; i < len(buf); {
+		kind := buf[i]
+		if kind == OPT_END {
+			return
+		}
+		if kind == OPT_NOP {
+			i++
+			continue
+		}
+		if i+1 >= len(buf) {
+			return
+		}
+		length := int(buf[i+1])
+		if length < 2 || i+length > len(buf) {
+			return
+		}
+		body := buf[i+2 : i+length]
+		switch {
+		case kind == OPT_MSS && length == OPT_MSS_LEN:
+			opts.MSS = util.Ntohs(body)
+		case kind == OPT_WSCALE && length == OPT_WSCALE_LEN:
+			opts.WindowScaling = true
+			opts.WindowScale = body[0]
+		case kind == OPT_TIMESTAMP && length == OPT_TIMESTAMP_LEN:
+			opts.Timestamps = true
+			opts.TSVal = util.Ntohl(body[0:4])
+			opts.TSEcr = util.Ntohl(body[4:8])
+		case kind == OPT_SACK_PERM && length == OPT_SACK_PERM_LEN:
+			opts.SACKPermitted = true
+		case kind == OPT_SACK && length > OPT_SACK_LEN && (length-OPT_SACK_LEN)%8 == 0:
+			for j := 0; j < len(body); j += 8 {
+				opts.SACKBlocks = append(opts.SACKBlocks, SACKBlock{util.Ntohl(body[j : j+4]), util.Ntohl(body[j+4 : j+8])})
+			}
+		}
+		i += length
+	}
+}
@@ -1,6 +1,7 @@
 package tcp
 
 import (
+	"errors"
 	"net"
 
 	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
@@ -33,32 +34,24 @@ type TCPPacket struct {
 	checksum uint16
 	urgent   uint16
 	options  []byte
+	opts     TCPOptions // Parsed options.
 	data     []byte
 }
 
-func (c *Conn) NewTCPPacket(srcAddr net.IP, destAddr net.IP, data []byte, options []byte, flags uint16, seqNum uint32) *TCPPacket {
-	ackNum, winSize := uint32(0), uint16(0)
-	if c.receiveBuffer != nil {
-		ackNum = c.receiveBuffer.GetAckNum(true)
-		winSize = uint16(c.receiveBuffer.GetWindowSize(true))
-	}
-
+// Creates a segment. Its ack, window, options and checksum are filled in when it's sent.
+func (c *Conn) NewTCPPacket(srcAddr net.IP, destAddr net.IP, data []byte, flags uint16, seqNum uint32) *TCPPacket {
 	packet := &TCPPacket{
 		srcPort:  c.localPort,
 		srcAddr:  srcAddr,
 		destPort: c.remotePort,
 		destAddr: destAddr,
 		seqNum:   seqNum, // Current data sequence
-		ackNum:   ackNum, // Index of next byte we're expecting
-		offset:   5 + uint8(len(options)/4),
+		offset:   5,
 		flags:    flags,
-		winSize:  winSize,
 		checksum: 0,
 		urgent:   0,
-		options:  options,
 		data:     data,
 	}
-	packet.checksum = TCPChecksum(packet)
 	return packet
 }
 
@@ -78,7 +71,10 @@ func (packet *TCPPacket) Serialize() []byte {
 	return buf
 }
 
-func (packet *TCPPacket) Deserialize(buf []byte) {
+func (packet *TCPPacket) Deserialize(buf []byte) error {
+	if len(buf) < util.MIN_PACKET_SIZE {
+		return errors.New("tcp segment too short")
+	}
 	packet.srcPort = util.Ntohs(buf[0:2])
 	packet.destPort = util.Ntohs(buf[2:4])
 	packet.seqNum = util.Ntohl(buf[4:8])
@@ -88,8 +84,21 @@ func (packet *TCPPacket) Deserialize(buf []byte) {
 	packet.winSize = util.Ntohs(buf[14:16])
 	packet.checksum = util.Ntohs(buf[16:18])
 	packet.urgent = util.Ntohs(buf[18:20])
-	packet.options = buf[20 : packet.offset*4]
-	packet.data = buf[packet.offset*4:]
+	headerLen := int(packet.offset) * 4
+	if headerLen < util.MIN_PACKET_SIZE || headerLen > len(buf) {
+		return errors.New("bad tcp data offset")
+	}
+	packet.options = buf[20:headerLen]
+	packet.opts.Deserialize(packet.options)
+	packet.data = buf[headerLen:]
+	return nil
+}
+
+// Sets the options, adjusting the data offset.
+func (packet *TCPPacket) setOptions(opts TCPOptions) {
+	packet.opts = opts
+	packet.options = opts.Serialize()
+	packet.offset = 5 + uint8(len(packet.options)/4)
 }
 
 // Compute the TCP Checksum with pseudoheader.
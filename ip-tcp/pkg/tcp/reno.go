@@ -0,0 +1,64 @@
+package tcp
+
+import (
+	"fmt"
+	"time"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Reno grows the window by a segment per round trip and halves it on loss (RFC 5681). With the
+// connection's fast recovery, this is NewReno.
+type Reno struct {
+	mss      uint32
+	cwnd     uint32
+	ssthresh uint32
+}
+
+// Creates Reno congestion control.
+func NewReno(mss uint32) CongestionControl {
+	return &Reno{mss: mss, cwnd: initialWindow(mss), ssthresh: util.TCP_MAX_CWND}
+}
+
+func (r *Reno) Name() string {
+	return "reno"
+}
+
+func (r *Reno) Window() uint32 {
+	return r.cwnd
+}
+
+func (r *Reno) Ssthresh() uint32 {
+	return r.ssthresh
+}
+
+func (r *Reno) OnAck(acked uint32, flight uint32) {
+	if r.cwnd < r.ssthresh {
+		// Slow start.
+		r.cwnd += util.Min(acked, r.mss)
+	} else {
+		// Congestion avoidance: about one segment per round trip.
+		r.cwnd += util.Max(1, r.mss*r.mss/r.cwnd)
+	}
+	r.cwnd = util.Min(r.cwnd, util.TCP_MAX_CWND)
+}
+
+func (r *Reno) OnLoss(loss LossType, flight uint32) {
+	r.ssthresh = util.Max(flight/2, 2*r.mss)
+	if loss == LOSS_TIMEOUT {
+		r.cwnd = r.mss
+	} else {
+		r.cwnd = r.ssthresh
+	}
+}
+
+func (r *Reno) OnRTTSample(rtt time.Duration) {}
+
+func (r *Reno) OnIdleRestart() {
+	// Restart from no more than the initial window (RFC 5681 section 4.1).
+	r.cwnd = util.Min(r.cwnd, initialWindow(r.mss))
+}
+
+func (r *Reno) State() string {
+	return fmt.Sprintf("cwnd=%v ssthresh=%v", r.cwnd, r.ssthresh)
+}
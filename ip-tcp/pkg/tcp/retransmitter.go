@@ -3,7 +3,6 @@ package tcp
 import (
 	"time"
 
-	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
 	"go.uber.org/atomic"
 )
 
@@ -15,11 +14,14 @@ type Retransmitter struct {
 	retried     atomic.Uint32
 	sent        time.Time
 	acked       bool
+	sacked      bool // Whether the peer has SACKed the segment.
+	lost        bool // Whether the scoreboard considers the segment lost.
+	rexmitted   bool // Whether the segment was retransmitted since it was considered lost.
 }
 
 // Retransmits a packet immediately
 func (rt *Retransmitter) execute() {
-	rt.c.driver.node.Send(6, rt.pkt.Serialize(), util.DEFAULT_TTL, rt.pkt.srcAddr, rt.pkt.destAddr)
+	rt.c.send(rt.pkt)
 	rt.sent = time.Now()
 }
 
@@ -0,0 +1,119 @@
+package tcp
+
+import (
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// The sender's SACK scoreboard (RFC 6675) lives in the sent buffer: each segment records whether
+// the peer SACKed it, whether we consider it lost, and whether it was retransmitted since. It is
+// guarded by stbMtx like the rest of the buffer.
+
+// Marks the segments the SACK blocks of an ack for ackNum cover, and the segments below them that
+// are now considered lost. Returns true if the segment at ackNum is lost. stbMtx held on entry.
+func (c *Conn) updateScoreboard(blocks []SACKBlock, ackNum uint32, sndNxt uint32) bool {
+	for _, block := range blocks {
+		// Ignore blocks for data that isn't outstanding, such as D-SACKs (RFC 2883).
+		if !seqLess(block.Left, block.Right) || !seqLess(ackNum, block.Left) || seqLess(sndNxt, block.Right) {
+			continue
+		}
+		for _, rt := range c.sentBuffer {
+			if !rt.acked && rt.len > 0 && !seqLess(rt.firstSeqNum, block.Left) && !seqLess(block.Right, rt.firstSeqNum+rt.len) {
+				rt.sacked = true
+			}
+		}
+	}
+	// A segment is lost once TCP_DUP_THRESH segments above it, or more than TCP_DUP_THRESH - 1
+	// segments' worth of bytes, have been SACKed (IsLost).
+	sackedSegs, sackedBytes, mss := uint32(0), uint32(0), c.segmentSize()
+	holeLost := false
+	for i := len(c.sentBuffer) - 1; i >= 0; i-- {
+		rt := c.sentBuffer[i]
+		if rt.acked {
+			break
+		}
+		if rt.sacked {
+			sackedSegs++
+			sackedBytes += rt.len
+			continue
+		}
+		if sackedSegs >= util.TCP_DUP_THRESH || sackedBytes > (util.TCP_DUP_THRESH-1)*mss {
+			rt.lost = true
+		}
+		if rt.firstSeqNum == ackNum {
+			holeLost = rt.lost
+		}
+	}
+	return holeLost
+}
+
+// Marks the unacked segment at seq lost, unless it was already retransmitted. stbMtx held on entry.
+func (c *Conn) markLost(seq uint32) {
+	for _, rt := range c.sentBuffer {
+		if rt.firstSeqNum == seq && !rt.acked && !rt.sacked {
+			rt.lost = true
+			return
+		}
+	}
+}
+
+// Marks every segment not yet acked or SACKed lost, to be retransmitted again after a timeout.
+// We keep what was SACKed: our receiver never throws away data it has SACKed. stbMtx held on entry.
+func (c *Conn) markAllLost() {
+	for _, rt := range c.sentBuffer {
+		if !rt.acked && !rt.sacked {
+			rt.lost, rt.rexmitted = true, false
+		}
+	}
+}
+
+// Gets how many bytes are in the network: those not acked, SACKed or lost, plus retransmissions
+// (pipe). stbMtx held on entry.
+func (c *Conn) pipe() uint32 {
+	pipe := uint32(0)
+	for _, rt := range c.sentBuffer {
+		if rt.acked || rt.sacked {
+			continue
+		}
+		if !rt.lost {
+			pipe += rt.len
+		}
+		if rt.rexmitted {
+			pipe += rt.len
+		}
+	}
+	return pipe
+}
+
+// Gets the first segment that is lost and not yet retransmitted, or nil if there is none.
+// stbMtx held on entry.
+func (c *Conn) nextLost() *Retransmitter {
+	for _, rt := range c.sentBuffer {
+		if !rt.acked && !rt.sacked && rt.lost && !rt.rexmitted {
+			return rt
+		}
+	}
+	return nil
+}
+
+// Retransmits lost segments while the congestion window has room for them (NextSeg rule 1). Does
+// nothing without SACK.
+func (c *Conn) retransmitLost() {
+	if !c.sackEnabled.Load() {
+		return
+	}
+	cwnd := c.cc.window()
+	c.stbMtx.Lock()
+	defer c.stbMtx.Unlock()
+	pipe := c.pipe()
+	for _, rt := range c.sentBuffer {
+		if rt.acked || rt.sacked || !rt.lost || rt.rexmitted {
+			continue
+		}
+		if pipe > 0 && pipe+rt.len > cwnd {
+			return
+		}
+		c.log.Debug("retransmitting lost segment", "seq", rt.firstSeqNum, "len", rt.len, "pipe", pipe, "cwnd", cwnd)
+		c.retransmit(rt)
+		pipe += rt.len
+	}
+}
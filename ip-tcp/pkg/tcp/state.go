@@ -1,7 +1,6 @@
 package tcp
 
 import (
-	"log"
 	"time"
 
 	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
@@ -29,39 +28,80 @@ func (c *Conn) StateMachine(packet *TCPPacket) {
 	// ...if packet is acking something between what we last heard them ack and the last packet we sent...
 	// weOF, theyOF := currAckNum-uint32(packet.winSize) > currAckNum, packet.ackNum-uint32(packet.winSize) > packet.ackNum
 	// if currAckNum == 0 || theyOF && !weOF || !xor(theyOF, weOF) && currAckNum < packet.ackNum {
+	advanced, lastWinSize := false, c.remoteWinSize.Load()
 	if currAckNum == 0 || (currAckNum < packet.ackNum && packet.ackNum <= seqNum) || (seqNum < currAckNum && !(seqNum < packet.ackNum && packet.ackNum <= currAckNum)) {
+		advanced = currAckNum != 0 && packet.ackNum != currAckNum
 		c.remoteAckNum.Store(packet.ackNum)
-		c.remoteWinSize.Store(uint32(packet.winSize))
+		c.remoteWinSize.Store(c.peerWindow(packet))
 	}
-	// Stop Retransmitters that have been acked
+	// Stop Retransmitters that have been acked, taking an RTT sample from the latest one that
+	// wasn't retransmitted (Karn's rule).
+	var rtt time.Duration
+	sndNxt, holeLost := c.sndNxt.Load(), false
 	c.stbMtx.Lock()
 	for _, rt := range c.sentBuffer {
 		if rt.firstSeqNum+rt.len <= packet.ackNum && !rt.acked {
 			rt.ack()
 			if rt.retried.Load() == 0 {
-				c.srtt.AddPoint(float64(time.Since(rt.sent).Nanoseconds()))
+				rtt = time.Since(rt.sent)
 			}
 		}
 	}
+	c.dropAcked()
+	if c.sackEnabled.Load() {
+		holeLost = c.updateScoreboard(packet.opts.SACKBlocks, packet.ackNum, sndNxt)
+	}
+	if advanced {
+		c.resetRetransmitTimer()
+	}
 	c.stbMtx.Unlock()
-	// Check for duplicate acks.
-	if packet.ackNum == currAckNum {
-		c.numDupeAcks.Add(1)
-		if packet.winSize > uint16(c.remoteWinSize.Load()) {
-			c.remoteWinSize.Store(uint32(packet.winSize))
-		}
+	// If everything acked had been retransmitted, the echoed timestamp still says which
+	// transmission is being acked.
+	if rtt == 0 && advanced && c.tsEnabled.Load() && packet.opts.Timestamps && packet.opts.TSEcr != 0 {
+		rtt = c.timestampRTT(packet.opts.TSEcr)
 	}
-	// Fast retransmit if needed.
-	if c.numDupeAcks.Load() == 3 {
-		c.numDupeAcks.Add(1)
-		c.stbMtx.Lock()
-		for _, rt := range c.sentBuffer {
-			if packet.ackNum == rt.firstSeqNum {
-				rt.execute()
-				break
+	if rtt > 0 {
+		c.addRTTSample(rtt)
+	}
+	// Grow the congestion window on new acks, and count duplicates: acks carrying nothing new while
+	// data is outstanding. The third duplicate triggers a fast retransmit. With SACK, so does the
+	// scoreboard finding the first unacked segment lost, and it decides what else to resend.
+	if advanced {
+		c.numDupeAcks.Store(0)
+		if c.cc.onAck(packet.ackNum, packet.ackNum-currAckNum, sndNxt-packet.ackNum) {
+			if c.sackEnabled.Load() {
+				c.stbMtx.Lock()
+				c.markLost(packet.ackNum)
+				c.stbMtx.Unlock()
+			} else {
+				c.log.Debug("partial ack, retransmitting", "seq", packet.ackNum)
+				c.retransmitAt(packet.ackNum)
+			}
+		}
+		c.retransmitLost()
+		c.signalAck()
+	} else if packet.ackNum == currAckNum {
+		isDupe := len(packet.data) == 0 && !packet.isSyn() && !packet.isFin() &&
+			c.peerWindow(packet) == lastWinSize && seqLess(packet.ackNum, sndNxt)
+		if window := c.peerWindow(packet); window > c.remoteWinSize.Load() {
+			c.remoteWinSize.Store(window)
+		}
+		if packet.winSize == 0 {
+			// The peer is answering our zero window probes, so keep probing however long it takes
+			// (RFC 1122 section 4.2.2.17) rather than timing out.
+			c.stbMtx.Lock()
+			c.backoff = 0
+			c.stbMtx.Unlock()
+		}
+		if isDupe {
+			n := c.numDupeAcks.Inc()
+			if c.cc.onDupAck(n, packet.ackNum, sndNxt-packet.ackNum, sndNxt, holeLost) {
+				c.log.Debug("fast retransmit", "seq", packet.ackNum)
+				c.retransmitAt(packet.ackNum)
 			}
+			c.retransmitLost()
+			c.signalAck()
 		}
-		c.stbMtx.Unlock()
 	}
 	// Handle state transitions
 	c.stMtx.Lock()
@@ -87,24 +127,45 @@ func (c *Conn) StateMachine(packet *TCPPacket) {
 		c.handleLastAck(packet)
 	case S_TIME_WAIT:
 		c.handleTimeWait(packet)
+	case S_CLOSED:
+		// Nothing is listening any more (RFC 793 section 3.9, CLOSED).
+		c.driver.sendReset(packet)
+	}
+}
+
+// Moves the connection to a new state. stMtx held on entry.
+func (c *Conn) setState(state TCPState) {
+	c.log.Debug("state changed", "from", c.state, "to", state)
+	c.state = state
+	if state == S_CLOSED {
+		// Stops our threads, which tear the connection down.
+		c.cancel()
 	}
 }
 
 func (c *Conn) handleListen(packet *TCPPacket) error {
 	if packet.isSyn() {
 		// Set ack number, set state, and send syn+ack.
-		c.receiveBuffer = NewCircBuff(uint32(util.TCP_WINDOW_SIZE), packet.seqNum+uint32(1))
-		c.state = S_SYN_RCVD
+		c.receiveBuffer = NewCircBuff(c.driver.windowSize, packet.seqNum+uint32(1))
+		c.negotiate(packet)
+		c.setState(S_SYN_RCVD)
 		// Retry up to 3 times.
 		seqnum := c.seqNum.Load()
+		c.synSent = time.Now()
 		c.sendControlMsgManually(F_SYN|F_ACK, seqnum, true)
-		go func() {
+		c.driver.spawn(func() {
 			ticker, tries, acked := time.NewTicker(util.TCP_SYN_TIMEOUT_DURATION), 1, false
-			for tries <= util.TCP_MAX_RETRIES {
-				<-ticker.C
+			defer ticker.Stop()
+			for tries <= c.driver.maxRetries {
+				select {
+				case <-ticker.C:
+				case <-c.ctx.Done():
+					return
+				}
 				c.stMtx.Lock()
 				if c.state == S_SYN_RCVD {
 					tries += 1
+					c.synRetried = true
 					c.sendControlMsgManually(F_SYN|F_ACK, seqnum, false)
 				} else {
 					acked = true
@@ -114,21 +175,47 @@ func (c *Conn) handleListen(packet *TCPPacket) error {
 				c.stMtx.Unlock()
 			}
 			if !acked {
-				log.Println("syn timeout")
+				c.log.Warn("handshake timed out waiting for ack", "tries", tries)
+				c.abort(ErrTimeout)
 			}
-		}()
+		})
 	}
 	return nil
 }
 
+// Marks the connection established, wakes writers and hands it to the listener, if any. stMtx held
+// on entry.
+func (c *Conn) establish() {
+	c.setState(S_ESTABLISHED)
+	// The handshake gives the first RTT sample, unless our SYN was sent more than once.
+	if !c.synSent.IsZero() && !c.synRetried {
+		c.addRTTSample(time.Since(c.synSent))
+	}
+	c.canWrite.Store(true)
+	c.writeCond.Broadcast()
+	select {
+	case c.estSignal <- struct{}{}:
+	default:
+	}
+	if c.readyConns != nil {
+		// Don't hold up the state machine until someone accepts.
+		c.driver.spawn(func() {
+			select {
+			case c.readyConns <- c:
+			case <-c.ctx.Done():
+			case <-c.acceptCtx.Done():
+				// Nobody will accept us, so reset the peer, unless the whole driver is closing.
+				if c.driver.ctx.Err() == nil {
+					c.Abort()
+				}
+			}
+		})
+	}
+}
+
 func (c *Conn) handleSynRcvd(packet *TCPPacket) error {
 	if packet.isAck() && c.allAcked(packet) {
-		c.state = S_ESTABLISHED
-		c.canWrite.Store(true)
-		c.writeCond.Broadcast()
-		if c.readyConns != nil {
-			c.readyConns <- c
-		}
+		c.establish()
 	}
 	return nil
 }
@@ -136,32 +223,63 @@ func (c *Conn) handleSynRcvd(packet *TCPPacket) error {
 func (c *Conn) handleSynSent(packet *TCPPacket) error {
 	if packet.isSyn() && packet.isAck() && c.allAcked(packet) {
 		// Set ack number and send ack.
-		c.receiveBuffer = NewCircBuff(uint32(util.TCP_WINDOW_SIZE), packet.seqNum+uint32(1))
+		c.receiveBuffer = NewCircBuff(c.driver.windowSize, packet.seqNum+uint32(1))
+		c.negotiate(packet)
 		c.sendAck()
 		// Update state.
-		c.state = S_ESTABLISHED
-		c.canWrite.Store(true)
-		c.writeCond.Broadcast()
-		if c.readyConns != nil {
-			c.readyConns <- c
-		}
+		c.establish()
 	} else if packet.isSyn() && !packet.isAck() {
 		// Set ack number and send ack.
-		c.receiveBuffer = NewCircBuff(uint32(util.TCP_WINDOW_SIZE), packet.seqNum+uint32(1))
+		c.receiveBuffer = NewCircBuff(c.driver.windowSize, packet.seqNum+uint32(1))
+		c.negotiate(packet)
 		c.sendAck()
 		// Update state.
-		c.state = S_SYN_RCVD
+		c.setState(S_SYN_RCVD)
+	} else if packet.isAck() && !c.allAcked(packet) {
+		// An ack of something we never sent, perhaps from an old connection (RFC 793 section 3.4).
+		c.log.Debug("resetting unacceptable ack in SYN_SENT", "ack", packet.ackNum)
+		c.driver.sendReset(packet)
 	} else {
-		log.Println("handleSynSent got a weird combination of packets")
+		c.log.Debug("unexpected packet in SYN_SENT", "flags", packet.flags)
 	}
 	return nil
 }
 
+// Handles a RST. In SYN_SENT, it must ack our SYN, and refuses the connection. Otherwise it must
+// start exactly where we expect the peer's next segment (RFC 5961 section 3.2). Anywhere else in
+// our window, it gets a challenge ACK, which a peer that really reset the connection answers with
+// a RST we accept, and a forged one can't. Any other RST is dropped, as are RSTs in TIME_WAIT, which
+// would otherwise cut it short (RFC 1337).
+func (c *Conn) handleReset(packet *TCPPacket) {
+	c.stMtx.Lock()
+	state := c.state
+	c.stMtx.Unlock()
+	switch state {
+	case S_LISTEN, S_TIME_WAIT, S_CLOSED:
+	case S_SYN_SENT:
+		if packet.isAck() && c.allAcked(packet) {
+			c.abort(ErrRefused)
+		}
+	default:
+		rcvNxt := c.receiveBuffer.GetAckNum(true)
+		if packet.seqNum == rcvNxt {
+			c.abort(ErrReset)
+		} else if packet.seqNum-rcvNxt < c.receiveBuffer.GetWindowSize(true) {
+			c.log.Debug("challenging reset", "seq", packet.seqNum, "rcv_nxt", rcvNxt)
+			c.sendAck()
+		}
+	}
+}
+
 func (c *Conn) handleEstablished(packet *TCPPacket) error {
+	if packet.isSyn() {
+		// Our ack of the peer's SYN was lost, and it's sending the SYN again.
+		c.sendAck()
+	}
 	if packet.isFin() {
 		c.receiveBuffer.setFin(packet.seqNum)
 		c.sendAck()
-		c.state = S_CLOSE_WAIT
+		c.setState(S_CLOSE_WAIT)
 	}
 	return nil
 }
@@ -169,17 +287,20 @@ func (c *Conn) handleEstablished(packet *TCPPacket) error {
 func (c *Conn) triggerClose() {
 	c.stMtx.Lock()
 	defer c.stMtx.Unlock()
+	sendFin := func() { c.sendControlMsg(F_ACK|F_FIN, true) }
 	if c.state == S_ESTABLISHED {
-		go c.sendControlMsg(F_ACK|F_FIN, true)
-		c.state = S_FIN_WAIT_1
+		c.driver.spawn(sendFin)
+		c.setState(S_FIN_WAIT_1)
 	} else if c.state == S_CLOSE_WAIT {
-		go c.sendControlMsg(F_ACK|F_FIN, true)
-		c.state = S_LAST_ACK
+		c.driver.spawn(sendFin)
+		c.setState(S_LAST_ACK)
 	} else if c.state == S_SYN_RCVD {
-		go c.sendControlMsg(F_ACK|F_FIN, true)
-		c.state = S_FIN_WAIT_1
+		c.driver.spawn(sendFin)
+		c.setState(S_FIN_WAIT_1)
 	} else if c.state == S_SYN_SENT {
-		c.state = S_CLOSED
+		// Nothing to tell the peer, so give up on the handshake.
+		c.abortErr.Store(ErrClosed)
+		c.setState(S_CLOSED)
 	} else {
 		// No-op. No one thinks connection is open OR close alr called
 	}
@@ -188,9 +309,9 @@ func (c *Conn) triggerClose() {
 func (c *Conn) handleFinWait1(packet *TCPPacket) error {
 	if packet.isFin() {
 		c.receiveBuffer.setFin(packet.seqNum)
-		c.state = S_CLOSING
+		c.setState(S_CLOSING)
 	} else if packet.isAck() && c.allAcked(packet) {
-		c.state = S_FIN_WAIT_2
+		c.setState(S_FIN_WAIT_2)
 	}
 	return nil
 }
@@ -199,16 +320,21 @@ func (c *Conn) handleFinWait2(packet *TCPPacket) error {
 	if packet.isFin() {
 		c.receiveBuffer.setFin(packet.seqNum)
 		c.sendAck()
-		c.state = S_TIME_WAIT
-		_ = time.AfterFunc(util.TCP_TIME_WAIT_DURATION, func() {
-			c.stMtx.Lock()
-			defer c.stMtx.Unlock()
-			c.state = S_CLOSED
-		})
+		c.enterTimeWait()
 	}
 	return nil
 }
 
+// Moves to TIME_WAIT, and on to CLOSED once it has passed. stMtx held on entry.
+func (c *Conn) enterTimeWait() {
+	c.setState(S_TIME_WAIT)
+	c.timeWait = time.AfterFunc(util.TCP_TIME_WAIT_DURATION, func() {
+		c.stMtx.Lock()
+		defer c.stMtx.Unlock()
+		c.setState(S_CLOSED)
+	})
+}
+
 func (c *Conn) handleCloseWait(packet *TCPPacket) error {
 	// No-op, wait for Application Close
 	return nil
@@ -216,19 +342,14 @@ func (c *Conn) handleCloseWait(packet *TCPPacket) error {
 
 func (c *Conn) handleClosing(packet *TCPPacket) error {
 	if packet.isAck() && c.allAcked(packet) {
-		c.state = S_TIME_WAIT
-		_ = time.AfterFunc(util.TCP_TIME_WAIT_DURATION, func() {
-			c.stMtx.Lock()
-			defer c.stMtx.Unlock()
-			c.state = S_CLOSED
-		})
+		c.enterTimeWait()
 	}
 	return nil
 }
 
 func (c *Conn) handleLastAck(packet *TCPPacket) error {
 	if packet.isAck() && c.allAcked(packet) {
-		c.state = S_CLOSED
+		c.setState(S_CLOSED)
 	}
 	return nil
 }
@@ -13,27 +13,78 @@ const INFINITY uint32 = 16
 const DEFAULT_TTL uint8 = uint8(INFINITY)
 const RIP_UPDATE_COOLDOWN time.Duration = 5 * time.Second
 const RIP_ENTRY_TIMEOUT time.Duration = 12 * time.Second
+const ROUTE_EVENT_BUFFER int = 256
+const RAW_QUEUE_SIZE int = 64 // Packets queued on a raw socket before more are dropped.
+const STATE_SAVE_INTERVAL time.Duration = 10 * time.Second
+const SCRIPT_WAIT_TIMEOUT time.Duration = 30 * time.Second
+const SCRIPT_POLL_INTERVAL time.Duration = 50 * time.Millisecond
+
+const LS_PROTO uint8 = 89
+const LS_HELLO_INTERVAL time.Duration = 1 * time.Second
+const LS_DEAD_INTERVAL time.Duration = 4 * time.Second
+const LS_RXMT_INTERVAL time.Duration = 1 * time.Second
+const LS_REFRESH_INTERVAL time.Duration = 30 * time.Second
+const LS_MAX_AGE uint16 = 60 // Seconds.
+
+const BFD_PROTO uint8 = 253 // Reserved for experimentation.
+const BFD_DEFAULT_INTERVAL time.Duration = 100 * time.Millisecond
+const BFD_DEFAULT_MULT uint8 = 3
+
+const BGP_PORT uint16 = 179
+const BGP_HOLD_TIME time.Duration = 9 * time.Second
+const BGP_KEEPALIVE_INTERVAL time.Duration = 3 * time.Second
+const BGP_CONNECT_RETRY time.Duration = 5 * time.Second
+const BGP_SCAN_INTERVAL time.Duration = 5 * time.Second
+const BGP_DEFAULT_LOCAL_PREF uint32 = 100
+const BGP_REDIST_COST uint32 = 8     // RIP metric of routes redistributed from BGP.
+const BGP_REDIST_TAG uint32 = 0xffff // Route tag marking routes redistributed from BGP.
+const BGP_MAX_MESSAGE_SIZE int = 4096
+const BGP_OUT_QUEUE int = 1024
 
 const MAX_FRAME_SIZE int = 65536 // 64KiB.
 const MAX_PACKET_SIZE = 1024     // Following reference node.
 const MIN_PACKET_SIZE int = 20   // 20B.
+const MIN_MTU int = 576          // Smallest MTU an interface may be configured with.
 
-const TCP_WINDOW_SIZE uint16 = 32768 // 32KiB.
+const TCP_WINDOW_SIZE uint32 = 32768       // 32KiB. Initial size of the receive buffer.
+const TCP_MAX_WINDOW_SIZE uint32 = 4 << 20 // 4MiB. Largest autotuning grows the receive buffer to.
+const TCP_MAX_WINDOW_SHIFT uint8 = 14      // Largest window scale (RFC 7323).
 const TCP_TIME_WAIT_DURATION = time.Second * 10
 const TCP_SYN_UPDATE_DURATION = time.Millisecond * 50
 const TCP_SYN_TIMEOUT_DURATION = time.Millisecond * 500
 const TCP_ZWP_UPDATE_DURATION = time.Millisecond * 25
 const TCP_ZWP_WAIT_DURATION = time.Millisecond * 25
 const TCP_MAX_RETRIES = 3
+const TCP_SHUTDOWN_TIMEOUT = time.Second * 2
+const TCP_SHUTDOWN_POLL_INTERVAL = time.Millisecond * 10
+const TCP_MAX_CWND uint32 = 1 << 30 // Largest congestion window, and the initial slow start threshold.
+const TCP_DUP_THRESH uint32 = 3     // Duplicate acks, or SACKed segments above a hole, that mean it was lost.
+const TCP_DEFAULT_CC = "reno"
+const TCP_DEFAULT_MSS uint32 = 536 // Assumed when the peer doesn't send an MSS option (RFC 1122).
+const TCP_MIN_MSS uint32 = 64      // Smallest MSS we accept from a peer or a config.
+const TCP_TS_TICK = time.Millisecond
+
+const CUBIC_C = 0.4    // Scales the cubic curve, in segments per second cubed.
+const CUBIC_BETA = 0.7 // Multiplicative decrease on loss.
+
+const BBR_BW_WINDOW = 10                    // Rounds of delivery rate samples the bandwidth is the maximum of.
+const BBR_FULL_BW_ROUNDS = 3                // Rounds without growth before startup ends.
+const BBR_MIN_RTT_WINDOW = time.Second * 10 // How long a minimum round-trip time stays valid.
+const BBR_PROBE_RTT_DURATION = time.Millisecond * 200
+const BBR_MIN_CWND uint32 = 4 // Segments.
+const BBR_CWND_GAIN = 2.0
 
-const DEFAULT_RTO = time.Millisecond * 100
-const DEFAULT_RTT = time.Millisecond
+const DEFAULT_RTT = time.Millisecond // Assumed round-trip time before there are any samples.
 
-const SRTT_INITIAL_GUESS = 1 * 1e6
-const SRTT_ALPHA = 0.9
-const SRTT_BETA = 1.5
-const SRTT_MIN = 1 * 1e6
-const SRTT_MAX = 500 * 1e6
+// Retransmission timer (RFC 6298).
+const TCP_RTO_INITIAL = time.Second
+const TCP_RTO_MIN = time.Millisecond * 10 // Lower than the RFC's 1s, since our links are emulated.
+const TCP_RTO_MAX = time.Second * 60
+const TCP_CLOCK_GRANULARITY = time.Millisecond
+const TCP_MAX_RETRANSMITS = 10 // Consecutive timeouts before the connection is aborted.
+const RTT_ALPHA = 0.125
+const RTT_BETA = 0.25
+const RTT_K = 4
 
 var DEFAULT_MASK net.IP = net.ParseIP("255.255.255.255")
 
@@ -44,9 +95,13 @@ interfaces : Print information about each interface, one per line
 li : Print information about each interface, one per line
 routes : Print information about the route to each known destination, one per line
 lr : Print information about the route to each known destination, one per line
+rib: Print every candidate route from every source, marking the ones in use
 up [integer]: Bring an interface "up" (it must be an existing interface, probably one you brought down)
 down [integer]: Bring an interface "down"
 send [ip] [protocol] [payload]: sends payload with protocol=protocol to virtual-ip ip
+lsdb: Print the link-state database and adjacencies (with -ls)
+bfd: Print the BFD sessions (with -bfd)
+bgp: Print the BGP peers and paths (with an as directive)
 q: Quit this node`
 
 const TCP_HELP_MESSAGE = `No valid command specified
@@ -71,11 +126,19 @@ rf <filename> <port>           - Listen for a connection on the given port.
                                  close the connection as well.
 sd <socket> [read|write|both]  - v_shutdown on the given socket.
 cl <socket>                    - v_close on the given socket.
+ab <socket>                    - abort the given socket, sending a RST.
 up <id>                        - enable interface with id
 down <id>                      - disable interface with id
 li, interfaces                 - list interfaces
 lr, routes                     - list routing table rows
+rib                            - list candidate routes from every source
+lsdb                           - list link-state database (with -ls)
+bfd                            - list BFD sessions (with -bfd)
+bgp                            - list BGP peers and paths (with an as
+                                 directive)
 ls, sockets                    - list sockets (fd, ip, port, state)
 window <socket>                - lists window sizes for socket
+cc [<socket>] [<algorithm>]    - show or set congestion control for new
+                                 connections, or for a socket
 q, quit                        - no cleanup, exit(0)
 h, help                        - show this help`
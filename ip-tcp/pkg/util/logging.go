@@ -1,20 +1,235 @@
 package util
 
 import (
-	"io/ioutil"
-	"log"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-// Debug is optional logger for debugging.
-var Debug *log.Logger
+// Level is the severity of a log message.
+type Level int
 
-// SetDebug turns debug print statements on or off.
+const (
+	LEVEL_DEBUG Level = iota
+	LEVEL_INFO
+	LEVEL_WARN
+	LEVEL_ERROR
+	LEVEL_OFF
+)
+
+var levelNames = map[Level]string{
+	LEVEL_DEBUG: "debug",
+	LEVEL_INFO:  "info",
+	LEVEL_WARN:  "warn",
+	LEVEL_ERROR: "error",
+	LEVEL_OFF:   "off",
+}
+
+func (level Level) String() string {
+	return levelNames[level]
+}
+
+// Parses a level name like debug or warn.
+func ParseLevel(s string) (Level, error) {
+	for level, name := range levelNames {
+		if name == strings.ToLower(s) {
+			return level, nil
+		}
+	}
+	return LEVEL_OFF, fmt.Errorf("unknown log level %v", s)
+}
+
+// Logger writes leveled messages tagged with a subsystem and key/value fields.
+type Logger struct {
+	subsystem string
+	fields    []interface{} // Alternating keys and values added by With.
+}
+
+// Logging configuration shared by every logger.
+var logging = struct {
+	mtx          sync.RWMutex
+	out          io.Writer
+	json         bool
+	defaultLevel Level
+	levels       map[string]Level // Per-subsystem overrides of defaultLevel.
+	subsystems   map[string]bool  // Every subsystem with a logger.
+}{
+	out:          os.Stdout,
+	defaultLevel: LEVEL_INFO,
+	levels:       make(map[string]Level),
+	subsystems:   make(map[string]bool),
+}
+
+// Creates the logger for a subsystem.
+func NewLogger(subsystem string) *Logger {
+	logging.mtx.Lock()
+	defer logging.mtx.Unlock()
+	logging.subsystems[subsystem] = true
+	return &Logger{subsystem: subsystem}
+}
+
+// Returns a logger that adds key/value pairs to every message, e.g. With("conn", id).
+func (l *Logger) With(kv ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+	return &Logger{subsystem: l.subsystem, fields: fields}
+}
+
+// Checks if messages at a level would be written, to skip building expensive fields.
+func (l *Logger) Enabled(level Level) bool {
+	logging.mtx.RLock()
+	defer logging.mtx.RUnlock()
+	threshold, exists := logging.levels[l.subsystem]
+	if !exists {
+		threshold = logging.defaultLevel
+	}
+	return level >= threshold && level < LEVEL_OFF
+}
+
+func (l *Logger) Debug(msg string, kv ...interface{}) { l.write(LEVEL_DEBUG, msg, kv) }
+func (l *Logger) Info(msg string, kv ...interface{})  { l.write(LEVEL_INFO, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...interface{})  { l.write(LEVEL_WARN, msg, kv) }
+func (l *Logger) Error(msg string, kv ...interface{}) { l.write(LEVEL_ERROR, msg, kv) }
+
+// Formats and writes a single message.
+func (l *Logger) write(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	fields := append(append([]interface{}{}, l.fields...), kv...)
+	if len(fields)%2 != 0 {
+		fields = append(fields, "MISSING")
+	}
+	now := time.Now()
+	var b strings.Builder
+	logging.mtx.RLock()
+	defer logging.mtx.RUnlock()
+	if logging.json {
+		b.WriteString(`{"time":`)
+		writeJSON(&b, now.Format(time.RFC3339Nano))
+		b.WriteString(`,"level":`)
+		writeJSON(&b, level.String())
+		b.WriteString(`,"subsystem":`)
+		writeJSON(&b, l.subsystem)
+		b.WriteString(`,"msg":`)
+		writeJSON(&b, msg)
+		for i := 0; i < len(fields); i += 2 {
+			b.WriteString(",")
+			writeJSON(&b, fmt.Sprint(fields[i]))
+			b.WriteString(":")
+			writeJSON(&b, fieldValue(fields[i+1]))
+		}
+		b.WriteString("}\n")
+	} else {
+		fmt.Fprintf(&b, "%v %-5v %-4v %v", now.Format("15:04:05.000"), strings.ToUpper(level.String()), l.subsystem, msg)
+		for i := 0; i < len(fields); i += 2 {
+			value := fmt.Sprint(fieldValue(fields[i+1]))
+			if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+				value = fmt.Sprintf("%q", value)
+			}
+			fmt.Fprintf(&b, " %v=%v", fields[i], value)
+		}
+		b.WriteString("\n")
+	}
+	io.WriteString(logging.out, b.String())
+}
+
+// Converts a field value to something that prints or marshals sensibly.
+func fieldValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case fmt.Stringer:
+		return v.String()
+	case nil, string, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	default:
+		return fmt.Sprintf("%+v", v)
+	}
+}
+
+// Writes a value as JSON, falling back to its string form.
+func writeJSON(b *strings.Builder, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+// Sets log levels from a spec like "tcp=debug,rip=warn". A bare level sets the default for
+// every subsystem not named. Subsystems not mentioned keep their current level.
+func ConfigureLogging(spec string) error {
+	logging.mtx.Lock()
+	defer logging.mtx.Unlock()
+	defaultLevel, levels := logging.defaultLevel, make(map[string]Level)
+	for subsystem, level := range logging.levels {
+		levels[subsystem] = level
+	}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) == 1 {
+			level, err := ParseLevel(parts[0])
+			if err != nil {
+				return err
+			}
+			defaultLevel = level
+			continue
+		}
+		if !logging.subsystems[parts[0]] {
+			return fmt.Errorf("unknown log subsystem %v (have %v)", parts[0], strings.Join(logSubsystems(), ", "))
+		}
+		level, err := ParseLevel(parts[1])
+		if err != nil {
+			return err
+		}
+		levels[parts[0]] = level
+	}
+	logging.defaultLevel, logging.levels = defaultLevel, levels
+	return nil
+}
+
+// Sets where log messages go and whether they're written as text or JSON lines.
+func SetLogOutput(out io.Writer, format string) error {
+	if format != "text" && format != "json" {
+		return errors.New("log format must be text or json")
+	}
+	logging.mtx.Lock()
+	defer logging.mtx.Unlock()
+	logging.out, logging.json = out, format == "json"
+	return nil
+}
+
+// Returns the names of every subsystem with a logger, sorted. logging.mtx held on entry.
+func logSubsystems() []string {
+	names := make([]string, 0, len(logging.subsystems))
+	for name := range logging.subsystems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// InitDebug turns debug messages on for every subsystem, or resets every subsystem to the
+// default level.
 func InitDebug(enabled bool) {
-	Debug = log.New(ioutil.Discard, "DEBUG: ", log.Ltime|log.Lshortfile)
+	logging.mtx.Lock()
+	defer logging.mtx.Unlock()
+	logging.levels = make(map[string]Level)
 	if enabled {
-		Debug.SetOutput(os.Stdout)
+		logging.defaultLevel = LEVEL_DEBUG
 	} else {
-		Debug.SetOutput(ioutil.Discard)
+		logging.defaultLevel = LEVEL_INFO
 	}
 }
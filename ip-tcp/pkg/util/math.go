@@ -6,3 +6,24 @@ func Min(x, y uint32) uint32 {
 	}
 	return y
 }
+
+func Max(x, y uint32) uint32 {
+	if x > y {
+		return x
+	}
+	return y
+}
+
+// Checks if x is a power of 2. The circular buffers index by sequence number modulo their size,
+// which only stays consistent across wraparound for powers of 2.
+func IsPowerOf2(x uint32) bool {
+	return x != 0 && x&(x-1) == 0
+}
+
+// Rounds x down to a power of 2, or 0 if x is 0.
+func PowerOf2Floor(x uint32) uint32 {
+	for x&(x-1) != 0 {
+		x &= x - 1
+	}
+	return x
+}
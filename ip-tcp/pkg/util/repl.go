@@ -1,6 +1,7 @@
 package util
 
 import (
+	"bufio"
 	"fmt"
 	"os"
 	"os/exec"
@@ -9,6 +10,21 @@ import (
 func InitREPL(prompt string, readyChan chan bool) chan string {
 	strChan := make(chan string)
 
+	// Without a terminal, just read lines; there's nothing to edit or echo.
+	if !IsTerminal(os.Stdin) {
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				if len(scanner.Text()) == 0 {
+					continue
+				}
+				strChan <- scanner.Text()
+				<-readyChan
+			}
+		}()
+		return strChan
+	}
+
 	// disable input buffering
 	exec.Command("stty", "-F", "/dev/tty", "cbreak", "min", "1").Run()
 	// do not display entered characters on the screen
@@ -25,7 +41,10 @@ func InitREPL(prompt string, readyChan chan bool) chan string {
 		var idx int = 0
 		for {
 			curstr := histcopy[idx]
-			os.Stdin.Read(b)
+			// Stop at the end of input, e.g. when stdin is /dev/null.
+			if n, _ := os.Stdin.Read(b); n == 0 {
+				return
+			}
 			if string(b[0]) == "\x1b" { // ESC character
 				os.Stdin.Read(b)
 				if string(b[0]) == "[" {
@@ -109,10 +128,19 @@ func InitREPL(prompt string, readyChan chan bool) chan string {
 }
 
 func CloseRepl() {
+	if !IsTerminal(os.Stdin) {
+		return
+	}
 	// reenable displaying characters on the screen
 	exec.Command("stty", "-F", "/dev/tty", "echo").Run()
 }
 
+// IsTerminal returns if the file is a terminal rather than a pipe or regular file.
+func IsTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
 // isPrintableChar returns if b represents a printable ascii char based on
 // https://www.asciitable.com/
 func isPrintableChar(b byte) bool {
@@ -65,5 +65,8 @@ func ValidMask(mask net.IP) bool {
 
 // computes the length of the mask; assumes valid mask.
 func MaskLen(mask net.IP) int {
+	if IP2int(mask) == 0 {
+		return 0
+	}
 	return 32 - int(math.Log2(float64((^IP2int(mask))+1)))
 }
@@ -0,0 +1,79 @@
+package bgp_test
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	bgp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/bgp"
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+)
+
+func TestUpdateRoundTrip(t *testing.T) {
+	update := bgp.Update{
+		Withdrawn: []ip.Route{ip.NewRoute(0x0a000000, 0xff000000)},
+		MED:       3,
+		NextHop:   net.ParseIP("192.168.0.3"),
+		ASPath:    []uint32{1, 65001},
+		NLRI:      []ip.Route{ip.NewRoute(0xc0a80001, 0xffffffff), ip.NewRoute(0xac100000, 0xfff00000)},
+	}
+	data, err := bgp.SerializeUpdate(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	length, msgType, err := bgp.DeserializeHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if length != len(data) || msgType != bgp.MSG_UPDATE {
+		t.Fatalf("bad header: length %v type %v", length, msgType)
+	}
+	parsed, err := bgp.DeserializeUpdate(data[bgp.HEADER_SIZE:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !parsed.NextHop.Equal(update.NextHop) {
+		t.Fatalf("expected next hop %v, got %v", update.NextHop, parsed.NextHop)
+	}
+	parsed.NextHop = update.NextHop
+	if !reflect.DeepEqual(parsed, update) {
+		t.Fatalf("expected %+v, got %+v", update, parsed)
+	}
+}
+
+func TestOpenRoundTrip(t *testing.T) {
+	open := bgp.Open{Version: 4, ASN: 65002, HoldTime: 9, RouterID: 0xc0a80004}
+	data := bgp.SerializeOpen(open)
+	parsed, err := bgp.DeserializeOpen(data[bgp.HEADER_SIZE:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != open {
+		t.Fatalf("expected %+v, got %+v", open, parsed)
+	}
+}
+
+func TestUpdateTruncated(t *testing.T) {
+	data, err := bgp.SerializeUpdate(bgp.Update{ASPath: []uint32{1}, NLRI: []ip.Route{ip.NewRoute(0, 0)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bgp.DeserializeUpdate(data[bgp.HEADER_SIZE : len(data)-1]); err == nil {
+		t.Fatal("should have rejected truncated update")
+	}
+}
+
+func TestUpdateASPathTooLong(t *testing.T) {
+	update := bgp.Update{ASPath: make([]uint32, bgp.MAX_AS_PATH_LEN), NLRI: []ip.Route{ip.NewRoute(0, 0)}}
+	data, err := bgp.SerializeUpdate(update)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, err := bgp.DeserializeUpdate(data[bgp.HEADER_SIZE:]); err != nil || len(parsed.ASPath) != bgp.MAX_AS_PATH_LEN {
+		t.Fatalf("expected a path of %v ASes, got %v, %v", bgp.MAX_AS_PATH_LEN, len(parsed.ASPath), err)
+	}
+	update.ASPath = append(update.ASPath, 1)
+	if _, err := bgp.SerializeUpdate(update); err == nil {
+		t.Fatal("should have refused a path that doesn't fit")
+	}
+}
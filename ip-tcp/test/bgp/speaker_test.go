@@ -0,0 +1,124 @@
+package bgp_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	bgp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/bgp"
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Finds a free UDP port on localhost.
+func freePort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Creates and runs a node in its own AS, linked to a neighbour in another, with a TCP driver for
+// BGP to run over. Extra lnx lines follow the interface to the neighbour. There is no way to stop a
+// running node, so it runs until the test binary exits.
+func newBGPNode(t *testing.T, port int, peerPort int, addr string, peerAddr string, asn uint32, peerAS uint32, extra ...string) (*ip.Node, *tcp.Driver) {
+	lnxfile := filepath.Join(t.TempDir(), "node.lnx")
+	lnx := fmt.Sprintf("localhost %v\nlocalhost %v %v %v\n", port, peerPort, addr, peerAddr)
+	lnx += strings.Join(append(extra, fmt.Sprintf("as %v\nneighbor %v remote-as %v\n", asn, peerAddr, peerAS)), "\n")
+	if err := os.WriteFile(lnxfile, []byte(lnx), 0644); err != nil {
+		t.Fatal(err)
+	}
+	node, err := ip.NewNode(lnxfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := tcp.InitDriver(node)
+	node.RegisterHandler(6, driver.TCPHandler)
+	node.Run(false)
+	return node, driver
+}
+
+// Waits for a node's route to a prefix to satisfy cond, which is passed a nil entry if there is
+// no route.
+func waitForRoute(t *testing.T, node *ip.Node, route ip.Route, what string, cond func(entry *ip.Entry) bool) {
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		var entry *ip.Entry
+		if e, exists := node.Routes()[route]; exists {
+			entry = &e
+		}
+		if cond(entry) {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Delivers a RIP response to a node as if it came from its neighbour on an interface.
+func receiveRIP(t *testing.T, node *ip.Node, linkID int, entries ...ip.RIPEntry) {
+	interf := node.LocalInterfaces[linkID]
+	packet := ip.NewIPPacket(200, ip.SerializeRIPData(ip.RIPData{Command: 2, Entries: entries}), util.DEFAULT_TTL,
+		interf.Remote, interf.Addr)
+	if err := ip.RIPHandler(node, packet, linkID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpeakersExchangeRoutes(t *testing.T) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	nodeA, driverA := newBGPNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 65001, 65002)
+	// B also has an interface into the rest of its AS, and runs no RIP across the AS boundary.
+	nodeB, driverB := newBGPNode(t, portB, portA, "10.0.0.2", "10.0.0.1", 65002, 65001,
+		fmt.Sprintf("localhost %v 10.1.0.1 10.1.0.2", freePort(t)),
+		"prefix-list ebgp deny 0.0.0.0/0 le 32", "import 0 prefix-list ebgp", "export 0 prefix-list ebgp")
+	// A connects to B, so B listens first.
+	for _, s := range []struct {
+		node   *ip.Node
+		driver *tcp.Driver
+	}{{nodeB, driverB}, {nodeA, driverA}} {
+		speaker, err := bgp.NewSpeaker(s.node, s.driver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := speaker.Start(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// B learns two routes over RIP: one another border node redistributed from BGP, and a distant
+	// one. Only the second is announced to A, however much it costs.
+	distant, _ := ip.ParsePrefix("10.9.0.0/16")
+	redistributed, _ := ip.ParsePrefix("10.8.0.0/16")
+	receiveRIP(t, nodeB, 1,
+		ip.RIPEntry{Cost: 2, Tag: util.BGP_REDIST_TAG, Addr: util.Int2IP(redistributed.Addr), Mask: util.Int2IP(redistributed.Mask)},
+		ip.RIPEntry{Cost: 12, Addr: util.Int2IP(distant.Addr), Mask: util.Int2IP(distant.Mask)})
+	waitForRoute(t, nodeA, distant, "the route to be learned over bgp", func(entry *ip.Entry) bool {
+		return entry != nil && entry.Source == ip.SRC_BGP
+	})
+	entry := nodeA.Routes()[distant]
+	if entry.Detail != "as-path 65002" {
+		t.Errorf("expected the path through B's AS, got %q", entry.Detail)
+	}
+	if entry.Tag != util.BGP_REDIST_TAG {
+		t.Errorf("expected the route to be tagged as redistributed from bgp, got tag %v", entry.Tag)
+	}
+	if entry, exists := nodeA.Routes()[redistributed]; exists && entry.Source == ip.SRC_BGP {
+		t.Error("expected a route redistributed from bgp not to be sent back into bgp")
+	}
+
+	// Losing it on B withdraws it from A's BGP table too.
+	receiveRIP(t, nodeB, 1, ip.RIPEntry{Cost: util.INFINITY, Addr: util.Int2IP(distant.Addr), Mask: util.Int2IP(distant.Mask)})
+	waitForRoute(t, nodeA, distant, "the route to be withdrawn over bgp", func(entry *ip.Entry) bool {
+		return entry == nil || entry.Source != ip.SRC_BGP
+	})
+}
@@ -0,0 +1,94 @@
+package ctl_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	ctl "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ctl"
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Starts a control server for a node with a single interface, and connects a client to it.
+func newTestClient(t *testing.T) *ctl.Client {
+	util.InitDebug(false)
+	dir, err := ioutil.TempDir("", "ctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	lnx := filepath.Join(dir, "A.lnx")
+	ioutil.WriteFile(lnx, []byte("localhost 0\nlocalhost 1 10.0.0.1 10.0.0.2\n"), 0644)
+	node, err := ip.NewNode(lnx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.UDPConn.Close() })
+	server := ctl.NewServer(node, tcp.InitDriver(node))
+	if err := server.Listen(filepath.Join(dir, "A.sock")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { server.Close() })
+	client, err := ctl.Dial(filepath.Join(dir, "A.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+func TestInterfacesAndRoutes(t *testing.T) {
+	client := newTestClient(t)
+	result, err := client.Call("li")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var interfaces []ctl.Interface
+	json.Unmarshal(result, &interfaces)
+	if len(interfaces) != 1 || interfaces[0].Local != "10.0.0.1" || interfaces[0].Remote != "10.0.0.2" || !interfaces[0].Up {
+		t.Fatalf("unexpected interfaces %s", result)
+	}
+
+	// Taking the interface down removes its address from the routing table.
+	if _, err := client.Call("down", "0"); err != nil {
+		t.Fatal(err)
+	}
+	result, err = client.Call("lr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var routes []ctl.Route
+	json.Unmarshal(result, &routes)
+	if len(routes) != 0 {
+		t.Fatalf("expected no routes, got %s", result)
+	}
+	if _, err := client.Call("up", "0"); err != nil {
+		t.Fatal(err)
+	}
+	result, _ = client.Call("lr")
+	json.Unmarshal(result, &routes)
+	if len(routes) != 1 || routes[0].Prefix != "10.0.0.1/32" || routes[0].Source != "connected" {
+		t.Fatalf("unexpected routes %s", result)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	client := newTestClient(t)
+	if _, err := client.Call("down", "7"); err == nil {
+		t.Fatal("expected error for a missing interface")
+	}
+	if _, err := client.Call("s", "0", "hello"); err == nil {
+		t.Fatal("expected error for a missing socket")
+	}
+	if _, err := client.Call("r", "0", "4294967295"); err == nil || !strings.Contains(err.Error(), "maximum") {
+		t.Fatalf("expected a huge read to be refused, got %v", err)
+	}
+	if _, err := client.Call("bogus"); err == nil {
+		t.Fatal("expected error for an unknown command")
+	}
+}
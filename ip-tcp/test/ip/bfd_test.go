@@ -0,0 +1,134 @@
+package ip_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+func TestBFDPacketRoundTrip(t *testing.T) {
+	bfdPacket := ip.BFDPacket{
+		State:         ip.BFD_UP,
+		DetectMult:    3,
+		MyDiscr:       0xdeadbeef,
+		YourDiscr:     0x12345679,
+		DesiredMinTx:  50000,
+		RequiredMinRx: 100000,
+	}
+	data := ip.SerializeBFDPacket(bfdPacket)
+	if len(data) != 20 {
+		t.Errorf("expected a 20 byte packet, got %v bytes", len(data))
+	}
+	parsed, err := ip.DeserializeBFDPacket(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed != bfdPacket {
+		t.Errorf("expected %+v, got %+v", bfdPacket, parsed)
+	}
+
+	if _, err := ip.DeserializeBFDPacket(data[:19]); err == nil {
+		t.Error("expected a short packet to be rejected")
+	}
+	bad := append([]byte{}, data...)
+	bad[1] = ip.BFD_UP + 1
+	if _, err := ip.DeserializeBFDPacket(bad); err == nil {
+		t.Error("expected an unknown state to be rejected")
+	}
+	bad = append([]byte{}, data...)
+	bad[2] = 0
+	if _, err := ip.DeserializeBFDPacket(bad); err == nil {
+		t.Error("expected a zero detection multiplier to be rejected")
+	}
+}
+
+// Delivers a BFD control packet from the neighbour on interface 0, in the given state.
+func receiveBFD(t *testing.T, node *ip.Node, state uint8) {
+	data := ip.SerializeBFDPacket(ip.BFDPacket{
+		State:         state,
+		DetectMult:    3,
+		MyDiscr:       7,
+		DesiredMinTx:  1000,
+		RequiredMinRx: 1000,
+	})
+	packet := ip.NewIPPacket(util.BFD_PROTO, data, util.DEFAULT_TTL, net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"))
+	if err := ip.BFDHandler(node, packet, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBFDStateMachine(t *testing.T) {
+	node, peers := newProbedNode(t, 1)
+	// Detection never fires on its own; only the neighbour's packets move the session.
+	node.SetBFD(time.Hour, 3)
+	route := mustParsePrefix(t, "10.1.1.0/24")
+	learned := func() bool {
+		receiveRIPOn(t, node, 0, ripEntry(t, "10.1.1.0/24", 1))
+		_, exists := node.Routes()[route]
+		return exists
+	}
+	// Until the session first comes up, routes are learned as usual.
+	if !learned() {
+		t.Fatal("expected to learn routes before bfd comes up")
+	}
+
+	// Down -> Init -> Up. Coming up asks the neighbour for its routes.
+	drain(peers[0])
+	receiveBFD(t, node, ip.BFD_DOWN)
+	receiveBFD(t, node, ip.BFD_UP)
+	nextRIP(t, peers[0])
+
+	// The neighbour going down pulls its routes, and stops us learning new ones.
+	receiveBFD(t, node, ip.BFD_ADMIN_DOWN)
+	if _, exists := node.Routes()[route]; exists {
+		t.Error("expected routes from the neighbour to be flushed when bfd goes down")
+	}
+	if learned() {
+		t.Error("expected routes not to be learned while bfd is down")
+	}
+
+	receiveBFD(t, node, ip.BFD_DOWN)
+	receiveBFD(t, node, ip.BFD_INIT)
+	if !learned() {
+		t.Error("expected to learn routes once bfd is back up")
+	}
+}
+
+func TestBFDDetectsDeadNeighbor(t *testing.T) {
+	interval, mult := 20*time.Millisecond, uint8(3)
+	nodeA, nodeB := newConfiguredLinkedNodes(t, func(cfg *ip.Config) {
+		cfg.Routing.BFD = &ip.BFDConfig{Interval: ip.Duration(interval), Mult: mult}
+	})
+	// Give the session a few intervals to come up.
+	time.Sleep(20 * interval)
+
+	events := nodeA.SubscribeRoutes()
+	defer nodeA.UnsubscribeRoutes(events)
+	route := mustParsePrefix(t, "10.0.0.2/32")
+	nodeB.Close()
+	start := time.Now()
+	for {
+		select {
+		case ev := <-events:
+			if ev.Route != route {
+				continue
+			}
+			if ev.Cause != ip.CAUSE_BFD {
+				t.Fatalf("expected the route to be removed by bfd, got %v", ev)
+			}
+			// Well within RIP's timeout, but allow for a slow scheduler.
+			if elapsed := time.Since(start); elapsed > 5*interval*time.Duration(mult) {
+				t.Errorf("expected the route to go within the detect time, took %v", elapsed)
+			}
+			if _, exists := nodeA.Routes()[route]; exists {
+				t.Error("expected the route to be gone from the routing table")
+			}
+			return
+		case <-time.After(2 * time.Second):
+			t.Fatal("route to the dead neighbour was never removed")
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package ip_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Writes a file for the duration of the test.
+func writeTempFile(t *testing.T, pattern string, contents string) string {
+	file, err := ioutil.TempFile("", pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Remove(file.Name()) })
+	file.WriteString(contents)
+	file.Close()
+	return file.Name()
+}
+
+func TestConfigValidation(t *testing.T) {
+	cases := map[string]string{
+		`{"listen": "localhost"}`: "listen:",
+		`{"listen": "localhost:0", "interfaces": [{"name": "a", "remote": "localhost:1", "addr": "10.0.0.1", "remote_addr": "10.0.0"}]}`:                "interfaces[0].remote_addr:",
+		`{"listen": "localhost:0", "interfaces": [{"name": "a", "remote": "localhost:1", "addr": "10.0.0.1", "remote_addr": "10.0.0.2", "loss": 1.5}]}`: "interfaces[0].loss:",
+		`{"listen": "localhost:0", "static_routes": [{"prefix": "10.9.0.0/16", "via": "10.0.0.2"}]}`:                                                    "static_routes[0].via:",
+		`{"listen": "localhost:0", "routing": {"protocol": "ospf"}}`:                                                                                    "routing.protocol:",
+		`{"listen": "localhost:0", "routing": {"rip_timeout": "1s"}}`:                                                                                   "routing.rip_timeout:",
+		`{"listen": "localhost:0", "tcp": {"max_window_size": 1000000}}`:                                                                                "tcp.max_window_size:",
+		`{"listen": "localhost:0", "tcp": {"window_size": 1000}}`:                                                                                       "tcp.window_size:",
+		`{"listen": "localhost:0", "tpc": {}}`:                                                                                                          `unknown field "tpc"`,
+	}
+	for contents, expected := range cases {
+		_, err := ip.LoadConfig(writeTempFile(t, "*.json", contents))
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("%v: expected error mentioning %q, got %v", contents, expected, err)
+		}
+	}
+}
+
+func TestLnxConversion(t *testing.T) {
+	cfg, err := ip.ParseLnx(writeTempFile(t, "*.lnx", `localhost 0
+localhost 1 10.0.0.1 10.0.0.2
+route 10.9.0.0/16 via 10.0.0.2 cost 3
+cost 0 2
+as 7
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != "localhost:0" || len(cfg.Interfaces) != 1 || cfg.Interfaces[0].Name != "if0" || cfg.Interfaces[0].Remote != "localhost:1" {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if len(cfg.StaticRoutes) != 1 || cfg.StaticRoutes[0].Cost != 3 || len(cfg.Policy) != 1 || cfg.BGP == nil || cfg.BGP.AS != 7 {
+		t.Fatalf("unexpected config %+v", cfg)
+	}
+	if _, err := ip.ParseLnx(writeTempFile(t, "*.lnx", "localhost 0\nlocalhost 1 10.0.0.1\n")); err == nil || !strings.Contains(err.Error(), ":2:") {
+		t.Fatalf("expected error on line 2, got %v", err)
+	}
+}
+
+func TestNewNodeFromConfig(t *testing.T) {
+	util.InitDebug(false)
+	cfg := &ip.Config{
+		Listen: "localhost:0",
+		Interfaces: []ip.InterfaceConfig{
+			{Name: "uplink", Remote: "localhost:1", Addr: "10.0.0.1", RemoteAddr: "10.0.0.2", Cost: 4},
+			{Name: "spare", Remote: "localhost:2", Addr: "10.0.1.1", RemoteAddr: "10.0.1.2", Down: true},
+		},
+		StaticRoutes: []ip.StaticRouteConfig{{Prefix: "10.9.0.0/16", Via: "10.0.0.2"}},
+		Policy:       []string{"export uplink offset 2"},
+	}
+	node, err := ip.NewNodeFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.UDPConn.Close()
+	if node.LocalInterfaces[0].Cost != 4 || node.LocalInterfaces[0].Export.Offset != 2 {
+		t.Fatal("expected interface cost and policy to be applied by name")
+	}
+	routes := node.Routes()
+	static, _ := ip.ParsePrefix("10.9.0.0/16")
+	if entry, exists := routes[static]; !exists || entry.Source != ip.SRC_STATIC || entry.Cost != 1 {
+		t.Fatalf("expected static route, got %+v", entry)
+	}
+	spare, _ := ip.ParsePrefix("10.0.1.1/32")
+	if _, exists := routes[spare]; exists {
+		t.Fatal("expected no route for an interface that starts down")
+	}
+}
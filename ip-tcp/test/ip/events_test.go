@@ -0,0 +1,64 @@
+package ip_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Creates a node with a single interface to a peer that doesn't exist.
+func newTestNode(t *testing.T) *ip.Node {
+	util.InitDebug(false)
+	file, err := ioutil.TempFile("", "*.lnx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("localhost 0\nlocalhost 1 10.0.0.1 10.0.0.2\n")
+	file.Close()
+	node, err := ip.NewNode(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.UDPConn.Close() })
+	return node
+}
+
+func nextEvent(t *testing.T, sub <-chan ip.RouteEvent) ip.RouteEvent {
+	select {
+	case ev := <-sub:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for route event")
+	}
+	return ip.RouteEvent{}
+}
+
+func TestRouteEventsOnInterfaceDownUp(t *testing.T) {
+	node := newTestNode(t)
+	sub := node.SubscribeRoutes()
+	readyChan := make(chan bool, 1)
+
+	node.HandleStdin([]string{"down", "0"}, readyChan)
+	ev := nextEvent(t, sub)
+	if ev.Type != ip.ROUTE_WITHDRAWN || ev.Cause != ip.CAUSE_IF_DOWN || ev.Interface != 0 || ev.OldCost != 0 {
+		t.Fatalf("unexpected event %v", ev)
+	}
+	<-readyChan
+
+	node.HandleStdin([]string{"up", "0"}, readyChan)
+	ev = nextEvent(t, sub)
+	if ev.Type != ip.ROUTE_ADDED || ev.Cause != ip.CAUSE_IF_UP || ev.NewCost != 0 {
+		t.Fatalf("unexpected event %v", ev)
+	}
+	<-readyChan
+
+	node.UnsubscribeRoutes(sub)
+	if _, ok := <-sub; ok {
+		t.Fatal("subscription should have been closed")
+	}
+}
@@ -0,0 +1,41 @@
+package ip_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Sends a RIP request out of interface 0 by way of the neighbour asking for our routes.
+func provokeRIP(t *testing.T, node *ip.Node) {
+	packet := ip.NewIPPacket(200, ip.SerializeRIPData(ip.RIPData{Command: 1}), util.DEFAULT_TTL,
+		net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"))
+	if err := ip.RIPHandler(node, packet, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDelayedPacketsStopOnClose(t *testing.T) {
+	delay := 100 * time.Millisecond
+	node, peers := newProbedNode(t, 1)
+	node.LocalInterfaces[0].Delay = delay
+
+	start := time.Now()
+	provokeRIP(t, node)
+	nextRIP(t, peers[0])
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("expected the packet to be held back for %v, arrived after %v", delay, elapsed)
+	}
+
+	// Packets still waiting out the delay are dropped once the node is closed.
+	provokeRIP(t, node)
+	node.Close()
+	buf := make([]byte, util.MAX_FRAME_SIZE)
+	peers[0].SetReadDeadline(time.Now().Add(3 * delay))
+	if _, _, err := peers[0].ReadFromUDP(buf); err == nil {
+		t.Error("expected a delayed packet not to be sent after the node closed")
+	}
+}
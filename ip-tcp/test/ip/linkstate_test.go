@@ -0,0 +1,60 @@
+package ip_test
+
+import (
+	"reflect"
+	"testing"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+)
+
+func TestLSUpdateRoundTrip(t *testing.T) {
+	lsPacket := ip.LSPacket{
+		Type:   ip.LS_UPDATE,
+		Router: 1,
+		LSAs: []ip.LSA{
+			{
+				Router:   1,
+				Seq:      7,
+				Age:      3,
+				Links:    []ip.LSLink{{Neighbor: 2, Cost: 1}, {Neighbor: 3, Cost: 4}},
+				Prefixes: []ip.LSPrefix{{Addr: 0xc0a80001, Mask: 0xffffffff, Cost: 0}},
+			},
+			{
+				Router:   2,
+				Seq:      1,
+				Links:    []ip.LSLink{},
+				Prefixes: []ip.LSPrefix{},
+			},
+		},
+	}
+	parsed, err := ip.DeserializeLSPacket(ip.SerializeLSPacket(lsPacket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, lsPacket) {
+		t.Fatalf("expected %+v, got %+v", lsPacket, parsed)
+	}
+}
+
+func TestLSHelloRoundTrip(t *testing.T) {
+	lsPacket := ip.LSPacket{Type: ip.LS_HELLO, Router: 5, Neighbors: []uint32{6}}
+	parsed, err := ip.DeserializeLSPacket(ip.SerializeLSPacket(lsPacket))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(parsed, lsPacket) {
+		t.Fatalf("expected %+v, got %+v", lsPacket, parsed)
+	}
+}
+
+func TestLSTruncated(t *testing.T) {
+	lsPacket := ip.LSPacket{
+		Type:   ip.LS_UPDATE,
+		Router: 1,
+		LSAs:   []ip.LSA{{Router: 1, Seq: 1, Links: []ip.LSLink{{Neighbor: 2, Cost: 1}}}},
+	}
+	data := ip.SerializeLSPacket(lsPacket)
+	if _, err := ip.DeserializeLSPacket(data[:len(data)-1]); err == nil {
+		t.Fatal("should have rejected truncated packet")
+	}
+}
@@ -0,0 +1,247 @@
+package ip_test
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+func mustParsePrefix(t *testing.T, s string) ip.Route {
+	route, err := ip.ParsePrefix(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return route
+}
+
+func TestPrefixListFirstMatchWins(t *testing.T) {
+	deny := mustParsePrefix(t, "192.168.0.0/30")
+	any := mustParsePrefix(t, "0.0.0.0/0")
+	pl := &ip.PrefixList{
+		Name: "test",
+		Entries: []ip.PrefixListEntry{
+			{Permit: false, Addr: deny.Addr, Mask: deny.Mask, Ge: 30, Le: 32},
+			{Permit: true, Addr: any.Addr, Mask: any.Mask, Ge: 0, Le: 32},
+		},
+	}
+	if pl.Permits(mustParsePrefix(t, "192.168.0.1/32")) {
+		t.Fatal("192.168.0.1/32 should have been denied")
+	}
+	if pl.Permits(mustParsePrefix(t, "192.168.0.0/30")) {
+		t.Fatal("192.168.0.0/30 should have been denied")
+	}
+	if !pl.Permits(mustParsePrefix(t, "192.168.0.4/32")) {
+		t.Fatal("192.168.0.4/32 should have been permitted")
+	}
+	if !pl.Permits(mustParsePrefix(t, "192.168.0.0/29")) {
+		t.Fatal("192.168.0.0/29 should have been permitted")
+	}
+}
+
+func TestPrefixListImplicitDeny(t *testing.T) {
+	route := mustParsePrefix(t, "10.0.0.0/8")
+	pl := &ip.PrefixList{
+		Name:    "test",
+		Entries: []ip.PrefixListEntry{{Permit: true, Addr: route.Addr, Mask: route.Mask, Ge: 8, Le: 8}},
+	}
+	if !pl.Permits(route) {
+		t.Fatal("10.0.0.0/8 should have been permitted")
+	}
+	if pl.Permits(mustParsePrefix(t, "10.1.0.0/16")) {
+		t.Fatal("10.1.0.0/16 is longer than le and should have been denied")
+	}
+	if pl.Permits(mustParsePrefix(t, "11.0.0.0/8")) {
+		t.Fatal("11.0.0.0/8 should have been denied")
+	}
+}
+
+// Creates a node with n interfaces whose neighbours are UDP sockets we hold, so we can see what
+// it sends. Interface i is 10.0.i.1, and its neighbour 10.0.i.2. The node isn't run, so it only
+// sends in response to what we hand it.
+func newProbedNode(t *testing.T, n int) (*ip.Node, []*net.UDPConn) {
+	util.InitDebug(false)
+	peers := make([]*net.UDPConn, n)
+	lines := []string{"localhost 0"}
+	for i := range peers {
+		peer, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { peer.Close() })
+		peers[i] = peer
+		lines = append(lines, fmt.Sprintf("localhost %v 10.0.%v.1 10.0.%v.2", peer.LocalAddr().(*net.UDPAddr).Port, i, i))
+	}
+	lnxfile := filepath.Join(t.TempDir(), "node.lnx")
+	if err := os.WriteFile(lnxfile, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	node, err := ip.NewNode(lnxfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.UDPConn.Close() })
+	return node, peers
+}
+
+// Makes a RIP entry for a prefix.
+func ripEntry(t *testing.T, prefix string, cost uint32) ip.RIPEntry {
+	route := mustParsePrefix(t, prefix)
+	return ip.RIPEntry{Cost: cost, Addr: util.Int2IP(route.Addr), Mask: util.Int2IP(route.Mask)}
+}
+
+// Delivers a RIP response from the neighbour on an interface.
+func receiveRIPOn(t *testing.T, node *ip.Node, linkID int, entries ...ip.RIPEntry) {
+	packet := ip.NewIPPacket(200, ip.SerializeRIPData(ip.RIPData{Command: 2, Entries: entries}), util.DEFAULT_TTL,
+		net.ParseIP(fmt.Sprintf("10.0.%v.2", linkID)), net.ParseIP(fmt.Sprintf("10.0.%v.1", linkID)))
+	if err := ip.RIPHandler(node, packet, linkID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Reads the next RIP packet the node sent to a neighbour, as prefix -> cost.
+func nextRIP(t *testing.T, peer *net.UDPConn) map[ip.Route]uint32 {
+	buf := make([]byte, util.MAX_FRAME_SIZE)
+	peer.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		n, _, err := peer.ReadFromUDP(buf)
+		if err != nil {
+			t.Fatalf("no RIP packet: %v", err)
+		}
+		packet := &ip.IPPacket{}
+		packet.Deserialize(buf[:n])
+		if packet.Header.Proto != 200 {
+			continue
+		}
+		ripData, err := ip.DeserializeRIPData(packet.Data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		costs := make(map[ip.Route]uint32)
+		for _, entry := range ripData.Entries {
+			costs[ip.RIPEntryToRoute(&entry)] = entry.Cost
+		}
+		return costs
+	}
+}
+
+// Throws away whatever the node has sent to a neighbour so far.
+func drain(peer *net.UDPConn) {
+	buf := make([]byte, util.MAX_FRAME_SIZE)
+	for {
+		peer.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+		if _, _, err := peer.ReadFromUDP(buf); err != nil {
+			return
+		}
+	}
+}
+
+// Asks the node for its routes on an interface, the way a neighbour does, and returns what it
+// advertises there.
+func advertised(t *testing.T, node *ip.Node, peers []*net.UDPConn, linkID int) map[ip.Route]uint32 {
+	drain(peers[linkID])
+	packet := ip.NewIPPacket(200, ip.SerializeRIPData(ip.RIPData{Command: 1}), util.DEFAULT_TTL,
+		net.ParseIP(fmt.Sprintf("10.0.%v.2", linkID)), net.ParseIP(fmt.Sprintf("10.0.%v.1", linkID)))
+	if err := ip.RIPHandler(node, packet, linkID); err != nil {
+		t.Fatal(err)
+	}
+	return nextRIP(t, peers[linkID])
+}
+
+// Checks the cost a prefix is advertised with, or that it isn't advertised if cost is 0.
+func expectAdvertised(t *testing.T, costs map[ip.Route]uint32, prefix string, cost uint32) {
+	t.Helper()
+	got, exists := costs[mustParsePrefix(t, prefix)]
+	if cost == 0 && exists {
+		t.Errorf("expected %v not to be advertised, got cost %v", prefix, got)
+	} else if cost != 0 && got != cost {
+		t.Errorf("expected %v to be advertised with cost %v, got %v (advertised %v)", prefix, cost, got, exists)
+	}
+}
+
+func applyPolicy(t *testing.T, node *ip.Node, directive ...string) {
+	if err := node.ApplyPolicy(directive); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Checks the cost and tag a prefix is installed with, or that it isn't installed if cost is 0.
+func expectInstalled(t *testing.T, node *ip.Node, prefix string, cost uint32, tag uint32) {
+	t.Helper()
+	entry, exists := node.RoutingTable[mustParsePrefix(t, prefix)]
+	if cost == 0 && exists {
+		t.Errorf("expected %v not to be installed, got cost %v", prefix, entry.Cost)
+	} else if cost != 0 && (!exists || entry.Cost != cost || entry.Tag != tag) {
+		t.Errorf("expected %v to be installed with cost %v and tag %v, got %+v", prefix, cost, tag, entry)
+	}
+}
+
+func TestImportPolicy(t *testing.T) {
+	node, _ := newProbedNode(t, 3)
+	applyPolicy(t, node, "prefix-list", "in", "permit", "10.1.0.0/16", "le", "24")
+	applyPolicy(t, node, "import", "0", "prefix-list", "in")
+	applyPolicy(t, node, "import", "1", "offset", "3")
+	applyPolicy(t, node, "import", "2", "tag", "7")
+
+	receiveRIPOn(t, node, 0, ripEntry(t, "10.1.1.0/24", 1), ripEntry(t, "10.2.0.0/16", 1))
+	expectInstalled(t, node, "10.1.1.0/24", 2, 0)
+	expectInstalled(t, node, "10.2.0.0/16", 0, 0)
+
+	// The offset is added to the interface cost, and can make a route unreachable.
+	receiveRIPOn(t, node, 1, ripEntry(t, "10.3.0.0/16", 2), ripEntry(t, "10.3.1.0/24", 12))
+	expectInstalled(t, node, "10.3.0.0/16", 6, 0)
+	expectInstalled(t, node, "10.3.1.0/24", 0, 0)
+
+	receiveRIPOn(t, node, 2, ripEntry(t, "10.4.0.0/16", 1))
+	expectInstalled(t, node, "10.4.0.0/16", 2, 7)
+
+	// Without the prefix list, the denied route is learned.
+	applyPolicy(t, node, "import", "0", "prefix-list", "none")
+	receiveRIPOn(t, node, 0, ripEntry(t, "10.2.0.0/16", 1))
+	expectInstalled(t, node, "10.2.0.0/16", 2, 0)
+
+	// Tags sent by the neighbour are kept, unless the interface sets its own.
+	tagged := ripEntry(t, "10.5.0.0/16", 1)
+	tagged.Tag = 9
+	receiveRIPOn(t, node, 0, tagged)
+	expectInstalled(t, node, "10.5.0.0/16", 2, 9)
+	tagged = ripEntry(t, "10.6.0.0/16", 1)
+	tagged.Tag = 9
+	receiveRIPOn(t, node, 2, tagged)
+	expectInstalled(t, node, "10.6.0.0/16", 2, 7)
+}
+
+func TestExportPolicy(t *testing.T) {
+	node, peers := newProbedNode(t, 3)
+	applyPolicy(t, node, "import", "2", "tag", "7")
+	receiveRIPOn(t, node, 0, ripEntry(t, "10.1.1.0/24", 1))
+	receiveRIPOn(t, node, 1, ripEntry(t, "10.3.0.0/16", 1))
+	receiveRIPOn(t, node, 2, ripEntry(t, "10.4.0.0/16", 1))
+
+	applyPolicy(t, node, "prefix-list", "out", "deny", "10.3.0.0/16")
+	applyPolicy(t, node, "prefix-list", "out", "permit", "0.0.0.0/0", "le", "32")
+	applyPolicy(t, node, "export", "2", "prefix-list", "out")
+	costs := advertised(t, node, peers, 2)
+	expectAdvertised(t, costs, "10.3.0.0/16", 0)
+	expectAdvertised(t, costs, "10.1.1.0/24", 2)
+
+	// The offset is added after poison reverse, which stays at infinity.
+	applyPolicy(t, node, "export", "1", "offset", "2")
+	costs = advertised(t, node, peers, 1)
+	expectAdvertised(t, costs, "10.1.1.0/24", 4)
+	expectAdvertised(t, costs, "10.3.0.0/16", util.INFINITY)
+
+	// Routes tagged on import can be kept from other neighbours.
+	applyPolicy(t, node, "export", "0", "deny-tag", "7")
+	costs = advertised(t, node, peers, 0)
+	expectAdvertised(t, costs, "10.4.0.0/16", 0)
+	expectAdvertised(t, costs, "10.3.0.0/16", 2)
+	applyPolicy(t, node, "export", "0", "permit-tag", "7")
+	expectAdvertised(t, advertised(t, node, peers, 0), "10.4.0.0/16", 2)
+}
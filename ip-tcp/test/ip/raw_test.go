@@ -0,0 +1,152 @@
+package ip_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Creates and runs two nodes linked to each other over localhost.
+func newLinkedNodes(t *testing.T) (*ip.Node, *ip.Node) {
+	return newConfiguredLinkedNodes(t, nil)
+}
+
+// Creates and runs two linked nodes, letting configure change each node's config first.
+func newConfiguredLinkedNodes(t *testing.T, configure func(cfg *ip.Config)) (*ip.Node, *ip.Node) {
+	util.InitDebug(false)
+	ports := make([]int, 2)
+	for i := range ports {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports[i] = conn.LocalAddr().(*net.UDPAddr).Port
+		conn.Close()
+	}
+	nodes := make([]*ip.Node, 2)
+	for i := range nodes {
+		cfg := &ip.Config{
+			Listen: fmt.Sprintf("localhost:%v", ports[i]),
+			Interfaces: []ip.InterfaceConfig{{
+				Name:       "peer",
+				Remote:     fmt.Sprintf("localhost:%v", ports[1-i]),
+				Addr:       fmt.Sprintf("10.0.0.%v", i+1),
+				RemoteAddr: fmt.Sprintf("10.0.0.%v", 2-i),
+			}},
+		}
+		if configure != nil {
+			configure(cfg)
+		}
+		node, err := ip.NewNodeFromConfig(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { node.Close() })
+		node.Run(false)
+		nodes[i] = node
+	}
+	// Wait for RIP to give A a route to B.
+	route, _ := ip.ParsePrefix("10.0.0.2/32")
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if _, exists := nodes[0].Routes()[route]; exists {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no route to peer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return nodes[0], nodes[1]
+}
+
+// Reads a packet from a raw socket, failing the test if none arrives.
+func readRaw(t *testing.T, sock *ip.RawSocket) ([]byte, ip.RawInfo) {
+	type result struct {
+		data []byte
+		info ip.RawInfo
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		buf := make([]byte, 100)
+		n, info, err := sock.ReadFrom(buf)
+		results <- result{buf[:n], info, err}
+	}()
+	select {
+	case r := <-results:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		return r.data, r.info
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for raw packet")
+	}
+	return nil, ip.RawInfo{}
+}
+
+func TestRawSockets(t *testing.T) {
+	nodeA, nodeB := newLinkedNodes(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	sender, err := nodeA.OpenRaw(150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := nodeB.OpenRaw(150)
+	second, _ := nodeB.OpenRaw(150)
+
+	// A protocol nobody handles is dropped rather than crashing the node.
+	if err := nodeA.SendData(addrB, 151, []byte("nobody")); err != nil {
+		t.Fatal(err)
+	}
+	// Every socket on a protocol gets its own copy.
+	if _, err := sender.WriteTo([]byte("hello"), addrB); err != nil {
+		t.Fatal(err)
+	}
+	for _, sock := range []*ip.RawSocket{first, second} {
+		data, info := readRaw(t, sock)
+		if string(data) != "hello" || !info.Header.Src.Equal(addrA) || info.Header.Proto != 150 || info.Interface != 0 {
+			t.Fatalf("unexpected packet %q %+v", data, info)
+		}
+	}
+
+	// With the header included, the packet is sent as given.
+	second.Close()
+	sender.SetHeaderIncluded(true)
+	packet := ip.NewIPPacket(150, []byte("crafted"), 5, net.ParseIP("10.9.9.9"), addrB)
+	if _, err := sender.WriteTo(packet.Serialize(), nil); err != nil {
+		t.Fatal(err)
+	}
+	data, info := readRaw(t, first)
+	if string(data) != "crafted" || info.Header.Ttl != 5 || !info.Header.Src.Equal(net.ParseIP("10.9.9.9")) {
+		t.Fatalf("unexpected packet %q %+v", data, info)
+	}
+	// Headers we can't send as given are refused, rather than mangled.
+	for name, mangle := range map[string]func(buf []byte){
+		"version":       func(buf []byte) { buf[0] = 6<<4 | 5 },
+		"header length": func(buf []byte) { buf[0] = 4<<4 | 6 },
+		"total length":  func(buf []byte) { copy(buf[2:4], util.Htons(uint16(len(buf)+1))) },
+	} {
+		buf := packet.Serialize()
+		copy(buf[10:12], []byte{0, 0})
+		mangle(buf)
+		if _, err := sender.WriteTo(buf, nil); err == nil {
+			t.Errorf("expected a packet with a bad %v to be refused", name)
+		}
+	}
+	if _, _, err := second.ReadFrom(make([]byte, 10)); err != ip.ErrRawClosed {
+		t.Fatalf("expected ErrRawClosed from a closed socket, got %v", err)
+	}
+
+	// Sniffing ICMP leaves the built-in handler working.
+	sniffer, _ := nodeB.OpenRaw(1)
+	if _, err := nodeA.Traceroute(addrB); err != nil {
+		t.Fatal(err)
+	}
+	if _, info := readRaw(t, sniffer); info.Header.Proto != 1 {
+		t.Fatalf("unexpected packet %+v", info)
+	}
+}
@@ -0,0 +1,61 @@
+package ip_test
+
+import (
+	"net"
+	"testing"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Delivers a RIP response from the peer on interface 0.
+func receiveRIP(t *testing.T, node *ip.Node, prefix string, cost uint32) {
+	route, err := ip.ParsePrefix(prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ripData := ip.RIPData{
+		Command: 2,
+		Entries: []ip.RIPEntry{{Cost: cost, Addr: util.Int2IP(route.Addr), Mask: util.Int2IP(route.Mask)}},
+	}
+	packet := ip.NewIPPacket(200, ip.SerializeRIPData(ripData), util.DEFAULT_TTL, net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.1"))
+	if err := ip.RIPHandler(node, packet, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStaticBackupTakesOver(t *testing.T) {
+	node := newTestNode(t)
+	route, _ := ip.ParsePrefix("10.9.0.0/16")
+	// A floating static route loses to RIP until RIP withdraws the prefix.
+	if err := node.ApplyPolicy([]string{"route", "10.9.0.0/16", "via", "10.0.0.2", "cost", "5"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := node.ApplyPolicy([]string{"distance", "static", "200"}); err != nil {
+		t.Fatal(err)
+	}
+	receiveRIP(t, node, "10.9.0.0/16", 1)
+	if entry := node.Routes()[route]; entry.Source != ip.SRC_RIP || entry.Cost != 2 {
+		t.Fatalf("expected rip route with cost 2, got %v route with cost %v", entry.Source, entry.Cost)
+	}
+	receiveRIP(t, node, "10.9.0.0/16", util.INFINITY)
+	if entry := node.Routes()[route]; entry.Source != ip.SRC_STATIC || entry.Cost != 5 {
+		t.Fatalf("expected static route with cost 5, got %v route with cost %v", entry.Source, entry.Cost)
+	}
+	// Without the static route, the prefix is gone.
+	if err := node.ApplyPolicy([]string{"no", "route", "10.9.0.0/16", "via", "10.0.0.2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists := node.Routes()[route]; exists {
+		t.Fatal("expected no route")
+	}
+}
+
+func TestConnectedBeatsRIP(t *testing.T) {
+	node := newTestNode(t)
+	receiveRIP(t, node, "10.0.0.1/32", 1)
+	route, _ := ip.ParsePrefix("10.0.0.1/32")
+	if entry := node.Routes()[route]; entry.Source != ip.SRC_CONNECTED || entry.Cost != 0 {
+		t.Fatalf("expected connected route, got %v route with cost %v", entry.Source, entry.Cost)
+	}
+}
@@ -0,0 +1,50 @@
+package ip_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+)
+
+func TestWarmRestartRestoresStaleRoutes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "node.state")
+	route, _ := ip.ParsePrefix("10.9.0.0/16")
+
+	// Learn a route and save it.
+	before := newTestNode(t)
+	if err := before.SetStateFile(path, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	receiveRIP(t, before, "10.9.0.0/16", 2)
+	if err := before.SaveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A restarted node comes back with the route marked stale.
+	after := newTestNode(t)
+	if err := after.SetStateFile(path, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	entry, exists := after.Routes()[route]
+	if !exists || entry.Source != ip.SRC_RIP || entry.Cost != 3 || !entry.Stale {
+		t.Fatalf("expected stale rip route with cost 3, got %+v", entry)
+	}
+	if remaining := time.Until(entry.Expires); remaining <= 0 {
+		t.Fatalf("expected route to have lifetime left, got %v", remaining)
+	}
+
+	// The neighbour advertising it again refreshes it.
+	receiveRIP(t, after, "10.9.0.0/16", 2)
+	if entry := after.Routes()[route]; entry.Stale {
+		t.Fatal("expected route to be refreshed")
+	}
+}
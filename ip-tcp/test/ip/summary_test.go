@@ -0,0 +1,56 @@
+package ip_test
+
+import (
+	"testing"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+func TestSummaryReplacesCoveredRoutes(t *testing.T) {
+	node, peers := newProbedNode(t, 2)
+	applyPolicy(t, node, "summary", "10.1.0.0/16")
+	receiveRIPOn(t, node, 0, ripEntry(t, "10.1.1.0/24", 3), ripEntry(t, "10.1.2.0/24", 1))
+
+	// The summary takes the lowest contributing cost, and the covered routes aren't advertised.
+	costs := advertised(t, node, peers, 1)
+	expectAdvertised(t, costs, "10.1.0.0/16", 2)
+	expectAdvertised(t, costs, "10.1.1.0/24", 0)
+	expectAdvertised(t, costs, "10.1.2.0/24", 0)
+	// Every contributor was learned on interface 0, so it gets the summary poisoned back.
+	costs = advertised(t, node, peers, 0)
+	expectAdvertised(t, costs, "10.1.0.0/16", util.INFINITY)
+	expectAdvertised(t, costs, "10.1.1.0/24", 0)
+
+	// Removing the summary advertises the routes themselves again.
+	applyPolicy(t, node, "no", "summary", "10.1.0.0/16")
+	costs = advertised(t, node, peers, 1)
+	expectAdvertised(t, costs, "10.1.0.0/16", 0)
+	expectAdvertised(t, costs, "10.1.1.0/24", 4)
+	expectAdvertised(t, costs, "10.1.2.0/24", 2)
+}
+
+func TestSummaryOnOneInterface(t *testing.T) {
+	node, peers := newProbedNode(t, 3)
+	applyPolicy(t, node, "summary", "10.1.0.0/16", "on", "if", "1")
+	receiveRIPOn(t, node, 0, ripEntry(t, "10.1.1.0/24", 1))
+
+	costs := advertised(t, node, peers, 1)
+	expectAdvertised(t, costs, "10.1.0.0/16", 2)
+	expectAdvertised(t, costs, "10.1.1.0/24", 0)
+	costs = advertised(t, node, peers, 2)
+	expectAdvertised(t, costs, "10.1.0.0/16", 0)
+	expectAdvertised(t, costs, "10.1.1.0/24", 2)
+}
+
+func TestSummaryGoesWithLastContributor(t *testing.T) {
+	node, peers := newProbedNode(t, 2)
+	applyPolicy(t, node, "summary", "10.1.0.0/16")
+	receiveRIPOn(t, node, 0, ripEntry(t, "10.1.1.0/24", 3), ripEntry(t, "10.1.2.0/24", 1))
+	// The neighbour on interface 1 advertises our summary back to us. That isn't a contributor.
+	receiveRIPOn(t, node, 1, ripEntry(t, "10.1.0.0/16", 2))
+
+	receiveRIPOn(t, node, 0, ripEntry(t, "10.1.2.0/24", util.INFINITY))
+	expectAdvertised(t, advertised(t, node, peers, 1), "10.1.0.0/16", 4)
+	receiveRIPOn(t, node, 0, ripEntry(t, "10.1.1.0/24", util.INFINITY))
+	expectAdvertised(t, advertised(t, node, peers, 1), "10.1.0.0/16", 0)
+}
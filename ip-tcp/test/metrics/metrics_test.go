@@ -0,0 +1,54 @@
+package metrics_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	metrics "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/metrics"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	util.InitDebug(false)
+	file, err := ioutil.TempFile("", "*.lnx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("localhost 0\nlocalhost 1 10.0.0.1 10.0.0.2\n")
+	file.Close()
+	node, err := ip.NewNode(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer node.UDPConn.Close()
+	driver := tcp.InitDriver(node)
+	if _, err := driver.Listen(node.LocalInterfaces[0].Addr, 9000); err != nil {
+		t.Fatal(err)
+	}
+	// Bring the interface down and up to churn the routing table.
+	node.SetInterfaceUp(0, false)
+	node.SetInterfaceUp(0, true)
+
+	var buf bytes.Buffer
+	metrics.Write(&buf, node, driver)
+	out := buf.String()
+	for _, expected := range []string{
+		"# TYPE node_interface_tx_packets_total counter\n",
+		`node_interface_up{if="0",name="if0"} 1` + "\n",
+		"node_routes 1\n",
+		`node_route_changes_total{type="added"} 2` + "\n",
+		`node_route_changes_total{type="withdrawn"} 1` + "\n",
+		`node_tcp_connections{state="LISTEN"} 1` + "\n",
+		`node_tcp_connections{state="ESTABLISHED"} 0` + "\n",
+	} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("expected %q in:\n%v", expected, out)
+		}
+	}
+}
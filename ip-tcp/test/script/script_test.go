@@ -0,0 +1,143 @@
+package script_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	script "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/script"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Creates a runner for a node with a single interface to a peer that doesn't exist.
+func newTestRunner(t *testing.T) *script.Runner {
+	util.InitDebug(false)
+	file, err := ioutil.TempFile("", "*.lnx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("localhost 0\nlocalhost 1 10.0.0.1 10.0.0.2\n")
+	file.Close()
+	node, err := ip.NewNode(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { node.UDPConn.Close() })
+	runner := script.NewRunner(node, tcp.InitDriver(node))
+	runner.Timeout = 100 * time.Millisecond
+	return runner
+}
+
+func TestScriptCountsFailures(t *testing.T) {
+	runner := newTestRunner(t)
+	failures, err := runner.Run(strings.NewReader(`
+# Our own address is a connected route.
+wait-route 10.0.0.1/32
+assert-route 10.0.0.1/32 cost 0
+down 0
+assert-route 10.0.0.1/32 none
+assert-route 10.0.0.1/32
+wait-route 10.0.0.1/32
+up 0
+assert-route 10.0.0.1/32 cost 0 via 10.0.0.2
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failures != 2 {
+		t.Fatalf("expected 2 failures, got %v", failures)
+	}
+}
+
+func TestScriptStopsAtQuit(t *testing.T) {
+	runner := newTestRunner(t)
+	failures, err := runner.Run(strings.NewReader("q\nassert-route 10.9.0.0/16\n"))
+	if err != nil || failures != 0 {
+		t.Fatalf("expected the script to stop cleanly, got %v failures, error %v", failures, err)
+	}
+}
+
+func TestMalformedScript(t *testing.T) {
+	runner := newTestRunner(t)
+	if _, err := runner.Run(strings.NewReader("sleep soon\n")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := runner.Run(strings.NewReader("assert-route 10.0.0.1/32 cost\n")); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// Creates and runs a node linked to a neighbour over localhost, with a TCP driver. There is no way
+// to stop a running node, so it runs until the test binary exits.
+func newLinkedDriver(t *testing.T, port int, peerPort int, addr string, peerAddr string) (*ip.Node, *tcp.Driver) {
+	lnxfile := filepath.Join(t.TempDir(), "node.lnx")
+	lnx := fmt.Sprintf("localhost %v\nlocalhost %v %v %v\n", port, peerPort, addr, peerAddr)
+	if err := os.WriteFile(lnxfile, []byte(lnx), 0644); err != nil {
+		t.Fatal(err)
+	}
+	node, err := ip.NewNode(lnxfile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := tcp.InitDriver(node)
+	node.RegisterHandler(6, driver.TCPHandler)
+	node.Run(false)
+	return node, driver
+}
+
+func TestExpectReadTimeoutKeepsLaterData(t *testing.T) {
+	util.InitDebug(false)
+	ports := make([]int, 2)
+	for i := range ports {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ports[i] = conn.LocalAddr().(*net.UDPAddr).Port
+		conn.Close()
+	}
+	nodeA, driverA := newLinkedDriver(t, ports[0], ports[1], "10.0.0.1", "10.0.0.2")
+	_, driverB := newLinkedDriver(t, ports[1], ports[0], "10.0.0.2", "10.0.0.1")
+	l, err := driverA.Listen(net.ParseIP("10.0.0.1"), 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conns := make(chan *tcp.Conn, 1)
+	go func() {
+		c, err := driverB.Connect(net.ParseIP("10.0.0.2"), driverB.EphemeralPort(), net.ParseIP("10.0.0.1"), 9000)
+		if err != nil {
+			t.Error(err)
+		}
+		conns <- c
+	}()
+	server, err := l.AcceptConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := <-conns
+	if client == nil {
+		t.FailNow()
+	}
+	runner := script.NewRunner(nodeA, driverA)
+	runner.Timeout = 100 * time.Millisecond
+
+	// Waiting for more than arrives fails the step, but doesn't go on reading into the next one.
+	client.Write([]byte("abc"))
+	expectRead := fmt.Sprintf("expect-read %v", server.SocketID())
+	if failures, err := runner.Run(strings.NewReader(expectRead + " abcdef\n")); err != nil || failures != 1 {
+		t.Fatalf("expected the read to time out, got %v failures, error %v", failures, err)
+	}
+	client.Write([]byte("ghi"))
+	time.Sleep(runner.Timeout)
+	if failures, err := runner.Run(strings.NewReader(expectRead + " ghi\n")); err != nil || failures != 0 {
+		t.Fatalf("expected to read what was sent after the timeout, got %v failures, error %v", failures, err)
+	}
+}
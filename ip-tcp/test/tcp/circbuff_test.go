@@ -3,6 +3,7 @@ package tcp_test
 import (
 	"bytes"
 	"math"
+	"reflect"
 	"testing"
 
 	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
@@ -275,6 +276,98 @@ func TestCircBuffFragmentsLeftOver(t *testing.T) {
 	}
 }
 
+func TestCircBuffFragmentsOutOfOrder(t *testing.T) {
+	cb := tcp.NewCircBuff(16, 128)
+	// Fragments arrive backwards, one of them twice.
+	for _, seq := range []uint32{136, 134, 136, 132, 130} {
+		_, err := cb.PushData(seq, []byte{byte(seq - 128), byte(seq - 127)})
+		t.Log(cb.PrintState())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cb.GetWindowSize(false) != 16 {
+		t.Fatalf("should have had window size 16, had window size %d", cb.GetWindowSize(false))
+	}
+
+	_, err := cb.PushData(128, []byte{0, 1})
+	t.Log(cb.PrintState())
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := cb.PullDataAll()
+	if !bytes.Equal(data, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}) {
+		t.Log(data)
+		t.Fatal("pulled wrong data")
+	}
+}
+
+func TestCircBuffSACKBlocks(t *testing.T) {
+	cb := tcp.NewCircBuff(32, 100)
+	for _, f := range []struct {
+		seq  uint32
+		data []byte
+	}{{104, []byte{4, 5}}, {106, []byte{6, 7}}, {112, []byte{12, 13}}, {110, []byte{10}}} {
+		_, err := cb.PushData(f.seq, f.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Log(cb.PrintState())
+	// The latest fragment comes first, and touching fragments are merged.
+	expected := []tcp.SACKBlock{{Left: 110, Right: 111}, {Left: 104, Right: 108}, {Left: 112, Right: 114}}
+	if blocks := cb.SACKBlocks(4); !reflect.DeepEqual(blocks, expected) {
+		t.Fatalf("expected blocks %v, got %v", expected, blocks)
+	}
+	if blocks := cb.SACKBlocks(2); !reflect.DeepEqual(blocks, expected[:2]) {
+		t.Fatalf("expected blocks %v, got %v", expected[:2], blocks)
+	}
+
+	_, err := cb.PushData(100, []byte{0, 1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Data the ack covers is no longer SACKed.
+	expected = []tcp.SACKBlock{{Left: 110, Right: 111}, {Left: 112, Right: 114}}
+	if blocks := cb.SACKBlocks(4); !reflect.DeepEqual(blocks, expected) {
+		t.Fatalf("expected blocks %v, got %v", expected, blocks)
+	}
+}
+
+func TestCircBuffGrow(t *testing.T) {
+	cb := tcp.NewCircBuff(8, 0)
+	_, err := cb.PushData(0, []byte{0, 1, 2, 3, 4, 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb.PullData(4)
+	// Wrap around the end of the buffer, with a fragment after a hole.
+	_, err = cb.PushData(6, []byte{6, 7, 8, 9})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = cb.PushData(11, []byte{11})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(cb.PrintState())
+
+	cb.Grow(16)
+	t.Log(cb.PrintState())
+	if cb.GetWindowSize(false) != 10 {
+		t.Fatalf("should have had window size 10, had window size %d", cb.GetWindowSize(false))
+	}
+	_, err = cb.PushData(10, []byte{10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := cb.PullDataAll()
+	if !bytes.Equal(data, []byte{4, 5, 6, 7, 8, 9, 10, 11}) {
+		t.Log(data)
+		t.Fatal("pulled wrong data")
+	}
+}
+
 func TestCircBuffPullTooMuch(t *testing.T) {
 	cb := tcp.NewCircBuff(10, 0)
 	_, err := cb.PushData(0, []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9})
@@ -0,0 +1,137 @@
+package tcp_test
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Finds a free UDP port on localhost.
+func freePort(t *testing.T) int {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// Creates and runs a node with one interface to a peer, dropping the given fraction of packets.
+func newLinkedNode(t *testing.T, port int, peerPort int, addr string, peerAddr string, loss float64) (*ip.Node, *tcp.Driver) {
+	return startNode(t, &ip.Config{
+		Listen: fmt.Sprintf("localhost:%v", port),
+		Interfaces: []ip.InterfaceConfig{
+			{Name: "peer", Remote: fmt.Sprintf("localhost:%v", peerPort), Addr: addr, RemoteAddr: peerAddr, Loss: loss},
+		},
+	})
+}
+
+// Creates and runs a node with a TCP driver from a config.
+func startNode(t *testing.T, cfg *ip.Config) (*ip.Node, *tcp.Driver) {
+	node, err := ip.NewNodeFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	driver := tcp.InitDriver(node)
+	node.RegisterHandler(6, driver.TCPHandler)
+	node.Run(false)
+	return node, driver
+}
+
+// Waits for RIP to give a node a route to a prefix.
+func waitForRoute(t *testing.T, node *ip.Node, prefix string) {
+	route, _ := ip.ParsePrefix(prefix)
+	for deadline := time.Now().Add(2 * time.Second); ; {
+		if _, exists := node.Routes()[route]; exists {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("no route to peer")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Waits for an error from a pending operation.
+func expectClosed(t *testing.T, what string, errs chan error) {
+	select {
+	case err := <-errs:
+		if err != tcp.ErrClosed {
+			t.Errorf("expected pending %v to fail with ErrClosed, got %v", what, err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Errorf("pending %v never returned", what)
+	}
+}
+
+func TestCloseStopsEverything(t *testing.T) {
+	util.InitDebug(false)
+	before := runtime.NumGoroutine()
+	portA, portB := freePort(t), freePort(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0)
+	nodeB, driverB := newLinkedNode(t, portB, portA, "10.0.0.2", "10.0.0.1", 0)
+
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	// Open a connection and leave a read, a write and an accept pending.
+	listener, err := driverB.Listen(addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := make(chan *tcp.Conn, 1)
+	go func() {
+		c, _ := listener.AcceptConn()
+		accepted <- c
+	}()
+	client, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-accepted
+	readErrs, writeErrs, acceptErrs := make(chan error, 1), make(chan error, 1), make(chan error, 1)
+	go func() {
+		_, err := client.Read(make([]byte, 10), 10, true)
+		readErrs <- err
+	}()
+	server.Shutdown(1)
+	go func() {
+		_, err := server.Write([]byte("blocked"))
+		writeErrs <- err
+	}()
+	go func() {
+		_, err := listener.AcceptConn()
+		acceptErrs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	driverA.Close()
+	driverB.Close()
+	nodeA.Close()
+	nodeB.Close()
+	expectClosed(t, "read", readErrs)
+	expectClosed(t, "write", writeErrs)
+	expectClosed(t, "accept", acceptErrs)
+	if _, err := client.Write([]byte("late")); err != tcp.ErrClosed {
+		t.Errorf("expected a write after close to fail with ErrClosed, got %v", err)
+	}
+	if _, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000); err != tcp.ErrClosed {
+		t.Errorf("expected connect after close to fail with ErrClosed, got %v", err)
+	}
+
+	// Everything the stacks started should be gone.
+	for deadline := time.Now().Add(2 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("%v goroutines leaked:\n%s", runtime.NumGoroutine()-before, buf[:n])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,91 @@
+package tcp_test
+
+import (
+	"bytes"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Sends data over a link that drops some of the sender's packets, using the given congestion
+// control, and returns the sender's socket info from before and after.
+func transferOverLossyLink(t *testing.T, cc string) (before tcp.SocketInfo, after tcp.SocketInfo) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0.05)
+	nodeB, driverB := newLinkedNode(t, portB, portA, "10.0.0.2", "10.0.0.1", 0)
+	defer nodeA.Close()
+	defer nodeB.Close()
+	defer driverA.Close()
+	defer driverB.Close()
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+	if err := driverA.SetCongestionControl(cc); err != nil {
+		t.Fatal(err)
+	}
+
+	listener, err := driverB.Listen(addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.AcceptConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	before = client.Info()
+
+	data := make([]byte, 256*1024)
+	rand.Read(data)
+	go client.Write(data)
+	received := make([]byte, len(data))
+	if n, err := server.Read(received, uint32(len(received)), true); err != nil || int(n) != len(data) {
+		t.Fatalf("read %v bytes: %v", n, err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("received data differs from what was sent")
+	}
+	after = client.Info()
+	if after.Retransmits == 0 {
+		t.Error("expected retransmissions on a lossy link")
+	}
+	return before, after
+}
+
+func TestTransferOverLossyLink(t *testing.T) {
+	for _, cc := range tcp.CongestionControls() {
+		t.Run(cc, func(t *testing.T) {
+			before, info := transferOverLossyLink(t, cc)
+			if info.Congestion != cc || info.CongestionState == "" {
+				t.Errorf("expected %v to report its state, got %q %q", cc, info.Congestion, info.CongestionState)
+			}
+			switch cc {
+			case "reno", "cubic":
+				// Losses should have cut the slow start threshold.
+				if info.Cwnd == before.Cwnd {
+					t.Errorf("expected the congestion window to move from %v", before.Cwnd)
+				}
+				if info.Ssthresh >= util.TCP_MAX_CWND || info.Ssthresh < info.MSS {
+					t.Errorf("expected loss to set ssthresh, got %v", info.Ssthresh)
+				}
+			case "bbr":
+				if !strings.Contains(info.CongestionState, "btl_bw=") {
+					t.Errorf("expected a bandwidth estimate, got %q", info.CongestionState)
+				}
+			}
+		})
+	}
+}
+
+func TestUnknownCongestionControl(t *testing.T) {
+	if _, err := tcp.NewCongestionControl("vegas", 1024); err == nil {
+		t.Error("expected an error for an unknown algorithm")
+	}
+}
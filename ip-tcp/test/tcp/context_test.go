@@ -0,0 +1,114 @@
+package tcp_test
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+func TestContextsAndNonBlocking(t *testing.T) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0)
+	nodeB, driverB := newLinkedNode(t, portB, portA, "10.0.0.2", "10.0.0.1", 0)
+	defer nodeA.Close()
+	defer nodeB.Close()
+	defer driverA.Close()
+	defer driverB.Close()
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	// Nobody answers SYNs to this address, so only the context ends the handshake.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := driverA.ConnectContext(ctx, addrA, driverA.EphemeralPort(), net.ParseIP("10.0.0.3"), 9000); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= util.TCP_SYN_TIMEOUT_DURATION {
+		t.Errorf("expected the context to end the handshake early, took %v", elapsed)
+	}
+	waitForSockets(t, driverA, 0)
+
+	listener, err := driverB.Listen(addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listener.TryAccept(); err != tcp.ErrWouldBlock {
+		t.Errorf("expected TryAccept to fail with ErrWouldBlock, got %v", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := listener.AcceptContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected context.DeadlineExceeded, got %v", err)
+	}
+
+	// Connect returns as soon as the handshake completes, not on its retry schedule.
+	start = time.Now()
+	client, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= util.TCP_SYN_TIMEOUT_DURATION {
+		t.Errorf("expected Connect to return once established, took %v", elapsed)
+	}
+	var server *tcp.Conn
+	for deadline := time.Now().Add(2 * time.Second); server == nil; {
+		if server, err = listener.TryAccept(); err != nil && err != tcp.ErrWouldBlock {
+			t.Fatal(err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection never became ready to accept")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	buf := make([]byte, 10)
+	if _, err := server.TryRead(buf, 10); err != tcp.ErrWouldBlock {
+		t.Errorf("expected TryRead to fail with ErrWouldBlock, got %v", err)
+	}
+	for written := uint32(0); written == 0; {
+		if written, err = client.TryWrite([]byte("hi")); err != nil && err != tcp.ErrWouldBlock {
+			t.Fatal(err)
+		}
+	}
+	var n uint32
+	for deadline := time.Now().Add(2 * time.Second); n == 0; {
+		if n, err = server.TryRead(buf, 10); err != nil && err != tcp.ErrWouldBlock {
+			t.Fatal(err)
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("data never arrived")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if string(buf[:n]) != "hi" {
+		t.Errorf("expected to read hi, got %q", buf[:n])
+	}
+
+	// Cancelling the context fails a pending read, and the connection still works afterwards.
+	ctx, cancel = context.WithCancel(context.Background())
+	readErrs := make(chan error, 1)
+	go func() {
+		_, err := server.ReadContext(ctx, buf, 10, true)
+		readErrs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-readErrs:
+		if err != context.Canceled {
+			t.Errorf("expected context.Canceled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("pending read ignored the context")
+	}
+	client.Write([]byte("again"))
+	if n, err := server.Read(buf, 5, true); err != nil || string(buf[:n]) != "again" {
+		t.Errorf("expected to read again, got %q, %v", buf[:n], err)
+	}
+}
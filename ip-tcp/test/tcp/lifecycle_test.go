@@ -0,0 +1,96 @@
+package tcp_test
+
+import (
+	"io"
+	"net"
+	"runtime"
+	"testing"
+	"time"
+
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Waits until the driver lists exactly n sockets.
+func waitForSockets(t *testing.T, d *tcp.Driver, n int) {
+	for deadline := time.Now().Add(2 * time.Second); len(d.Sockets()) != n; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v sockets, got %+v", n, d.Sockets())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestClosedSocketsAreReclaimed(t *testing.T) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0)
+	nodeB, driverB := newLinkedNode(t, portB, portA, "10.0.0.2", "10.0.0.1", 0)
+	defer nodeA.Close()
+	defer nodeB.Close()
+	defer driverA.Close()
+	defer driverB.Close()
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	listener, err := driverB.Listen(addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect := func() (*tcp.Conn, *tcp.Conn) {
+		client, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		server, err := listener.AcceptConn()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client, server
+	}
+
+	// The client closes first, so the server's side goes through LAST_ACK to CLOSED, and is
+	// reclaimed once the server has closed it too.
+	client, server := connect()
+	client.Close()
+	if _, err := server.Read(make([]byte, 10), 10, true); err != io.EOF {
+		t.Fatalf("expected EOF, got %v", err)
+	}
+	serverID := server.SocketID()
+	server.Close()
+	waitForSockets(t, driverB, 1)
+	if _, err := driverB.Socket(serverID); err == nil {
+		t.Errorf("expected socket %v to be gone", serverID)
+	}
+
+	// The freed descriptor is reused. The peer resetting the connection tears it down, but it
+	// stays listed until the application closes it.
+	before := runtime.NumGoroutine()
+	client, server = connect()
+	if server.SocketID() != serverID {
+		t.Errorf("expected socket %v to be reused, got %v", serverID, server.SocketID())
+	}
+	client.Abort()
+	if _, err := server.Read(make([]byte, 10), 10, true); err != tcp.ErrReset {
+		t.Fatalf("expected ErrReset, got %v", err)
+	}
+	if c, err := driverB.Socket(serverID); err != nil || c != server {
+		t.Errorf("expected socket %v to stay open until closed, got %v", serverID, err)
+	}
+	waitForSockets(t, driverA, 1) // Only the first client, still in TIME_WAIT.
+	server.Close()
+	if _, err := driverB.Socket(serverID); err == nil {
+		t.Errorf("expected socket %v to be gone", serverID)
+	}
+	waitForSockets(t, driverB, 1)
+
+	// Both connections' goroutines are gone.
+	for deadline := time.Now().Add(2 * time.Second); runtime.NumGoroutine() > before; {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			n := runtime.Stack(buf, true)
+			t.Fatalf("%v goroutines leaked:\n%s", runtime.NumGoroutine()-before, buf[:n])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,125 @@
+package tcp_test
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+func TestHTTPOverVirtualNetwork(t *testing.T) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0)
+	nodeB, driverB := newLinkedNode(t, portB, portA, "10.0.0.2", "10.0.0.1", 0)
+	defer nodeA.Close()
+	defer nodeB.Close()
+	defer driverA.Close()
+	defer driverB.Close()
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	listener, err := driverB.Listen(net.ParseIP("10.0.0.2"), 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "hello %v from %v", r.URL.Path, r.RemoteAddr)
+	})}
+	go server.Serve(tcp.NewNetListener(listener))
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, address string) (net.Conn, error) {
+			return driverA.Dial(ctx, address)
+		},
+	}}
+	defer client.CloseIdleConnections()
+	var remote string
+	// The second request reuses the connection.
+	for _, path := range []string{"/a", "/b"} {
+		resp, err := client.Get("http://10.0.0.2" + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if remote == "" {
+			fmt.Sscanf(string(body), "hello /a from %s", &remote)
+		}
+		if expected := fmt.Sprintf("hello %v from %v", path, remote); string(body) != expected {
+			t.Errorf("expected %q, got %q", expected, body)
+		}
+	}
+	if host, _, _ := net.SplitHostPort(remote); host != "10.0.0.1" {
+		t.Errorf("expected the client's virtual address, got %v", remote)
+	}
+}
+
+func TestNetConnDeadlines(t *testing.T) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0)
+	nodeB, driverB := newLinkedNode(t, portB, portA, "10.0.0.2", "10.0.0.1", 0)
+	defer nodeA.Close()
+	defer nodeB.Close()
+	defer driverA.Close()
+	defer driverB.Close()
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	listener, err := driverB.Listen(net.ParseIP("10.0.0.2"), 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := driverA.Dial(context.Background(), "10.0.0.2:9000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := tcp.NewNetListener(listener).Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.RemoteAddr().String() != "10.0.0.2:9000" || server.LocalAddr().String() != "10.0.0.2:9000" {
+		t.Errorf("expected virtual addresses, got %v and %v", client.RemoteAddr(), server.LocalAddr())
+	}
+
+	// A read with nothing to read times out, and the connection still works afterwards, even if
+	// data arrives before the next read.
+	buf := make([]byte, 10)
+	server.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, err = server.Read(buf)
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	server.SetReadDeadline(time.Time{})
+	client.Write([]byte("hi"))
+	time.Sleep(50 * time.Millisecond)
+	if n, err := server.Read(buf); err != nil || string(buf[:n]) != "hi" {
+		t.Errorf("expected to read hi, got %q, %v", buf[:n], err)
+	}
+
+	// Closing fails a pending read at once.
+	readErrs := make(chan error, 1)
+	go func() {
+		_, err := server.Read(buf)
+		readErrs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	server.Close()
+	expectClosed(t, "read", readErrs)
+	client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := driverA.Dial(ctx, "10.0.0.2:9001"); err != context.Canceled {
+		t.Errorf("expected a cancelled dial to fail with context.Canceled, got %v", err)
+	}
+}
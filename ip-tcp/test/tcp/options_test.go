@@ -0,0 +1,98 @@
+package tcp_test
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+func TestOptionsRoundTrip(t *testing.T) {
+	opts := tcp.TCPOptions{MSS: 1460, Timestamps: true, TSVal: 123456, TSEcr: 654321, WindowScaling: true, WindowScale: 7,
+		SACKPermitted: true, SACKBlocks: []tcp.SACKBlock{{Left: 100, Right: 200}}}
+	buf := opts.Serialize()
+	if len(buf)%4 != 0 || len(buf) > tcp.OPT_MAX_LEN {
+		t.Fatalf("expected options padded to 4 bytes, got %v", len(buf))
+	}
+	parsed := tcp.TCPOptions{}
+	parsed.Deserialize(buf)
+	if !reflect.DeepEqual(parsed, opts) {
+		t.Errorf("expected %+v, got %+v", opts, parsed)
+	}
+}
+
+func TestOptionsSkipUnknown(t *testing.T) {
+	// Two experimental options, which we don't know, around an MSS.
+	buf := []byte{253, 3, 7, 254, 2, 2, 4, 0x04, 0x00, 1, 1, 0}
+	opts := tcp.TCPOptions{}
+	opts.Deserialize(buf)
+	if opts.MSS != 1024 || opts.Timestamps || opts.WindowScaling {
+		t.Errorf("expected only the MSS, got %+v", opts)
+	}
+
+	// A bad length stops parsing without losing what came before.
+	opts.Deserialize([]byte{2, 4, 0x02, 0x00, 8, 0, 1, 1})
+	if opts.MSS != 512 {
+		t.Errorf("expected the MSS before a bad option, got %+v", opts)
+	}
+	opts.Deserialize([]byte{8, 10, 0, 0, 0, 1})
+	if opts.Timestamps {
+		t.Errorf("expected a truncated timestamp to be ignored, got %+v", opts)
+	}
+}
+
+func TestNegotiateOptions(t *testing.T) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0)
+	nodeB, driverB := startNode(t, &ip.Config{
+		Listen: fmt.Sprintf("localhost:%v", portB),
+		Interfaces: []ip.InterfaceConfig{
+			{Name: "peer", Remote: fmt.Sprintf("localhost:%v", portA), Addr: "10.0.0.2", RemoteAddr: "10.0.0.1"},
+		},
+		TCP: ip.TCPConfig{MSS: 300},
+	})
+	defer nodeA.Close()
+	defer nodeB.Close()
+	defer driverA.Close()
+	defer driverB.Close()
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	listener, err := driverB.Listen(addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.AcceptConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Both ends use the smaller MSS.
+	for _, info := range []tcp.SocketInfo{client.Info(), server.Info()} {
+		if info.MSS != 300 || !info.Timestamps || !info.SACK {
+			t.Errorf("expected MSS 300 with timestamps and SACK, got %v %v %v", info.MSS, info.Timestamps, info.SACK)
+		}
+	}
+
+	data := bytes.Repeat([]byte("0123456789"), 500)
+	go client.Write(data)
+	received := make([]byte, len(data))
+	if n, err := server.Read(received, uint32(len(received)), true); err != nil || int(n) != len(data) {
+		t.Fatalf("read %v bytes: %v", n, err)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("received data differs from what was sent")
+	}
+	if info := client.Info(); info.SRTT == 0 {
+		t.Error("expected RTT samples")
+	}
+}
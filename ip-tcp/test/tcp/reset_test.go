@@ -0,0 +1,85 @@
+package tcp_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+func TestConnectRefused(t *testing.T) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0)
+	nodeB, driverB := newLinkedNode(t, portB, portA, "10.0.0.2", "10.0.0.1", 0)
+	defer nodeA.Close()
+	defer nodeB.Close()
+	defer driverA.Close()
+	defer driverB.Close()
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	// Nothing listens on the port, so B resets our SYN rather than letting it time out.
+	start := time.Now()
+	if _, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000); err != tcp.ErrRefused {
+		t.Fatalf("expected ErrRefused, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= util.TCP_SYN_TIMEOUT_DURATION {
+		t.Errorf("expected the RST to fail Connect at once, took %v", elapsed)
+	}
+}
+
+func TestAbortResetsPeer(t *testing.T) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0)
+	nodeB, driverB := newLinkedNode(t, portB, portA, "10.0.0.2", "10.0.0.1", 0)
+	defer nodeA.Close()
+	defer nodeB.Close()
+	defer driverA.Close()
+	defer driverB.Close()
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	listener, err := driverB.Listen(addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.AcceptConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	readErrs := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 10), 10, true)
+		readErrs <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	client.Abort()
+	select {
+	case err := <-readErrs:
+		if err != tcp.ErrReset {
+			t.Errorf("expected pending read to fail with ErrReset, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("peer never saw the reset")
+	}
+	if _, err := server.Write([]byte("late")); err != tcp.ErrReset {
+		t.Errorf("expected a write after the reset to fail with ErrReset, got %v", err)
+	}
+	if _, err := client.Write([]byte("late")); err != tcp.ErrClosed {
+		t.Errorf("expected a write after abort to fail with ErrClosed, got %v", err)
+	}
+	for _, info := range []tcp.SocketInfo{client.Info(), server.Info()} {
+		if info.State != tcp.S_CLOSED {
+			t.Errorf("expected CLOSED, got %v", info.State)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package tcp_test
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+func TestAbortAfterRetransmits(t *testing.T) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	nodeA, driverA := startNode(t, &ip.Config{
+		Listen: fmt.Sprintf("localhost:%v", portA),
+		Interfaces: []ip.InterfaceConfig{
+			{Name: "peer", Remote: fmt.Sprintf("localhost:%v", portB), Addr: "10.0.0.1", RemoteAddr: "10.0.0.2"},
+		},
+		TCP: ip.TCPConfig{MaxRetransmits: 3},
+	})
+	defer nodeA.Close()
+	defer driverA.Close()
+	nodeB, driverB := newLinkedNode(t, portB, portA, "10.0.0.2", "10.0.0.1", 0)
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	listener, err := driverB.Listen(addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := listener.AcceptConn(); err != nil {
+		t.Fatal(err)
+	}
+	if info := client.Info(); info.RTO < util.TCP_RTO_MIN || info.RTO >= util.TCP_RTO_INITIAL {
+		t.Errorf("expected the handshake to give an RTT sample, got RTO %v", info.RTO)
+	}
+
+	// Take the peer away, so nothing we send is acked.
+	driverB.Close()
+	nodeB.Close()
+	readErrs := make(chan error, 1)
+	go func() {
+		_, err := client.Read(make([]byte, 10), 10, true)
+		readErrs <- err
+	}()
+	if _, err := client.Write([]byte("hello?")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-readErrs:
+		if err != tcp.ErrTimeout {
+			t.Errorf("expected pending read to fail with ErrTimeout, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was never aborted")
+	}
+	if _, err := client.Write([]byte("late")); err != tcp.ErrTimeout {
+		t.Errorf("expected a later write to fail with ErrTimeout, got %v", err)
+	}
+	if info := client.Info(); info.State != tcp.S_CLOSED || info.Retransmits != 3 {
+		t.Errorf("expected CLOSED after 3 retransmits, got %v after %v", info.State, info.Retransmits)
+	}
+}
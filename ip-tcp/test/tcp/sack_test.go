@@ -0,0 +1,159 @@
+package tcp_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// Collects the data segments that arrive until the sender goes quiet.
+func (peer *rawPeer) collect() []rawSegment {
+	segs := make([]rawSegment, 0)
+	for {
+		select {
+		case seg := <-peer.segs:
+			if len(seg.data) > 0 {
+				segs = append(segs, seg)
+			}
+		case <-time.After(50 * time.Millisecond):
+			return segs
+		}
+	}
+}
+
+func TestSACKRetransmitsOnlyHoles(t *testing.T) {
+	util.InitDebug(false)
+	mss := uint32(100)
+	portA, portB := freePort(t), freePort(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0)
+	defer nodeA.Close()
+	defer driverA.Close()
+	peer := newRawPeer(t, portB, portA, "10.0.0.2", "10.0.0.1")
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	conns := make(chan *tcp.Conn, 1)
+	go func() {
+		c, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000)
+		if err != nil {
+			t.Error(err)
+		}
+		conns <- c
+	}()
+	syn := peer.next(t)
+	if syn.flags&tcp.F_SYN == 0 || !syn.opts.SACKPermitted {
+		t.Fatalf("expected a SYN offering SACK, got flags %b", syn.flags)
+	}
+	// Answer slowly, so the retransmission timeout is long enough to stay out of the way.
+	time.Sleep(300 * time.Millisecond)
+	ack := func(ackNum uint32, blocks ...tcp.SACKBlock) {
+		peer.send(rawSegment{srcPort: 9000, dstPort: syn.srcPort, seq: 5001, ack: ackNum, flags: tcp.F_ACK,
+			opts: tcp.TCPOptions{SACKBlocks: blocks}})
+	}
+	peer.send(rawSegment{srcPort: 9000, dstPort: syn.srcPort, seq: 5000, ack: syn.seq + 1, flags: tcp.F_SYN | tcp.F_ACK,
+		opts: tcp.TCPOptions{MSS: uint16(mss), SACKPermitted: true}})
+	client := <-conns
+	if client == nil {
+		t.FailNow()
+	}
+	go client.Write(make([]byte, 100*mss))
+
+	// Grow the window in slow start by acking every segment, a round trip at a time, until a whole
+	// window is in flight and unacked.
+	var flight []rawSegment
+	for round := 0; round < 3; round++ {
+		flight = peer.collect()
+		if round == 2 {
+			break
+		}
+		time.Sleep(200 * time.Millisecond)
+		for _, seg := range flight {
+			ack(seg.seq + uint32(len(seg.data)))
+		}
+	}
+	if len(flight) < 12 {
+		t.Fatalf("expected at least 12 segments in flight, got %v", len(flight))
+	}
+	base := flight[0].seq
+	seg := func(i int) uint32 { return base + uint32(i)*mss }
+	sacked := make(map[uint32]bool)
+	// Gets the retransmissions among the segments sent next. SACKs drain the pipe, which also lets
+	// new data out.
+	high := seg(len(flight))
+	retransmitted := func() []rawSegment {
+		rexmits := make([]rawSegment, 0)
+		for _, sent := range peer.collect() {
+			if seqNum := sent.seq + uint32(len(sent.data)); sent.seq >= high {
+				high = seqNum
+				continue
+			}
+			rexmits = append(rexmits, sent)
+		}
+		return rexmits
+	}
+	expectRetransmits := func(step string, expected ...int) {
+		t.Helper()
+		got := retransmitted()
+		for _, rexmit := range got {
+			if sacked[rexmit.seq] {
+				t.Errorf("%v: retransmitted SACKed segment %v", step, (rexmit.seq-base)/mss)
+			}
+		}
+		if len(got) != len(expected) {
+			t.Fatalf("%v: expected %v retransmissions, got %v", step, len(expected), len(got))
+		}
+		for i, rexmit := range got {
+			if rexmit.seq != seg(expected[i]) {
+				t.Errorf("%v: expected segment %v to be retransmitted, got %v", step, expected[i], (rexmit.seq-base)/mss)
+			}
+		}
+	}
+	sack := func(blocks ...tcp.SACKBlock) {
+		for _, block := range blocks {
+			for seq := block.Left; seq != block.Right; seq += mss {
+				sacked[seq] = true
+			}
+		}
+		ack(base, blocks...)
+	}
+
+	// Segment 0 is lost. It isn't considered lost until DupThresh segments above it are SACKed.
+	sack(tcp.SACKBlock{Left: seg(1), Right: seg(2)})
+	sack(tcp.SACKBlock{Left: seg(1), Right: seg(3)})
+	expectRetransmits("two SACKed")
+	sack(tcp.SACKBlock{Left: seg(1), Right: seg(4)})
+	expectRetransmits("three SACKed", 0)
+	info := client.Info()
+	if !info.InRecovery {
+		t.Fatal("expected fast recovery")
+	}
+
+	// The last four segments sent arrive too, so everything between is lost. Only what fits in the
+	// window beside the retransmission of segment 0 is sent.
+	n := int((high - base) / mss)
+	sack(tcp.SACKBlock{Left: seg(n - 4), Right: seg(n)}, tcp.SACKBlock{Left: seg(1), Right: seg(4)})
+	limit := int(info.Cwnd/mss) - 1
+	if limit >= n-8 {
+		t.Fatalf("expected the window (%v) to be smaller than the holes, with %v in flight", info.Cwnd, n)
+	}
+	expected := make([]int, 0)
+	for i := 4; i < 4+limit; i++ {
+		expected = append(expected, i)
+	}
+	expectRetransmits("window full", expected...)
+
+	// SACKing two of the retransmissions frees room for the next hole.
+	sack(tcp.SACKBlock{Left: seg(4), Right: seg(6)}, tcp.SACKBlock{Left: seg(n - 4), Right: seg(n)}, tcp.SACKBlock{Left: seg(1), Right: seg(4)})
+	got := retransmitted()
+	if len(got) == 0 || got[0].seq != seg(4+limit) {
+		t.Errorf("expected segment %v to be retransmitted next, got %v segments", 4+limit, len(got))
+	}
+	for _, rexmit := range got {
+		if sacked[rexmit.seq] {
+			t.Errorf("retransmitted SACKed segment %v", (rexmit.seq-base)/mss)
+		}
+	}
+}
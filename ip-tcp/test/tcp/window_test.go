@@ -0,0 +1,232 @@
+package tcp_test
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	ip "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/ip"
+	tcp "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/tcp"
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+// A segment seen or sent by a rawPeer.
+type rawSegment struct {
+	srcPort uint16
+	dstPort uint16
+	seq     uint32
+	ack     uint32
+	flags   uint16
+	window  uint16
+	opts    tcp.TCPOptions
+	data    []byte
+}
+
+// A node without a TCP stack, which receives segments as they are and sends the ones the test
+// writes, so the test plays the peer exactly.
+type rawPeer struct {
+	node   *ip.Node
+	addr   net.IP
+	remote net.IP
+	segs   chan rawSegment
+}
+
+func newRawPeer(t *testing.T, port int, peerPort int, addr string, peerAddr string) *rawPeer {
+	node, err := ip.NewNodeFromConfig(&ip.Config{
+		Listen: fmt.Sprintf("localhost:%v", port),
+		Interfaces: []ip.InterfaceConfig{
+			{Name: "peer", Remote: fmt.Sprintf("localhost:%v", peerPort), Addr: addr, RemoteAddr: peerAddr},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := &rawPeer{node: node, addr: net.ParseIP(addr), remote: net.ParseIP(peerAddr), segs: make(chan rawSegment, 1024)}
+	node.RegisterHandler(6, func(_ *ip.Node, packet *ip.IPPacket, _ int) error {
+		d := packet.Data
+		headerLen := int(d[12]>>4) * 4
+		seg := rawSegment{
+			srcPort: util.Ntohs(d[0:2]),
+			dstPort: util.Ntohs(d[2:4]),
+			seq:     util.Ntohl(d[4:8]),
+			ack:     util.Ntohl(d[8:12]),
+			flags:   util.Ntohs(d[12:14]) & 0x1ff,
+			window:  util.Ntohs(d[14:16]),
+			data:    append([]byte{}, d[headerLen:]...),
+		}
+		seg.opts.Deserialize(d[20:headerLen])
+		peer.segs <- seg
+		return nil
+	})
+	node.Run(false)
+	t.Cleanup(func() { node.Close() })
+	return peer
+}
+
+// Sends a segment with a full window.
+func (peer *rawPeer) send(seg rawSegment) {
+	options := seg.opts.Serialize()
+	buf := make([]byte, 0)
+	buf = append(buf, util.Htons(seg.srcPort)...)
+	buf = append(buf, util.Htons(seg.dstPort)...)
+	buf = append(buf, util.Htonl(seg.seq)...)
+	buf = append(buf, util.Htonl(seg.ack)...)
+	buf = append(buf, util.Htons(uint16(5+len(options)/4)<<12|seg.flags)...)
+	buf = append(buf, util.Htons(65535)...)
+	buf = append(buf, 0, 0, 0, 0) // Checksum and urgent pointer.
+	buf = append(buf, options...)
+	buf = append(buf, seg.data...)
+	pseudo := append(util.Htonl(util.IP2int(peer.addr)), util.Htonl(util.IP2int(peer.remote))...)
+	pseudo = append(append(pseudo, 0, 6), util.Htons(uint16(len(buf)))...)
+	copy(buf[16:18], util.Htons(util.IPChecksum(append(pseudo, buf...))))
+	peer.node.Send(6, buf, util.DEFAULT_TTL, peer.addr, peer.remote)
+}
+
+// Waits for the next segment, failing the test if none arrives.
+func (peer *rawPeer) next(t *testing.T) rawSegment {
+	select {
+	case seg := <-peer.segs:
+		return seg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no segment arrived")
+		return rawSegment{}
+	}
+}
+
+func TestWindowScaling(t *testing.T) {
+	util.InitDebug(false)
+	portA, portB := freePort(t), freePort(t)
+	addrA, addrB := net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")
+	// A round trip long enough that a 32KB window would hold the transfer back.
+	delay := ip.Duration(5 * time.Millisecond)
+	nodeA, driverA := startNode(t, &ip.Config{
+		Listen: fmt.Sprintf("localhost:%v", portA),
+		Interfaces: []ip.InterfaceConfig{
+			{Name: "peer", Remote: fmt.Sprintf("localhost:%v", portB), Addr: "10.0.0.1", RemoteAddr: "10.0.0.2", Delay: delay},
+		},
+	})
+	nodeB, driverB := startNode(t, &ip.Config{
+		Listen: fmt.Sprintf("localhost:%v", portB),
+		Interfaces: []ip.InterfaceConfig{
+			{Name: "peer", Remote: fmt.Sprintf("localhost:%v", portA), Addr: "10.0.0.2", RemoteAddr: "10.0.0.1", Delay: delay},
+		},
+	})
+	defer nodeA.Close()
+	defer nodeB.Close()
+	defer driverA.Close()
+	defer driverB.Close()
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+
+	listener, err := driverB.Listen(addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := driverA.Connect(addrA, driverA.EphemeralPort(), addrB, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.AcceptConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The default 4MB maximum window needs a shift of 7.
+	if info := client.Info(); info.SendScale != 7 || info.ReceiveScale != 7 {
+		t.Fatalf("expected window scales of 7, got %v and %v", info.SendScale, info.ReceiveScale)
+	}
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	go client.Write(data)
+	received := make([]byte, 0, len(data))
+	buf := make([]byte, 1<<16)
+	for len(received) < len(data) {
+		n, err := server.Read(buf, uint32(len(buf)), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received = append(received, buf[:n]...)
+	}
+	if !bytes.Equal(received, data) {
+		t.Fatal("received data differs from what was sent")
+	}
+	if info := server.Info(); info.ReceiveBuffer <= util.TCP_WINDOW_SIZE {
+		t.Errorf("expected the receive buffer to grow past %v, got %v", util.TCP_WINDOW_SIZE, info.ReceiveBuffer)
+	}
+}
+
+func TestAutotuningWithoutWindowScaling(t *testing.T) {
+	util.InitDebug(false)
+	mss := uint32(1000)
+	portA, portB := freePort(t), freePort(t)
+	addrA := net.ParseIP("10.0.0.1")
+	nodeA, driverA := newLinkedNode(t, portA, portB, "10.0.0.1", "10.0.0.2", 0)
+	defer nodeA.Close()
+	defer driverA.Close()
+	peer := newRawPeer(t, portB, portA, "10.0.0.2", "10.0.0.1")
+	waitForRoute(t, nodeA, "10.0.0.2/32")
+	listener, err := driverA.Listen(addrA, 9000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The peer offers no window scaling, so A can't advertise more than 65535 bytes, and starts
+	// close enough to the end of the sequence space that the transfer wraps.
+	isn := ^uint32(0) - 1<<19
+	peer.send(rawSegment{srcPort: 5000, dstPort: 9000, seq: isn, flags: tcp.F_SYN, opts: tcp.TCPOptions{MSS: uint16(mss)}})
+	synAck := peer.next(t)
+	if synAck.flags&(tcp.F_SYN|tcp.F_ACK) != tcp.F_SYN|tcp.F_ACK || synAck.opts.WindowScaling {
+		t.Fatalf("expected a SYN-ACK without window scaling, got flags %b", synAck.flags)
+	}
+	peer.send(rawSegment{srcPort: 5000, dstPort: 9000, seq: isn + 1, ack: synAck.seq + 1, flags: tcp.F_ACK})
+	server, err := listener.AcceptConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	done := make(chan []byte, 1)
+	go func() {
+		received := make([]byte, 0, len(data))
+		buf := make([]byte, 1<<16)
+		for len(received) < len(data) {
+			n, err := server.Read(buf, uint32(len(buf)), false)
+			if err != nil {
+				break
+			}
+			received = append(received, buf[:n]...)
+		}
+		done <- received
+	}()
+	// Send as much as A's window allows, going back to the last ack if A goes quiet.
+	acked, sent, window := uint32(0), uint32(0), uint32(synAck.window)
+	for acked < uint32(len(data)) {
+		for sent < uint32(len(data)) && sent < acked+window {
+			n := util.Min(util.Min(mss, uint32(len(data))-sent), acked+window-sent)
+			peer.send(rawSegment{srcPort: 5000, dstPort: 9000, seq: isn + 1 + sent, ack: synAck.seq + 1, flags: tcp.F_ACK,
+				data: data[sent : sent+n]})
+			sent += n
+		}
+		select {
+		case seg := <-peer.segs:
+			if seg.flags&tcp.F_ACK != 0 && seg.ack-(isn+1) > acked && seg.ack-(isn+1) <= sent {
+				acked = seg.ack - (isn + 1)
+			}
+			window = uint32(seg.window)
+		case <-time.After(200 * time.Millisecond):
+			sent, window = acked, util.Max(window, 1)
+		}
+	}
+
+	select {
+	case received := <-done:
+		if !bytes.Equal(received, data) {
+			t.Fatal("received data differs from what was sent")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("not all the data was read")
+	}
+	if size := server.Info().ReceiveBuffer; !util.IsPowerOf2(size) {
+		t.Errorf("expected the receive buffer to stay a power of 2, got %v", size)
+	}
+}
@@ -0,0 +1,56 @@
+package util_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	util "github.com/brown-csci1680/ip-dcheong-nyoung/pkg/util"
+)
+
+func TestLogLevelsPerSubsystem(t *testing.T) {
+	util.InitDebug(false)
+	defer util.SetLogOutput(os.Stdout, "text")
+	quiet, chatty := util.NewLogger("quiet"), util.NewLogger("chatty")
+	if err := util.ConfigureLogging("warn,chatty=debug"); err != nil {
+		t.Fatal(err)
+	}
+	defer util.InitDebug(false)
+	var buf bytes.Buffer
+	util.SetLogOutput(&buf, "text")
+	quiet.Info("hidden")
+	quiet.Warn("shown")
+	chatty.With("conn", 7).Debug("also shown", "note", "two words")
+	out := buf.String()
+	if strings.Contains(out, "hidden") || !strings.Contains(out, "WARN  quiet shown") {
+		t.Fatalf("unexpected output %q", out)
+	}
+	if !strings.Contains(out, `also shown conn=7 note="two words"`) {
+		t.Fatalf("expected fields in %q", out)
+	}
+	if err := util.ConfigureLogging("nosuch=debug"); err == nil {
+		t.Fatal("expected an error for an unknown subsystem")
+	}
+	if err := util.ConfigureLogging("chatty=loud"); err == nil {
+		t.Fatal("expected an error for an unknown level")
+	}
+}
+
+func TestLogJSON(t *testing.T) {
+	util.InitDebug(false)
+	defer util.SetLogOutput(os.Stdout, "text")
+	var buf bytes.Buffer
+	if err := util.SetLogOutput(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	util.NewLogger("json").Info("hello", "port", 80)
+	var msg map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg["level"] != "info" || msg["subsystem"] != "json" || msg["msg"] != "hello" || msg["port"] != float64(80) {
+		t.Fatalf("unexpected message %v", msg)
+	}
+}